@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Access levels of ACL rules
+const (
+	// Everybody including anonymous visitors
+	AccessPublic = "public"
+	// Any logged in user
+	AccessAuthenticated = "authenticated"
+	// Only listed users and members of listed groups
+	AccessUsers = "users"
+)
+
+// aclRule grants access to a folder or media file and everything under it.
+// The most specific rule matching a path wins.
+type aclRule struct {
+	// Path prefix relative to the gallery root e.g. kif/2023, empty for the whole gallery
+	Path   string   `json:"path"`
+	Access string   `json:"access"`
+	Users  []string `json:"users"`
+	Groups []string `json:"groups"`
+	// Hidden paths are left out of listings outside of them but can still be
+	// opened by direct link by users that have access
+	Hidden bool `json:"hidden"`
+}
+
+type aclFile struct {
+	// Access of paths no rule matches, authenticated if not set
+	Default string    `json:"default"`
+	Rules   []aclRule `json:"rules"`
+}
+
+// aclStore holds access rules loaded from JSON file. Admins are not
+// restricted by the rules.
+type aclStore struct {
+	mu    sync.RWMutex
+	file  string
+	def   aclRule
+	rules []aclRule
+}
+
+func newACLStore(file string) *aclStore {
+	return &aclStore{file: file, def: aclRule{Access: AccessAuthenticated}}
+}
+
+// cleanACLPath normalizes gallery path so it can be compared by prefix. Root is empty string.
+func cleanACLPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// isWithin reports whether p is prefix or a path under prefix
+func isWithin(p string, prefix string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// load (re)reads ACL file. Missing file means every path has the default access.
+func (s *aclStore) load() error {
+	af := aclFile{}
+
+	data, err := os.ReadFile(s.file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading ACL file %s: %w", s.file, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &af); err != nil {
+			return fmt.Errorf("error parsing ACL file %s: %w", s.file, err)
+		}
+	}
+
+	def := aclRule{Access: af.Default}
+	if def.Access == "" {
+		def.Access = AccessAuthenticated
+	}
+	if err := def.validate(); err != nil {
+		return fmt.Errorf("ACL default: %w", err)
+	}
+
+	rules := []aclRule{}
+	seen := make(map[string]bool)
+	for _, rule := range af.Rules {
+		rule.Path = cleanACLPath(rule.Path)
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("ACL rule %q: %w", rule.Path, err)
+		}
+		if seen[rule.Path] {
+			return fmt.Errorf("duplicate ACL rule %q", rule.Path)
+		}
+		seen[rule.Path] = true
+		rules = append(rules, rule)
+	}
+
+	// Most specific rules first so the first match wins
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Path) > len(rules[j].Path)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.def = def
+	s.rules = rules
+
+	return nil
+}
+
+func (rule aclRule) validate() error {
+	switch rule.Access {
+	case AccessPublic, AccessAuthenticated:
+	case AccessUsers:
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return errors.New("users access requires users or groups")
+		}
+	default:
+		return fmt.Errorf("unknown access %q", rule.Access)
+	}
+	return nil
+}
+
+// allows reports whether user (nil for anonymous) has access under the rule
+func (rule aclRule) allows(u *User) bool {
+	if u.isAdmin() {
+		return true
+	}
+
+	switch rule.Access {
+	case AccessPublic:
+		return true
+	case AccessAuthenticated:
+		return u != nil
+	case AccessUsers:
+		if u == nil {
+			return false
+		}
+		if slices.Contains(rule.Users, u.Name) {
+			return true
+		}
+		for _, g := range u.Groups {
+			if slices.Contains(rule.Groups, g) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rule returns the most specific rule matching p
+func (s *aclStore) rule(p string) aclRule {
+	p = cleanACLPath(p)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rule := range s.rules {
+		if isWithin(p, rule.Path) {
+			return rule
+		}
+	}
+	return s.def
+}
+
+// canRead reports whether user can open media or folder at p
+func (s *aclStore) canRead(u *User, p string) bool {
+	if s == nil {
+		return true
+	}
+	return s.rule(p).allows(u)
+}
+
+// canBrowse reports whether user can open folder dir. Folders the user has
+// no access to can still be browsed to reach subfolders the user has access to.
+func (s *aclStore) canBrowse(u *User, dir string) bool {
+	if s.canRead(u, dir) {
+		return true
+	}
+
+	dir = cleanACLPath(dir)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rule := range s.rules {
+		if rule.Path != dir && isWithin(rule.Path, dir) && rule.allows(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVisible reports whether entry p is shown to user in listing of folder dir
+func (s *aclStore) isVisible(u *User, dir string, p string, isDir bool) bool {
+	if s == nil {
+		return true
+	}
+
+	if isDir && !s.canBrowse(u, p) || !isDir && !s.canRead(u, p) {
+		return false
+	}
+
+	rule := s.rule(p)
+	if rule.Hidden && !u.isAdmin() && !isWithin(cleanACLPath(dir), rule.Path) {
+		return false
+	}
+
+	return true
+}
+
+// requestACL returns user, access rules and share link of the request.
+// Requests that did not pass through authMiddleware are not restricted.
+func requestACL(r *http.Request) (*User, *aclStore, *shareToken) {
+	ra := requestAuthFromContext(r)
+	if ra == nil {
+		return nil, nil, nil
+	}
+	return ra.user, ra.acl, ra.share
+}
+
+// Share links give access to everything under the shared path, including
+// hidden media. Deleted media in the trash is only accessible to admins.
+
+func canRead(r *http.Request, p string) bool {
+	u, acl, share := requestACL(r)
+	if isTrashPath(p) {
+		return u.isAdmin()
+	}
+	return share.allows(p) || acl.canRead(u, p)
+}
+
+func canBrowse(r *http.Request, dir string) bool {
+	u, acl, share := requestACL(r)
+	if isTrashPath(dir) {
+		return u.isAdmin()
+	}
+	return share.allows(dir) || acl.canBrowse(u, dir)
+}
+
+func isVisible(r *http.Request, dir string, p string, isDir bool) bool {
+	u, acl, share := requestACL(r)
+	if isTrashPath(p) {
+		return u.isAdmin()
+	}
+	return share.allows(p) || acl.isVisible(u, dir, p, isDir)
+}
+
+// canDownload reports whether folder dir can be downloaded as archive.
+// Share links allow it only if they were created with download permission.
+func canDownload(r *http.Request, dir string) bool {
+	u, acl, share := requestACL(r)
+	if isTrashPath(dir) {
+		return u.isAdmin()
+	}
+	if acl.canBrowse(u, dir) {
+		return true
+	}
+	return share.allows(dir) && share.Download
+}
+
+// visibleItems returns indexed media the user can see in a view of folder
+func visibleItems(r *http.Request, folder string, items []MediaItem) []MediaItem {
+	u, acl, share := requestACL(r)
+	if acl == nil && share == nil {
+		return items
+	}
+
+	visible := []MediaItem{}
+	for _, it := range items {
+		if share.allows(it.Path) || acl.isVisible(u, folder, it.Path, false) {
+			visible = append(visible, it)
+		}
+	}
+	return visible
+}
+
+// isAnonymous reports whether request is made by a visitor that is not logged in
+func isAnonymous(r *http.Request) bool {
+	ra := requestAuthFromContext(r)
+	return ra != nil && ra.user == nil
+}
+
+// denyAccess asks anonymous visitors to log in and forbids the rest
+func denyAccess(w http.ResponseWriter, r *http.Request) {
+	ra := requestAuthFromContext(r)
+	if ra != nil && ra.user == nil {
+		ra.auth.challenge(w, r)
+		return
+	}
+	writeError(w, http.StatusForbidden, "Forbidden")
+}
+
+// requireReadable protects static files of the assets file server
+func requireReadable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !canRead(r, r.URL.Path) {
+			denyAccess(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
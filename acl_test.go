@@ -0,0 +1,287 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// staticAuth authenticates every request as the same user
+type staticAuth struct {
+	user *User
+}
+
+func (a staticAuth) authenticate(r *http.Request) *User {
+	return a.user
+}
+
+func (a staticAuth) challenge(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+}
+
+func newTestACL(t *testing.T, data string) *aclStore {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "acl.json")
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	acl := newACLStore(file)
+	if err := acl.load(); err != nil {
+		t.Fatal(err)
+	}
+	return acl
+}
+
+const testACL = `{
+  "default": "authenticated",
+  "rules": [
+    {"path": "kif", "access": "public"},
+    {"path": "/kif/private/", "access": "users", "users": ["kif"]},
+    {"path": "snay", "access": "users", "groups": ["family"]},
+    {"path": "snay/drafts", "access": "users", "groups": ["family"], "hidden": true}
+  ]
+}`
+
+var (
+	kifUser    = &User{Name: "kif", Role: RoleViewer}
+	snayUser   = &User{Name: "snay", Role: RoleViewer, Groups: []string{"family"}}
+	otherUser  = &User{Name: "other", Role: RoleViewer}
+	adminUser  = &User{Name: "admin", Role: RoleAdmin}
+	everyUsers = map[string]*User{"anonymous": nil, "kif": kifUser, "snay": snayUser, "other": otherUser, "admin": adminUser}
+)
+
+func TestACLCanRead(t *testing.T) {
+	acl := newTestACL(t, testACL)
+
+	tests := []struct {
+		path    string
+		allowed []string
+	}{
+		{"kif", []string{"anonymous", "kif", "snay", "other", "admin"}},
+		{"kif/2023/post_1700000000_0.jpg", []string{"anonymous", "kif", "snay", "other", "admin"}},
+		// Rule prefix must match whole path segments
+		{"kiffy/2023/post_1700000000_0.jpg", []string{"kif", "snay", "other", "admin"}},
+		{"kif/private", []string{"kif", "admin"}},
+		{"kif/private/post_1700000000_0.jpg", []string{"kif", "admin"}},
+		{"kif/privateer/post_1700000000_0.jpg", []string{"anonymous", "kif", "snay", "other", "admin"}},
+		// Paths are normalized before matching
+		{"/kif//private/", []string{"kif", "admin"}},
+		{"kif/2023/../private/a.jpg", []string{"kif", "admin"}},
+		{"../kif/private/a.jpg", []string{"kif", "admin"}},
+		{"snay/2023/post_1700000000_0.jpg", []string{"snay", "admin"}},
+		{"snay/drafts/post_1700000000_0.jpg", []string{"snay", "admin"}},
+		{"Snay/2023/post_1700000000_0.jpg", []string{"kif", "snay", "other", "admin"}},
+		{"", []string{"kif", "snay", "other", "admin"}},
+		{".", []string{"kif", "snay", "other", "admin"}},
+	}
+
+	for _, tt := range tests {
+		for name, u := range everyUsers {
+			expected := false
+			for _, a := range tt.allowed {
+				expected = expected || a == name
+			}
+			if got := acl.canRead(u, tt.path); got != expected {
+				t.Errorf("canRead(%s, %q) = %v, expected %v", name, tt.path, got, expected)
+			}
+		}
+	}
+}
+
+func TestACLCanBrowse(t *testing.T) {
+	acl := newTestACL(t, testACL)
+
+	// Anonymous visitors can get to the public folder through the root
+	if !acl.canBrowse(nil, ".") {
+		t.Error("expected anonymous visitor to browse the root")
+	}
+	if acl.canBrowse(nil, "snay") {
+		t.Error("expected anonymous visitor not to browse snay")
+	}
+	if acl.canBrowse(otherUser, "kif/private") {
+		t.Error("expected other user not to browse kif/private")
+	}
+
+	// Nothing is public without rules
+	empty := newACLStore(filepath.Join(t.TempDir(), "missing.json"))
+	if err := empty.load(); err != nil {
+		t.Fatal(err)
+	}
+	if empty.canBrowse(nil, ".") {
+		t.Error("expected gallery to require login without ACL file")
+	}
+	if !empty.canBrowse(otherUser, "kif") {
+		t.Error("expected logged in user to browse without ACL file")
+	}
+}
+
+func TestACLIsVisible(t *testing.T) {
+	acl := newTestACL(t, testACL)
+
+	tests := []struct {
+		name     string
+		user     *User
+		dir      string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"public folder in root", nil, ".", "kif", true, true},
+		{"private folder in root", nil, ".", "snay", true, false},
+		{"private subfolder", otherUser, "kif", "kif/private", true, false},
+		{"own private subfolder", kifUser, "kif", "kif/private", true, true},
+		{"hidden folder in parent", snayUser, "snay", "snay/drafts", true, false},
+		{"hidden folder for admin", adminUser, "snay", "snay/drafts", true, true},
+		{"media inside hidden folder", snayUser, "snay/drafts", "snay/drafts/post_1700000000_0.jpg", false, true},
+		{"hidden media in root view", snayUser, "", "snay/drafts/post_1700000000_0.jpg", false, false},
+		{"hidden media in sibling view", snayUser, "snay/draft", "snay/drafts/post_1700000000_0.jpg", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acl.isVisible(tt.user, tt.dir, tt.path, tt.isDir); got != tt.expected {
+				t.Errorf("isVisible = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestACLLoadErrors(t *testing.T) {
+	tests := []string{
+		`{"default": "nobody"}`,
+		`{"rules": [{"path": "kif", "access": "secret"}]}`,
+		`{"rules": [{"path": "kif", "access": "users"}]}`,
+		`{"rules": [{"path": "kif", "access": "public"}, {"path": "/kif/", "access": "authenticated"}]}`,
+		`{"rules": [`,
+	}
+
+	for _, data := range tests {
+		file := filepath.Join(t.TempDir(), "acl.json")
+		os.WriteFile(file, []byte(data), 0o600)
+		if err := newACLStore(file).load(); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+}
+
+func newACLTestServer(t *testing.T) http.Handler {
+	t.Helper()
+
+	mockFs := fstest.MapFS{
+		"kif/2023/post_1700000000_0.jpg":         &fstest.MapFile{Data: []byte("kif")},
+		"kif/private/post_1700000001_0.jpg":      &fstest.MapFile{Data: []byte("kif private")},
+		"snay/2023/post_1700000002_0.jpg":        &fstest.MapFile{Data: []byte("snay")},
+		"snay/drafts/post_1700000003_0.jpg":      &fstest.MapFile{Data: []byte("snay draft")},
+		"snay/drafts/post_1700000003_0.jpg.json": &fstest.MapFile{Data: []byte("{}")},
+	}
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+	index.readExif = false
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	galleryMux := http.NewServeMux()
+	galleryMux.HandleFunc("/", makeGalleryRootHandler(mockFs, sizeFn, index, nil, nil))
+	mux.HandleFunc(urlPrefix+"/download/", makeDownloadHandler(mockFs, readFile, sizeFn, nil, nil))
+	mux.HandleFunc(urlPrefix+"/timeline/", makeTimelineHandler(index))
+	mux.HandleFunc(urlPrefix+"/api/geojson/", makeGeoJSONHandler(index))
+	mux.Handle(assetsRoute+"/", http.StripPrefix(assetsRoute, requireReadable(http.FileServer(http.FS(mockFs)))))
+	mux.Handle(urlPrefix+"/", http.StripPrefix(urlPrefix, galleryMux))
+
+	return mux
+}
+
+func TestACLHandlers(t *testing.T) {
+	mux := newACLTestServer(t)
+	acl := newTestACL(t, testACL)
+
+	tests := []struct {
+		name         string
+		user         *User
+		path         string
+		expectedCode int
+		contains     []string
+		excludes     []string
+	}{
+		{"root lists public folder", nil, urlPrefix + "/", http.StatusOK, []string{urlPrefix + "/kif"}, []string{urlPrefix + "/snay"}},
+		{"root lists group folder", snayUser, urlPrefix + "/", http.StatusOK, []string{urlPrefix + "/kif", urlPrefix + "/snay"}, nil},
+		{"private folder asks for login", nil, urlPrefix + "/snay", http.StatusUnauthorized, nil, nil},
+		{"private folder is forbidden", otherUser, urlPrefix + "/snay", http.StatusForbidden, nil, nil},
+		{"private subfolder not listed", otherUser, urlPrefix + "/kif", http.StatusOK, []string{urlPrefix + "/kif/2023"}, []string{urlPrefix + "/kif/private"}},
+		{"hidden folder not listed", snayUser, urlPrefix + "/snay", http.StatusOK, []string{urlPrefix + "/snay/2023"}, []string{urlPrefix + "/snay/drafts"}},
+		{"hidden folder by direct link", snayUser, urlPrefix + "/snay/drafts", http.StatusOK, []string{"post_1700000003_0.jpg"}, nil},
+		{"private media in player", otherUser, urlPrefix + "/kif/private/post_1700000001_0.jpg", http.StatusForbidden, nil, nil},
+		{"public media in player", nil, urlPrefix + "/kif/2023/post_1700000000_0.jpg", http.StatusOK, nil, nil},
+		{"private download", otherUser, urlPrefix + "/download/kif/private", http.StatusForbidden, nil, nil},
+		{"private asset", otherUser, assetsRoute + "/kif/private/post_1700000001_0.jpg", http.StatusForbidden, nil, nil},
+		{"private sidecar asset", nil, assetsRoute + "/snay/drafts/post_1700000003_0.jpg.json", http.StatusUnauthorized, nil, nil},
+		{"public asset", nil, assetsRoute + "/kif/2023/post_1700000000_0.jpg", http.StatusOK, []string{"kif"}, nil},
+		{"timeline of the root", otherUser, urlPrefix + "/timeline/", http.StatusOK, []string{"post_1700000000_0.jpg"}, []string{"post_1700000001_0.jpg", "post_1700000002_0.jpg"}},
+		{"timeline of private folder", nil, urlPrefix + "/timeline/snay", http.StatusUnauthorized, nil, nil},
+		{"geojson of private folder", otherUser, urlPrefix + "/api/geojson/snay", http.StatusForbidden, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			authMiddleware(staticAuth{user: tt.user}, acl, mux).ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedCode, rr.Code, rr.Body.String())
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(rr.Body.String(), s) {
+					t.Errorf("expected response to contain %q", s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(rr.Body.String(), s) {
+					t.Errorf("expected response not to contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestACLDownloadSkipsPrivateFiles(t *testing.T) {
+	mux := newACLTestServer(t)
+	acl := newTestACL(t, `{"rules": [{"path": "kif", "access": "public"}, {"path": "kif/2023/post_1700000000_0.jpg", "access": "users", "users": ["kif"]}]}`)
+
+	zipNames := func(u *User) []string {
+		rr := httptest.NewRecorder()
+		authMiddleware(staticAuth{user: u}, acl, mux).ServeHTTP(rr, httptest.NewRequest("GET", urlPrefix+"/download/kif/2023", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rr.Code)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	if names := zipNames(nil); len(names) != 0 {
+		t.Errorf("expected empty archive for anonymous visitor, got %v", names)
+	}
+	if names := zipNames(kifUser); len(names) != 1 {
+		t.Errorf("expected private file in archive of its owner, got %v", names)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Gallery folder under which virtual albums are listed
+const albumsFolder = "albums"
+
+// Album is a curated collection of media defined in the albums file
+// independently of the storage folder layout. Media can be listed
+// explicitly, selected by a saved query or both.
+type Album struct {
+	// Name used in album URL e.g. best-of-2023
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	// Explicit list of media paths relative to the gallery root
+	Media []string    `json:"media"`
+	Query *AlbumQuery `json:"query"`
+}
+
+// AlbumQuery selects media from the media index. All set fields must match.
+type AlbumQuery struct {
+	// Only media under this folder e.g. kif/2023
+	Folder string `json:"folder"`
+	// File name keywords, same syntax as gallery "filter" parameter e.g. "post reel"
+	Filter string `json:"filter"`
+	// Case insensitive substring of the caption
+	Caption string `json:"caption"`
+	// Capture date range in YYYY-MM-DD format, both ends inclusive
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type albumsFile struct {
+	Albums []Album `json:"albums"`
+}
+
+// albumStore holds albums loaded from JSON file and resolves their media from the index
+type albumStore struct {
+	mu     sync.RWMutex
+	file   string
+	index  *mediaIndex
+	albums []Album
+}
+
+func newAlbumStore(file string, index *mediaIndex) *albumStore {
+	return &albumStore{file: file, index: index}
+}
+
+// load (re)reads albums file. Missing file means there are no albums.
+func (s *albumStore) load() error {
+	albums := []Album{}
+
+	data, err := os.ReadFile(s.file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading albums file %s: %w", s.file, err)
+	}
+
+	if err == nil {
+		af := albumsFile{}
+		if err := json.Unmarshal(data, &af); err != nil {
+			return fmt.Errorf("error parsing albums file %s: %w", s.file, err)
+		}
+
+		seen := make(map[string]bool)
+		for _, a := range af.Albums {
+			if a.Name == "" || strings.ContainsAny(a.Name, "/\\") || a.Name == "." || a.Name == ".." {
+				return fmt.Errorf("invalid album name %q", a.Name)
+			}
+			if seen[a.Name] {
+				return fmt.Errorf("duplicate album name %q", a.Name)
+			}
+			seen[a.Name] = true
+
+			if a.Title == "" {
+				a.Title = a.Name
+			}
+			if a.Query != nil {
+				if _, _, err := a.Query.dateRange(); err != nil {
+					return fmt.Errorf("album %s: %w", a.Name, err)
+				}
+			}
+			albums = append(albums, a)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.albums = albums
+
+	return nil
+}
+
+func (s *albumStore) list() []Album {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.albums
+}
+
+func (s *albumStore) get(name string) (Album, bool) {
+	for _, a := range s.list() {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Album{}, false
+}
+
+// dateRange parses From and To dates. Zero time means the range is open.
+func (q AlbumQuery) dateRange() (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+
+	if q.From != "" {
+		from, err = time.ParseInLocation("2006-01-02", q.From, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid query date %q: %w", q.From, err)
+		}
+	}
+	if q.To != "" {
+		to, err = time.ParseInLocation("2006-01-02", q.To, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid query date %q: %w", q.To, err)
+		}
+		// Include the whole last day
+		to = to.AddDate(0, 0, 1)
+	}
+
+	return from, to, nil
+}
+
+// run returns indexed media matching the query, newest first
+func (q AlbumQuery) run(index *mediaIndex) []MediaItem {
+	from, to, _ := q.dateRange()
+	caption := strings.ToLower(q.Caption)
+
+	matched := []MediaItem{}
+	for _, it := range filterMediaItems(index.all(q.Folder), q.Filter) {
+		if !from.IsZero() && it.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !it.Time.Before(to) {
+			continue
+		}
+		if caption != "" && !strings.Contains(strings.ToLower(it.Caption), caption) {
+			continue
+		}
+		matched = append(matched, it)
+	}
+
+	return matched
+}
+
+// items resolves album media. Explicitly listed media goes first in the
+// listed order followed by query results. Paths missing from the index are skipped.
+func (s *albumStore) items(a Album) []MediaItem {
+	items := []MediaItem{}
+	seen := make(map[string]bool)
+
+	for _, p := range a.Media {
+		it, ok := s.index.get(p)
+		if !ok || seen[it.Path] {
+			continue
+		}
+		seen[it.Path] = true
+		items = append(items, it)
+	}
+
+	if a.Query != nil {
+		for _, it := range a.Query.run(s.index) {
+			if seen[it.Path] {
+				continue
+			}
+			seen[it.Path] = true
+			items = append(items, it)
+		}
+	}
+
+	return items
+}
+
+// folder returns album as virtual folder by its gallery path e.g. albums/best-of-2023
+func (s *albumStore) folder(p string) (virtualFolder, bool) {
+	p = strings.Trim(p, "/")
+	name := strings.TrimPrefix(p, albumsFolder+"/")
+	if name == p || strings.Contains(name, "/") {
+		return virtualFolder{}, false
+	}
+
+	a, ok := s.get(name)
+	if !ok {
+		return virtualFolder{}, false
+	}
+
+	return virtualFolder{Path: p, Title: a.Title, Items: s.items(a)}, true
+}
+
+// virtualFolder is a list of media from arbitrary storage folders
+// presented as a single gallery folder
+type virtualFolder struct {
+	// URL path of the folder relative to the gallery root e.g. albums/best-of-2023
+	Path  string
+	Title string
+	Items []MediaItem
+}
+
+// visibleTo returns the folder without media the user of the request can not see
+func (vf virtualFolder) visibleTo(r *http.Request) virtualFolder {
+	vf.Items = visibleItems(r, "", vf.Items)
+	return vf
+}
+
+// media returns gallery media of the folder with page URLs pointing inside of it,
+// so the player walks through the folder and not the storage folder of each file
+func (vf virtualFolder) media() []Media {
+	media := []Media{}
+	for _, it := range vf.Items {
+		m := makeIndexedMedia(it)
+		m.RelativePageURL = path.Join(vf.Path, it.Path)
+		m.AbsolutePageURL = path.Join(urlPrefix, m.RelativePageURL)
+		media = append(media, m)
+	}
+	return media
+}
+
+// zipEntries returns archive entries of the folder
+func (vf virtualFolder) zipEntries() []zipEntry {
+	paths := []string{}
+	for _, it := range vf.Items {
+		paths = append(paths, it.Path)
+	}
+	return uniqueZipEntries(paths)
+}
+
+// serve renders the folder as gallery or, if mediaPath is set, one of its media in the player
+func (vf virtualFolder) serve(w http.ResponseWriter, r *http.Request, mediaPath string, backLink string) {
+	vf = vf.visibleTo(r)
+	media := vf.media()
+
+	if mediaPath == "" {
+		var total int64
+		for _, it := range vf.Items {
+			total += it.Size
+		}
+		galleryHandler(media, vf.Title, backLink, vf.Path, formatSize(total), false)(w, r)
+		return
+	}
+
+	for i, it := range vf.Items {
+		if it.Path != mediaPath {
+			continue
+		}
+
+		li := LinkedMedia{Cur: media[i]}
+		if i > 0 {
+			li.Prev = media[i-1]
+		}
+		if i < len(media)-1 {
+			li.Next = media[i+1]
+		}
+
+		playerHandler(li, li.Cur.FileName, path.Join(urlPrefix, vf.Path))(w, r)
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+// virtualDirEntry is a fs.DirEntry of virtual folder shown in storage listing
+type virtualDirEntry struct {
+	name string
+}
+
+func (e virtualDirEntry) Name() string               { return e.name }
+func (e virtualDirEntry) IsDir() bool                { return true }
+func (e virtualDirEntry) Type() fs.FileMode          { return fs.ModeDir }
+func (e virtualDirEntry) Info() (fs.FileInfo, error) { return nil, fs.ErrNotExist }
+
+// makeAlbumsHandler serves albums list, album galleries and album player
+// pages e.g. /albums, /albums/best-of-2023, /albums/best-of-2023/kif/2023/post_1_0.jpg
+func makeAlbumsHandler(albums *albumStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isAnonymous(r) {
+			denyAccess(w, r)
+			return
+		}
+
+		p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/"+albumsFolder), "/")
+
+		if p == "" {
+			media := []Media{}
+			for _, a := range albums.list() {
+				// Albums with nothing the user can see are not listed
+				if len(visibleItems(r, "", albums.items(a))) == 0 {
+					continue
+				}
+				m := makeMedia(path.Join(albumsFolder, a.Name), assetsRoute, urlPrefix)
+				m.DirName = a.Title
+				media = append(media, m)
+			}
+			galleryHandler(media, "Albums", urlPrefix, albumsFolder, formatSize(0), false)(w, r)
+			return
+		}
+
+		name, mediaPath, _ := strings.Cut(p, "/")
+		vf, ok := albums.folder(path.Join(albumsFolder, name))
+		if !ok {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+
+		vf.serve(w, r, mediaPath, path.Join(urlPrefix, albumsFolder))
+	}
+}
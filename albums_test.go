@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestAlbums(t *testing.T, albumsJSON string) (*albumStore, fstest.MapFS) {
+	t.Helper()
+
+	mockFs := fstest.MapFS{
+		"kif/2023/post_1700000000_0.jpg":       &fstest.MapFile{Data: []byte("aaaa")},
+		"kif/2023/post_1700000000_1.jpg":       &fstest.MapFile{Data: []byte("bb")},
+		"kif/2022/story_1650000000_0.mp4":      &fstest.MapFile{Data: []byte("c")},
+		"kif/2022/story_1650000000_0.mp4.json": &fstest.MapFile{Data: []byte(`{"caption": "Beach day"}`)},
+		"snay/2023/post_1700000000_0.jpg":      &fstest.MapFile{Data: []byte("ddd")},
+	}
+	readFile := func(p string) ([]byte, error) { return mockFs[p].Data, nil }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "albums.json")
+	if albumsJSON != "" {
+		os.WriteFile(file, []byte(albumsJSON), 0644)
+	}
+
+	albums := newAlbumStore(file, index)
+	if err := albums.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return albums, mockFs
+}
+
+func TestAlbumStoreLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     bool
+		count   int
+	}{
+		{"missing file", "", false, 0},
+		{"valid", `{"albums": [{"name": "a"}, {"name": "b", "query": {"from": "2023-01-01"}}]}`, false, 2},
+		{"invalid json", `{"albums": [`, true, 0},
+		{"duplicate name", `{"albums": [{"name": "a"}, {"name": "a"}]}`, true, 0},
+		{"name with slash", `{"albums": [{"name": "a/b"}]}`, true, 0},
+		{"invalid date", `{"albums": [{"name": "a", "query": {"to": "2023/01/01"}}]}`, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "albums.json")
+			if tt.content != "" {
+				os.WriteFile(file, []byte(tt.content), 0644)
+			}
+			albums := newAlbumStore(file, &mediaIndex{})
+
+			err := albums.load()
+			if tt.err != (err != nil) {
+				t.Fatalf("load() error = %v, want error %v", err, tt.err)
+			}
+			if got := len(albums.list()); got != tt.count {
+				t.Errorf("loaded %d albums, want %d", got, tt.count)
+			}
+		})
+	}
+}
+
+func TestAlbumItems(t *testing.T) {
+	albums, _ := newTestAlbums(t, `{"albums": [
+		{"name": "picked", "title": "Picked", "media": ["snay/2023/post_1700000000_0.jpg", "kif/missing.jpg", "kif/2022/story_1650000000_0.mp4"]},
+		{"name": "beach", "query": {"caption": "beach"}},
+		{"name": "kif-2023", "query": {"folder": "kif", "from": "2023-01-01", "to": "2023-12-31"}},
+		{"name": "mixed", "media": ["snay/2023/post_1700000000_0.jpg"], "query": {"filter": "post"}}
+	]}`)
+
+	tests := []struct {
+		album    string
+		expected []string
+	}{
+		{"picked", []string{"snay/2023/post_1700000000_0.jpg", "kif/2022/story_1650000000_0.mp4"}},
+		{"beach", []string{"kif/2022/story_1650000000_0.mp4"}},
+		{"kif-2023", []string{"kif/2023/post_1700000000_0.jpg", "kif/2023/post_1700000000_1.jpg"}},
+		{"mixed", []string{"snay/2023/post_1700000000_0.jpg", "kif/2023/post_1700000000_0.jpg", "kif/2023/post_1700000000_1.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.album, func(t *testing.T) {
+			vf, ok := albums.folder("albums/" + tt.album)
+			if !ok {
+				t.Fatal("album not found")
+			}
+			got := []string{}
+			for _, it := range vf.Items {
+				got = append(got, it.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("items = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	if _, ok := albums.folder("albums/unknown"); ok {
+		t.Error("unknown album must not be found")
+	}
+	if _, ok := albums.folder("kif/picked"); ok {
+		t.Error("album must only be found under albums folder")
+	}
+}
+
+func TestAlbumsHandler(t *testing.T) {
+	albums, _ := newTestAlbums(t, `{"albums": [{"name": "picked", "title": "Picked", "media": [
+		"kif/2023/post_1700000000_0.jpg", "snay/2023/post_1700000000_0.jpg", "kif/2022/story_1650000000_0.mp4"
+	]}]}`)
+	handler := makeAlbumsHandler(albums)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/albums", nil))
+	if !strings.Contains(w.Body.String(), "Picked") {
+		t.Error("albums list does not contain album title")
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/albums/picked", nil))
+	if !strings.Contains(w.Body.String(), urlPrefix+"/albums/picked/snay/2023/post_1700000000_0.jpg") {
+		t.Error("album page must link media inside of the album")
+	}
+
+	// Player prev/next walk album order across storage folders
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/albums/picked/snay/2023/post_1700000000_0.jpg", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `class="nav-prev" href="`+urlPrefix+`/albums/picked/kif/2023/post_1700000000_0.jpg`) {
+		t.Error("player prev link does not point to previous album media")
+	}
+	if !strings.Contains(body, `class="nav-next" href="`+urlPrefix+`/albums/picked/kif/2022/story_1650000000_0.mp4`) {
+		t.Error("player next link does not point to next album media")
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/albums/picked/kif/2023/post_1700000000_1.jpg", nil))
+	if w.Code != 404 {
+		t.Errorf("media outside of album returned %d, want 404", w.Code)
+	}
+}
+
+func TestDownloadHandler_Album(t *testing.T) {
+	albums, mockFs := newTestAlbums(t, `{"albums": [{"name": "picked", "media": [
+		"kif/2023/post_1700000000_0.jpg", "snay/2023/post_1700000000_0.jpg"
+	]}]}`)
+	readFile := func(p string) ([]byte, error) { return mockFs[p].Data, nil }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	w := httptest.NewRecorder()
+	makeDownloadHandler(mockFs, readFile, sizeFn, albums, nil)(w, httptest.NewRequest("GET", urlPrefix+"/download/albums/picked", nil))
+
+	if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Content-Length = %s, body is %d bytes", cl, w.Body.Len())
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+
+	// Same file names from different folders must not collide
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	expected := "kif_2023_post_1700000000_0.jpg,snay_2023_post_1700000000_0.jpg"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("zip entries = %v, want %s", names, expected)
+	}
+}
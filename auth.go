@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed web/gallery/login.css
+var loginCss []byte
+
+// Authentication modes selected with CCG_AUTH_MODE
+const (
+	AuthNone    = "none"
+	AuthBasic   = "basic"
+	AuthSession = "session"
+	AuthProxy   = "proxy"
+)
+
+// User roles. Viewers can browse and download, admins can also manage the gallery.
+const (
+	RoleViewer = "viewer"
+	RoleAdmin  = "admin"
+)
+
+// Name of the cookie holding signed session token
+const sessionCookie = "ccg_session"
+
+// How long login session is valid
+const sessionTTL = 30 * 24 * time.Hour
+
+// User is an authenticated user of the request
+type User struct {
+	Name   string
+	Role   string
+	Groups []string
+}
+
+func (u *User) isAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
+}
+
+// userEntry is a line of the users file: name:bcrypt_hash:role:groups
+type userEntry struct {
+	Name   string
+	Hash   string
+	Role   string
+	Groups []string
+}
+
+func (u userEntry) user() *User {
+	return &User{Name: u.Name, Role: u.Role, Groups: u.Groups}
+}
+
+// authProvider identifies the user making request
+type authProvider interface {
+	// authenticate returns user of the request or nil if request is anonymous
+	authenticate(r *http.Request) *User
+	// challenge responds to request that requires authentication
+	challenge(w http.ResponseWriter, r *http.Request)
+}
+
+// requestAuth is authentication state of a request set by authMiddleware
+type requestAuth struct {
+	// nil for anonymous visitors
+	user *User
+	auth authProvider
+	acl  *aclStore
+	// Share link the anonymous visitor opened, nil if none
+	share *shareToken
+}
+
+type requestAuthContextKey struct{}
+
+func requestAuthFromContext(r *http.Request) *requestAuth {
+	ra, _ := r.Context().Value(requestAuthContextKey{}).(*requestAuth)
+	return ra
+}
+
+// userFromRequest returns user set by authMiddleware
+func userFromRequest(r *http.Request) *User {
+	if ra := requestAuthFromContext(r); ra != nil {
+		return ra.user
+	}
+	return nil
+}
+
+// loadUsers reads users file. Each line is name:bcrypt_hash:role:groups
+// where groups are comma separated, lines starting with # are comments.
+// Role defaults to viewer.
+func loadUsers(file string) (map[string]userEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading users file: %w", err)
+	}
+	defer f.Close()
+
+	users := make(map[string]userEntry)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.Split(text, ":")
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, fmt.Errorf("users file %s:%d: expected name:hash:role", file, line)
+		}
+
+		u := userEntry{Name: parts[0], Hash: parts[1], Role: RoleViewer}
+		if len(parts) > 2 && parts[2] != "" {
+			u.Role = parts[2]
+		}
+		if u.Role != RoleViewer && u.Role != RoleAdmin {
+			return nil, fmt.Errorf("users file %s:%d: unknown role %q", file, line, u.Role)
+		}
+		if len(parts) > 3 {
+			for _, g := range strings.Split(parts[3], ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					u.Groups = append(u.Groups, g)
+				}
+			}
+		}
+
+		users[u.Name] = u
+	}
+
+	return users, scanner.Err()
+}
+
+// Hash compared against for unknown users, generated on first use
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+// checkPassword verifies password against user bcrypt hash. Unknown users
+// still pay bcrypt cost so response time does not reveal which names exist.
+func checkPassword(users map[string]userEntry, name string, password string) *User {
+	u, ok := users[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte(password)) != nil {
+		return nil
+	}
+	return u.user()
+}
+
+// noAuth keeps the gallery public. Everybody is an admin, as it was before
+// authentication existed.
+type noAuth struct{}
+
+func (noAuth) authenticate(r *http.Request) *User {
+	return &User{Role: RoleAdmin}
+}
+
+func (noAuth) challenge(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusForbidden, "Forbidden")
+}
+
+// basicAuth checks HTTP Basic credentials on every request
+type basicAuth struct {
+	users map[string]userEntry
+}
+
+func (a basicAuth) authenticate(r *http.Request) *User {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	return checkPassword(a.users, name, password)
+}
+
+func (a basicAuth) challenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Gallery", charset="UTF-8"`)
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+}
+
+// sessionAuth authenticates with login page and a signed session cookie.
+// Cookie value is base64(name)|expiry|hmac so no server side session storage is needed.
+type sessionAuth struct {
+	users  map[string]userEntry
+	secret []byte
+}
+
+func (a sessionAuth) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a sessionAuth) token(name string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(name)) + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "|" + a.sign(payload)
+}
+
+func (a sessionAuth) authenticate(r *http.Request) *User {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	i := strings.LastIndex(c.Value, "|")
+	if i < 0 {
+		return nil
+	}
+	payload, sig := c.Value[:i], c.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(a.sign(payload))) {
+		return nil
+	}
+
+	encName, expiry, _ := strings.Cut(payload, "|")
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return nil
+	}
+	name, err := base64.RawURLEncoding.DecodeString(encName)
+	if err != nil {
+		return nil
+	}
+
+	// Role is looked up on every request so changes in users file apply
+	// to existing sessions and removed users are logged out
+	u, ok := a.users[string(name)]
+	if !ok {
+		return nil
+	}
+	return u.user()
+}
+
+func (a sessionAuth) challenge(w http.ResponseWriter, r *http.Request) {
+	// Browsers navigating to pages are sent to the login page, API clients get 401
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, urlPrefix+"/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+}
+
+// proxyAuth trusts user name set by a reverse proxy (e.g. nginx with
+// auth_request or oauth2-proxy) in a request header. The header is only
+// accepted from trusted proxy addresses. Roles come from users file if it
+// is configured, password hashes are ignored in this mode.
+type proxyAuth struct {
+	header  string
+	trusted []*net.IPNet
+	users   map[string]userEntry
+}
+
+func (a proxyAuth) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range a.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a proxyAuth) authenticate(r *http.Request) *User {
+	name := strings.TrimSpace(r.Header.Get(a.header))
+	if name == "" || !a.isTrusted(r.RemoteAddr) {
+		return nil
+	}
+
+	if a.users == nil {
+		return &User{Name: name, Role: RoleViewer}
+	}
+	u, ok := a.users[name]
+	if !ok {
+		return nil
+	}
+	return u.user()
+}
+
+func (a proxyAuth) challenge(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+}
+
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// newAuthProvider configures authentication from environment variables
+func newAuthProvider() (authProvider, error) {
+	mode := getEnv("CCG_AUTH_MODE", AuthNone)
+	if mode == AuthNone {
+		return noAuth{}, nil
+	}
+
+	usersFile := getEnv("CCG_AUTH_USERS_FILE", "")
+	var users map[string]userEntry
+	if usersFile != "" {
+		var err error
+		users, err = loadUsers(usersFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch mode {
+	case AuthBasic, AuthSession:
+		if users == nil {
+			return nil, fmt.Errorf("%s authentication requires CCG_AUTH_USERS_FILE", mode)
+		}
+		if mode == AuthBasic {
+			return basicAuth{users: users}, nil
+		}
+
+		secret := []byte(getEnv("CCG_AUTH_SECRET", ""))
+		if len(secret) == 0 {
+			fmt.Println("[-] CCG_AUTH_SECRET is not set, sessions will not survive server restart")
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		return sessionAuth{users: users, secret: secret}, nil
+
+	case AuthProxy:
+		trusted, err := parseCIDRs(getEnv("CCG_AUTH_PROXY_TRUSTED", "127.0.0.1,::1"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CCG_AUTH_PROXY_TRUSTED: %w", err)
+		}
+		return proxyAuth{
+			header:  getEnv("CCG_AUTH_PROXY_HEADER", "X-Forwarded-User"),
+			trusted: trusted,
+			users:   users,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown CCG_AUTH_MODE %q", mode)
+}
+
+// authMiddleware identifies the user and stores it in request context
+// together with access rules. Anonymous requests are let through, handlers
+// check access rules and ask for login where it is required.
+func authMiddleware(auth authProvider, acl *aclStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ra := &requestAuth{user: auth.authenticate(r), auth: auth, acl: acl}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestAuthContextKey{}, ra)))
+	})
+}
+
+// requireAdmin allows only admins to call the handler
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !userFromRequest(r).isAdmin() {
+			denyAccess(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireMethod rejects requests with other HTTP methods
+func requireMethod(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireSameOrigin rejects changes requested by pages of other sites. With
+// basic or session authentication the browser sends credentials with a form
+// posted from anywhere. Browsers send Sec-Fetch-Site or Origin with such
+// requests, requests without them like curl are let through.
+func requireSameOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next(w, r)
+			return
+		}
+		if !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, "Cross-origin request denied")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// sameOrigin reports whether the request did not come from a page of another site
+func sameOrigin(r *http.Request) bool {
+	// "none" is sent when the user opens the URL themselves
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+type LoginPage struct {
+	Title  string
+	Next   string
+	Error  string
+	Styles template.CSS
+}
+
+// safeNext only allows redirects to pages of this gallery after login
+func safeNext(next string) string {
+	if strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return urlPrefix
+	}
+	if next == urlPrefix || strings.HasPrefix(next, urlPrefix+"/") || strings.HasPrefix(next, urlPrefix+"?") {
+		return next
+	}
+	return urlPrefix
+}
+
+// makeLoginHandler renders login form and starts a session on successful login
+func makeLoginHandler(auth sessionAuth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := LoginPage{
+			Title:  "Login",
+			Next:   safeNext(r.FormValue("next")),
+			Styles: template.CSS(append(loginCss, globalCss...)),
+		}
+
+		if r.Method == http.MethodPost {
+			u := checkPassword(auth.users, r.FormValue("username"), r.FormValue("password"))
+			if u != nil {
+				expires := time.Now().Add(sessionTTL)
+				http.SetCookie(w, &http.Cookie{
+					Name:     sessionCookie,
+					Value:    auth.token(u.Name, expires),
+					Path:     urlPrefix,
+					Expires:  expires,
+					HttpOnly: true,
+					Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+					SameSite: http.SameSiteLaxMode,
+				})
+				http.Redirect(w, r, page.Next, http.StatusSeeOther)
+				return
+			}
+			page.Error = "Invalid user name or password"
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+
+		err := tmpl.ExecuteTemplate(w, "login.html", page)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     urlPrefix,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, urlPrefix+"/login", http.StatusSeeOther)
+}
+
+// hashPasswordCommand reads password from stdin and prints users file line for it
+func hashPasswordCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: gallery hash-password <user> [viewer|admin] < password.txt")
+	}
+	role := RoleViewer
+	if len(args) > 1 {
+		role = args[1]
+	}
+	if role != RoleViewer && role != RoleAdmin {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("error reading password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s:%s:%s\n", args[0], hash, role)
+	return nil
+}
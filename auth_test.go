@@ -0,0 +1,348 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testUsers(t *testing.T) map[string]userEntry {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "users")
+	data := "# gallery users\n\n" +
+		"kif:" + string(hash) + ":admin\n" +
+		"snay:" + string(hash) + "\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := loadUsers(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestLoadUsers(t *testing.T) {
+	users := testUsers(t)
+
+	if len(users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(users))
+	}
+	if users["kif"].Role != RoleAdmin {
+		t.Errorf("expected kif to be admin, got %q", users["kif"].Role)
+	}
+	if users["snay"].Role != RoleViewer {
+		t.Errorf("expected default viewer role, got %q", users["snay"].Role)
+	}
+
+	for _, line := range []string{"kif", ":hash:admin", "kif:hash:owner"} {
+		file := filepath.Join(t.TempDir(), "users")
+		os.WriteFile(file, []byte(line+"\n"), 0o600)
+		if _, err := loadUsers(file); err == nil {
+			t.Errorf("expected error for line %q", line)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	auth := basicAuth{users: testUsers(t)}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		expected string
+	}{
+		{"valid", "kif", "secret", "kif"},
+		{"wrong password", "kif", "wrong", ""},
+		{"unknown user", "nobody", "secret", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/gallery", nil)
+			req.SetBasicAuth(tt.user, tt.password)
+
+			u := auth.authenticate(req)
+			if tt.expected == "" && u != nil {
+				t.Errorf("expected anonymous request, got %q", u.Name)
+			}
+			if tt.expected != "" && (u == nil || u.Name != tt.expected) {
+				t.Errorf("expected user %q, got %v", tt.expected, u)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	auth.challenge(rr, httptest.NewRequest("GET", "/gallery", nil))
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected 401 with WWW-Authenticate, got %d", rr.Code)
+	}
+}
+
+func TestSessionAuth(t *testing.T) {
+	auth := sessionAuth{users: testUsers(t), secret: []byte("test secret")}
+
+	withCookie := func(value string) *http.Request {
+		req := httptest.NewRequest("GET", "/gallery", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+		return req
+	}
+
+	token := auth.token("kif", time.Now().Add(time.Hour))
+	u := auth.authenticate(withCookie(token))
+	if u == nil || u.Name != "kif" || u.Role != RoleAdmin {
+		t.Fatalf("expected admin kif, got %v", u)
+	}
+
+	tampered := strings.Replace(token, token[:3], "c25", 1)
+	if u := auth.authenticate(withCookie(tampered)); u != nil {
+		t.Errorf("expected tampered token to be rejected, got %v", u)
+	}
+
+	other := sessionAuth{users: auth.users, secret: []byte("other secret")}
+	if u := other.authenticate(withCookie(token)); u != nil {
+		t.Errorf("expected token signed with other secret to be rejected, got %v", u)
+	}
+
+	expired := auth.token("kif", time.Now().Add(-time.Minute))
+	if u := auth.authenticate(withCookie(expired)); u != nil {
+		t.Errorf("expected expired token to be rejected, got %v", u)
+	}
+
+	removed := auth.token("nobody", time.Now().Add(time.Hour))
+	if u := auth.authenticate(withCookie(removed)); u != nil {
+		t.Errorf("expected token of unknown user to be rejected, got %v", u)
+	}
+}
+
+func TestLoginHandler(t *testing.T) {
+	auth := sessionAuth{users: testUsers(t), secret: []byte("test secret")}
+	handler := makeLoginHandler(auth)
+
+	form := url.Values{"username": {"snay"}, "password": {"secret"}, "next": {urlPrefix + "/kif"}}
+	req := httptest.NewRequest("POST", urlPrefix+"/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != urlPrefix+"/kif" {
+		t.Fatalf("expected redirect to %s, got %d %s", urlPrefix+"/kif", rr.Code, rr.Header().Get("Location"))
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie {
+		t.Fatalf("expected session cookie, got %v", cookies)
+	}
+	req = httptest.NewRequest("GET", urlPrefix, nil)
+	req.AddCookie(cookies[0])
+	if u := auth.authenticate(req); u == nil || u.Name != "snay" {
+		t.Errorf("expected session of snay, got %v", u)
+	}
+
+	form.Set("password", "wrong")
+	req = httptest.NewRequest("POST", urlPrefix+"/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized || len(rr.Result().Cookies()) != 0 {
+		t.Errorf("expected 401 without cookie, got %d", rr.Code)
+	}
+}
+
+func TestProxyAuth(t *testing.T) {
+	trusted, err := parseCIDRs("127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := proxyAuth{header: "X-Forwarded-User", trusted: trusted}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		user       string
+		expected   bool
+	}{
+		{"trusted", "127.0.0.1:5000", "kif", true},
+		{"trusted network", "10.1.2.3:5000", "kif", true},
+		{"untrusted", "192.168.1.10:5000", "kif", false},
+		{"no header", "127.0.0.1:5000", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/gallery", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.user != "" {
+				req.Header.Set("X-Forwarded-User", tt.user)
+			}
+
+			u := auth.authenticate(req)
+			if (u != nil) != tt.expected {
+				t.Errorf("expected authenticated %v, got %v", tt.expected, u)
+			}
+			if u != nil && u.Role != RoleViewer {
+				t.Errorf("expected viewer role without users file, got %q", u.Role)
+			}
+		})
+	}
+
+	// With users file only listed users are let in and get their role
+	auth.users = testUsers(t)
+	req := httptest.NewRequest("GET", "/gallery", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	req.Header.Set("X-Forwarded-User", "kif")
+	if u := auth.authenticate(req); !u.isAdmin() {
+		t.Errorf("expected admin kif, got %v", u)
+	}
+	req.Header.Set("X-Forwarded-User", "nobody")
+	if u := auth.authenticate(req); u != nil {
+		t.Errorf("expected unknown user to be rejected, got %v", u)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := sessionAuth{users: testUsers(t), secret: []byte("test secret")}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(urlPrefix+"/update", requireAdmin(requireMethod(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("updated"))
+	})))
+	mux.HandleFunc(urlPrefix+"/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("login"))
+	})
+	mux.HandleFunc(urlPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		if !canBrowse(r, strings.TrimPrefix(r.URL.Path, urlPrefix)) {
+			denyAccess(w, r)
+			return
+		}
+		w.Write([]byte(userFromRequest(r).Name))
+	})
+
+	// Without ACL file the whole gallery requires login
+	acl := newACLStore(filepath.Join(t.TempDir(), "acl.json"))
+	if err := acl.load(); err != nil {
+		t.Fatal(err)
+	}
+	handler := authMiddleware(auth, acl, mux)
+
+	session := func(name string) *http.Cookie {
+		return &http.Cookie{Name: sessionCookie, Value: auth.token(name, time.Now().Add(time.Hour))}
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		accept       string
+		cookie       *http.Cookie
+		expectedCode int
+		expectedBody string
+	}{
+		{"browser redirected to login", "GET", urlPrefix + "/kif", "text/html", nil, http.StatusFound, ""},
+		{"api client unauthorized", "GET", urlPrefix + "/kif", "application/json", nil, http.StatusUnauthorized, ""},
+		{"login page is public", "GET", urlPrefix + "/login", "text/html", nil, http.StatusOK, "login"},
+		{"user in context", "GET", urlPrefix + "/kif", "text/html", session("snay"), http.StatusOK, "snay"},
+		{"viewer cannot update", "POST", urlPrefix + "/update", "", session("snay"), http.StatusForbidden, ""},
+		{"update requires post", "GET", urlPrefix + "/update", "", session("kif"), http.StatusMethodNotAllowed, ""},
+		{"admin can update", "POST", urlPrefix + "/update", "", session("kif"), http.StatusOK, "updated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Accept", tt.accept)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Errorf("expected status %d, got %d", tt.expectedCode, rr.Code)
+			}
+			if tt.expectedBody != "" && rr.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestNoAuthIsAdmin(t *testing.T) {
+	handler := authMiddleware(noAuth{}, nil, requireAdmin(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", urlPrefix+"/update", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected everybody to be admin without authentication, got %d", rr.Code)
+	}
+}
+
+func TestRequireSameOrigin(t *testing.T) {
+	handler := requireSameOrigin(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		expectedCode int
+	}{
+		{"curl without browser headers", "POST", nil, http.StatusOK},
+		{"same origin fetch", "POST", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://example.com"}, http.StatusOK},
+		{"form posted from another site", "POST", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"another subdomain", "PUT", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"same origin without fetch metadata", "POST", map[string]string{"Origin": "http://example.com"}, http.StatusOK},
+		{"other origin without fetch metadata", "POST", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"opaque origin", "POST", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"reading from another site", "GET", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, urlPrefix+"/api/media/kif/2023", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Errorf("expected %d, got %d", tt.expectedCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	tests := []struct {
+		next     string
+		expected string
+	}{
+		{urlPrefix + "/kif/2023", urlPrefix + "/kif/2023"},
+		{"", urlPrefix},
+		{"https://evil.example", urlPrefix},
+		{"//evil.example", urlPrefix},
+		{urlPrefix + "evil", urlPrefix},
+		{urlPrefix + "\\evil", urlPrefix},
+	}
+
+	for _, tt := range tests {
+		if got := safeNext(tt.next); got != tt.expected {
+			t.Errorf("safeNext(%q) = %q, expected %q", tt.next, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed web/gallery/dashboard.css
+var dashboardCss []byte
+
+//go:embed web/gallery/dashboard.js
+var dashboardJs []byte
+
+// Number of recent errors kept for the dashboard
+const maxRecentErrors = 50
+
+// LoggedError is an error that happened while serving the gallery
+type LoggedError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// errorLog keeps the most recent errors, older ones are only in the server log
+type errorLog struct {
+	mu     sync.Mutex
+	errors []LoggedError
+}
+
+var recentErrors = &errorLog{}
+
+func (l *errorLog) add(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, LoggedError{Time: time.Now(), Message: msg})
+	if len(l.errors) > maxRecentErrors {
+		l.errors = l.errors[len(l.errors)-maxRecentErrors:]
+	}
+}
+
+// list returns logged errors, most recent first
+func (l *errorLog) list() []LoggedError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]LoggedError, 0, len(l.errors))
+	for i := len(l.errors) - 1; i >= 0; i-- {
+		list = append(list, l.errors[i])
+	}
+	return list
+}
+
+// logError prints error to the server log and keeps it for the dashboard
+func logError(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Printf("[-] %s\n", msg)
+	recentErrors.add(msg)
+}
+
+// Number of archives being downloaded right now
+var activeDownloads atomic.Int64
+
+// DashboardStats is the state of the running server
+type DashboardStats struct {
+	Backend string    `json:"backend"`
+	Started time.Time `json:"started"`
+	// Files in the storage and their size, without the trash
+	Objects      int    `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	TrashObjects int    `json:"trashObjects"`
+	TrashBytes   int64  `json:"trashBytes"`
+	StorageError string `json:"storageError,omitempty"`
+	// Media in the index and when it was last refreshed
+	Media        int       `json:"media"`
+	IndexUpdated time.Time `json:"indexUpdated"`
+	IndexError   string    `json:"indexError,omitempty"`
+	// Entries of in-memory caches. The gallery has no thumbnail cache, media
+	// is served as it is stored.
+	ExifCache    int `json:"exifCache"`
+	SidecarCache int `json:"sidecarCache"`
+	CRCCache     int `json:"crcCache"`
+
+	ActiveDownloads int64         `json:"activeDownloads"`
+	Errors          []LoggedError `json:"errors"`
+}
+
+func (s DashboardStats) BytesText() string {
+	return formatSize(s.Bytes)
+}
+
+func (s DashboardStats) TrashBytesText() string {
+	return formatSize(s.TrashBytes)
+}
+
+// dashboard collects server state for admins
+type dashboard struct {
+	// Human readable storage backend e.g. local:/srv/media or s3://bucket/folder
+	backend string
+	fSys    fs.FS
+	sizeFn  func(string) int64
+	index   *mediaIndex
+	started time.Time
+}
+
+func newDashboard(backend string, fSys fs.FS, sizeFn func(string) int64, index *mediaIndex) *dashboard {
+	return &dashboard{backend: backend, fSys: fSys, sizeFn: sizeFn, index: index, started: time.Now()}
+}
+
+// stats walks the storage listing and reads state of the index and caches
+func (d *dashboard) stats() DashboardStats {
+	st := d.index.status()
+	stats := DashboardStats{
+		Backend:         d.backend,
+		Started:         d.started,
+		Media:           st.items,
+		IndexUpdated:    st.updatedAt,
+		ExifCache:       st.exifCached,
+		SidecarCache:    st.sidecarCached,
+		CRCCache:        zipCRCs.len(),
+		ActiveDownloads: activeDownloads.Load(),
+		Errors:          recentErrors.list(),
+	}
+	if st.err != nil {
+		stats.IndexError = st.err.Error()
+	}
+
+	err := fs.WalkDir(d.fSys, ".", func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+
+		if isTrashPath(p) {
+			stats.TrashObjects++
+			stats.TrashBytes += d.sizeFn(p)
+		} else {
+			stats.Objects++
+			stats.Bytes += d.sizeFn(p)
+		}
+		return nil
+	})
+	if err != nil {
+		stats.StorageError = err.Error()
+	}
+
+	return stats
+}
+
+// purgeCaches empties in-memory caches, they are filled again by the next
+// refresh and download
+func (d *dashboard) purgeCaches() {
+	d.index.purgeCaches()
+	zipCRCs.purge()
+	if err := zipCRCs.save(); err != nil {
+		logError("Error saving CRC cache: %v", err)
+	}
+	fmt.Println("[+] Purged caches")
+}
+
+type DashboardPage struct {
+	Title     string
+	Stats     DashboardStats
+	BackLink  string
+	URLPrefix string
+	// Purging caches is only available with authentication
+	CanPurge bool
+	Styles   template.CSS
+	JS       template.JS
+}
+
+// makeDashboardHandler renders state of the server with refresh and cache purge buttons
+func makeDashboardHandler(d *dashboard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := DashboardPage{
+			Title:     "Dashboard",
+			Stats:     d.stats(),
+			BackLink:  urlPrefix,
+			URLPrefix: urlPrefix,
+			CanPurge:  canModify(r),
+			Styles:    template.CSS(append(dashboardCss, globalCss...)),
+			JS:        template.JS(dashboardJs),
+		}
+
+		err := tmpl.ExecuteTemplate(w, "dashboard.html", page)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+	}
+}
+
+// makeDashboardApiHandler returns server state on GET /api/dashboard and
+// empties caches on POST /api/dashboard/purge
+func makeDashboardApiHandler(d *dashboard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch strings.Trim(strings.TrimPrefix(r.URL.Path, urlPrefix+"/api/dashboard"), "/") {
+		case "":
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+				return
+			}
+			writeJSON(w, d.stats())
+
+		case "purge":
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+				return
+			}
+			d.purgeCaches()
+			writeJSON(w, map[string]any{"purged": true})
+
+		default:
+			writeError(w, http.StatusNotFound, "Not Found")
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardStats(t *testing.T) {
+	media, _ := newTestMediaManager(t)
+	media.remove("snay/post_1700000002_0.jpg")
+	d := newDashboard("local:/srv/media", media.fSys, media.index.sizeFn, media.index)
+
+	activeDownloads.Add(2)
+	defer activeDownloads.Add(-2)
+
+	rr := httptest.NewRecorder()
+	makeDashboardApiHandler(d)(rr, httptest.NewRequest("GET", urlPrefix+"/api/dashboard", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected stats, got %d", rr.Code)
+	}
+
+	stats := DashboardStats{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Backend != "local:/srv/media" || stats.Media != 3 || stats.ActiveDownloads != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	// Media with sidecar and two more media, the deleted one is in the trash
+	if stats.Objects != 4 || stats.TrashObjects != 1 || stats.TrashBytes != 1 {
+		t.Errorf("unexpected storage stats %+v", stats)
+	}
+	if stats.SidecarCache != 1 || stats.IndexUpdated.IsZero() {
+		t.Errorf("unexpected index stats %+v", stats)
+	}
+}
+
+func TestDashboardPurge(t *testing.T) {
+	media, _ := newTestMediaManager(t)
+	d := newDashboard("local:/srv/media", media.fSys, media.index.sizeFn, media.index)
+	zipCRCs.set("kif/2023/post_1700000000_0.jpg", 1, time.Time{}, 1)
+
+	handler := makeDashboardApiHandler(d)
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", urlPrefix+"/api/dashboard/purge", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected purge to need POST, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", urlPrefix+"/api/dashboard/purge", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected caches to be purged, got %d", rr.Code)
+	}
+	if stats := d.stats(); stats.SidecarCache != 0 || stats.CRCCache != 0 {
+		t.Errorf("expected empty caches, got %+v", stats)
+	}
+
+	// Purged caches are filled again by refresh
+	if err := media.index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := d.stats(); stats.SidecarCache != 1 {
+		t.Errorf("expected refresh to read sidecar again, got %+v", stats)
+	}
+}
+
+func TestDashboardPage(t *testing.T) {
+	media, _ := newTestMediaManager(t)
+	d := newDashboard("local:/srv/media", media.fSys, media.index.sizeFn, media.index)
+	writeError(httptest.NewRecorder(), http.StatusInternalServerError, "dashboard test failure")
+
+	tests := []struct {
+		name          string
+		auth          authProvider
+		expectedCode  int
+		expectedPurge bool
+	}{
+		{"admin", staticAuth{user: adminUser}, http.StatusOK, true},
+		{"without authentication", noAuth{}, http.StatusOK, false},
+		{"viewer", staticAuth{user: kifUser}, http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := authMiddleware(tt.auth, nil, requireAdmin(makeDashboardHandler(d)))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", urlPrefix+"/dashboard", nil))
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("expected %d, got %d %s", tt.expectedCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			for _, s := range []string{"local:/srv/media", "dashboard test failure"} {
+				if !strings.Contains(rr.Body.String(), s) {
+					t.Errorf("expected dashboard to contain %q", s)
+				}
+			}
+			if got := strings.Contains(rr.Body.String(), "Purge caches"); got != tt.expectedPurge {
+				t.Errorf("expected purge button %v, got %v", tt.expectedPurge, got)
+			}
+		})
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	l := &errorLog{}
+	for i := 0; i < maxRecentErrors+5; i++ {
+		l.add(fmt.Sprintf("error %d", i))
+	}
+
+	list := l.list()
+	if len(list) != maxRecentErrors {
+		t.Fatalf("expected %d errors, got %d", maxRecentErrors, len(list))
+	}
+	if list[0].Message != fmt.Sprintf("error %d", maxRecentErrors+4) || list[len(list)-1].Message != "error 5" {
+		t.Errorf("expected most recent errors first, got %q ... %q", list[0].Message, list[len(list)-1].Message)
+	}
+}
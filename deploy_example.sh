@@ -5,7 +5,7 @@
 rm -rf bin
 
 # Build for linux amd64
-GOOS=linux GOARCH=amd64 go build -o bin/gallery server.go
+GOOS=linux GOARCH=amd64 go build -o bin/gallery .
 
 # Copy server binary to remote host
 scp bin/gallery codercat:~/gallery/gallery.new
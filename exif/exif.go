@@ -0,0 +1,248 @@
+// Package exif implements a minimal reader for the EXIF tags the gallery
+// cares about. It only understands JPEG files (APP1 segment) and raw TIFF
+// blobs, which covers what phone and camera exports produce.
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoExif is returned when the input does not contain an EXIF block
+var ErrNoExif = errors.New("exif: no exif data found")
+
+const (
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// Layout of EXIF date strings e.g. 2024:05:17 18:04:11
+const dateLayout = "2006:01:02 15:04:05"
+
+// Data holds the subset of EXIF tags extracted from a file
+type Data struct {
+	// Capture time from DateTimeOriginal falling back to DateTime.
+	// EXIF dates carry no zone so they are interpreted in local time.
+	DateTime time.Time
+	// GPS coordinates in decimal degrees, only valid if HasLocation is set
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+}
+
+// Decode extracts EXIF data from JPEG file content
+func Decode(b []byte) (*Data, error) {
+	tiff, err := findTiff(b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTiff(tiff)
+}
+
+// findTiff returns the TIFF structure embedded in JPEG APP1 segment.
+// If the input already starts with a TIFF header it is returned as is.
+func findTiff(b []byte) ([]byte, error) {
+	if isTiffHeader(b) {
+		return b, nil
+	}
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, ErrNoExif
+	}
+
+	pos := 2
+	for pos+4 <= len(b) {
+		if b[pos] != 0xFF {
+			return nil, ErrNoExif
+		}
+		marker := b[pos+1]
+		// Start of scan, the rest is image data
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, ErrNoExif
+		}
+		size := int(binary.BigEndian.Uint16(b[pos+2:]))
+		if size < 2 || pos+2+size > len(b) {
+			return nil, ErrNoExif
+		}
+		segment := b[pos+4 : pos+2+size]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return segment[6:], nil
+		}
+		pos += 2 + size
+	}
+
+	return nil, ErrNoExif
+}
+
+func isTiffHeader(b []byte) bool {
+	if len(b) < 8 {
+		return false
+	}
+	return string(b[:4]) == "II*\x00" || string(b[:4]) == "MM\x00*"
+}
+
+type reader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag    uint16
+	typ    uint16
+	count  uint32
+	offset uint32 // value or offset to the value
+	raw    []byte // 4 raw bytes of the value field
+}
+
+func decodeTiff(b []byte) (*Data, error) {
+	if !isTiffHeader(b) {
+		return nil, ErrNoExif
+	}
+
+	r := reader{b: b, order: binary.LittleEndian}
+	if b[0] == 'M' {
+		r.order = binary.BigEndian
+	}
+
+	ifd0, err := r.readIFD(r.order.Uint32(b[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Data{}
+
+	if e, ok := ifd0[tagDateTime]; ok {
+		d.DateTime = r.date(e)
+	}
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		sub, err := r.readIFD(e.offset)
+		if err == nil {
+			if e, ok := sub[tagDateTimeOriginal]; ok {
+				if t := r.date(e); !t.IsZero() {
+					d.DateTime = t
+				}
+			}
+		}
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		gps, err := r.readIFD(e.offset)
+		if err == nil {
+			r.readLocation(gps, d)
+		}
+	}
+
+	return d, nil
+}
+
+// readLocation converts GPS IFD degrees/minutes/seconds rationals into decimal degrees
+func (r reader) readLocation(gps map[uint16]ifdEntry, d *Data) {
+	lat, okLat := r.degrees(gps[tagGPSLatitude])
+	lon, okLon := r.degrees(gps[tagGPSLongitude])
+	if !okLat || !okLon {
+		return
+	}
+
+	if r.ascii(gps[tagGPSLatitudeRef]) == "S" {
+		lat = -lat
+	}
+	if r.ascii(gps[tagGPSLongitudeRef]) == "W" {
+		lon = -lon
+	}
+
+	// Cameras without a fix often write zeros
+	if lat == 0 && lon == 0 {
+		return
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return
+	}
+
+	d.Latitude, d.Longitude, d.HasLocation = lat, lon, true
+}
+
+// degrees reads 3 RATIONAL values (degrees, minutes, seconds)
+func (r reader) degrees(e ifdEntry) (float64, bool) {
+	if e.typ != 5 || e.count != 3 {
+		return 0, false
+	}
+	start := int(e.offset)
+	if start < 0 || start+24 > len(r.b) {
+		return 0, false
+	}
+
+	var v [3]float64
+	for i := range v {
+		num := r.order.Uint32(r.b[start+i*8:])
+		den := r.order.Uint32(r.b[start+i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		v[i] = float64(num) / float64(den)
+	}
+
+	return v[0] + v[1]/60 + v[2]/3600, true
+}
+
+func (r reader) readIFD(offset uint32) (map[uint16]ifdEntry, error) {
+	start := int(offset)
+	if start < 8 || start+2 > len(r.b) {
+		return nil, fmt.Errorf("exif: invalid IFD offset %d", offset)
+	}
+
+	count := int(r.order.Uint16(r.b[start:]))
+	if start+2+count*12 > len(r.b) {
+		return nil, fmt.Errorf("exif: truncated IFD at offset %d", offset)
+	}
+
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		p := start + 2 + i*12
+		e := ifdEntry{
+			tag:    r.order.Uint16(r.b[p:]),
+			typ:    r.order.Uint16(r.b[p+2:]),
+			count:  r.order.Uint32(r.b[p+4:]),
+			offset: r.order.Uint32(r.b[p+8:]),
+			raw:    r.b[p+8 : p+12],
+		}
+		entries[e.tag] = e
+	}
+
+	return entries, nil
+}
+
+// ascii returns value of ASCII typed entry
+func (r reader) ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	var v []byte
+	if e.count <= 4 {
+		v = e.raw[:e.count]
+	} else {
+		end := int(e.offset) + int(e.count)
+		if end > len(r.b) || end < int(e.offset) {
+			return ""
+		}
+		v = r.b[e.offset:end]
+	}
+	return strings.TrimRight(string(v), "\x00 ")
+}
+
+func (r reader) date(e ifdEntry) time.Time {
+	t, err := time.ParseInLocation(dateLayout, r.ascii(e), time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
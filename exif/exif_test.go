@@ -0,0 +1,207 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+type testTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // inline value (<= 4 bytes) or data stored after the IFD
+}
+
+// makeTiff builds little endian TIFF blob with IFD0 and sub IFDs
+// referenced from IFD0 by pointer tags e.g. Exif or GPS IFD
+func makeTiff(ifd0 []testTag, subs map[uint16][]testTag) []byte {
+	writeIFD := func(tags []testTag, start uint32) []byte {
+		var ifd, data bytes.Buffer
+		dataOffset := start + 2 + uint32(len(tags))*12 + 4
+		binary.Write(&ifd, binary.LittleEndian, uint16(len(tags)))
+		for _, t := range tags {
+			binary.Write(&ifd, binary.LittleEndian, t.tag)
+			binary.Write(&ifd, binary.LittleEndian, t.typ)
+			binary.Write(&ifd, binary.LittleEndian, t.count)
+			if len(t.value) <= 4 {
+				v := make([]byte, 4)
+				copy(v, t.value)
+				ifd.Write(v)
+				continue
+			}
+			binary.Write(&ifd, binary.LittleEndian, dataOffset+uint32(data.Len()))
+			data.Write(t.value)
+		}
+		binary.Write(&ifd, binary.LittleEndian, uint32(0))
+		ifd.Write(data.Bytes())
+		return ifd.Bytes()
+	}
+
+	// Add pointer tags with placeholder values to know IFD0 size
+	tags := append([]testTag{}, ifd0...)
+	pointers := []uint16{}
+	for tag := range subs {
+		pointers = append(pointers, tag)
+	}
+	sort.Slice(pointers, func(i, j int) bool { return pointers[i] < pointers[j] })
+	for _, tag := range pointers {
+		tags = append(tags, testTag{tag: tag, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+
+	offset := uint32(8 + len(writeIFD(tags, 8)))
+	var rest bytes.Buffer
+	for i, tag := range pointers {
+		tags[len(ifd0)+i].value = binary.LittleEndian.AppendUint32(nil, offset+uint32(rest.Len()))
+		rest.Write(writeIFD(subs[tag], offset+uint32(rest.Len())))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	buf.Write(writeIFD(tags, 8))
+	buf.Write(rest.Bytes())
+	return buf.Bytes()
+}
+
+func asciiTag(tag uint16, s string) testTag {
+	return testTag{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+// wrapJpeg embeds TIFF blob into minimal JPEG APP1 segment
+func wrapJpeg(tiff []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	buf.Write([]byte{0xFF, 0xE1})
+	binary.Write(&buf, binary.BigEndian, uint16(len(payload)+2))
+	buf.Write(payload)
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected time.Time
+		err      bool
+	}{
+		{
+			name:     "date time original in exif ifd",
+			data:     wrapJpeg(makeTiff([]testTag{asciiTag(tagDateTime, "2020:01:01 00:00:00")}, map[uint16][]testTag{tagExifIFD: {asciiTag(tagDateTimeOriginal, "2023:11:14 22:13:20")}})),
+			expected: time.Date(2023, 11, 14, 22, 13, 20, 0, time.Local),
+		},
+		{
+			name:     "fallback to ifd0 date time",
+			data:     wrapJpeg(makeTiff([]testTag{asciiTag(tagDateTime, "2020:01:02 03:04:05")}, nil)),
+			expected: time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local),
+		},
+		{
+			name:     "raw tiff",
+			data:     makeTiff([]testTag{asciiTag(tagDateTime, "2021:06:07 08:09:10")}, nil),
+			expected: time.Date(2021, 6, 7, 8, 9, 10, 0, time.Local),
+		},
+		{
+			name: "jpeg without exif",
+			data: []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9},
+			err:  true,
+		},
+		{
+			name: "not an image",
+			data: []byte("hello world"),
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Decode(tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !d.DateTime.Equal(tt.expected) {
+				t.Errorf("DateTime = %v, want %v", d.DateTime, tt.expected)
+			}
+		})
+	}
+}
+
+func rationalTag(tag uint16, values ...uint32) testTag {
+	v := []byte{}
+	for _, n := range values {
+		v = binary.LittleEndian.AppendUint32(v, n)
+	}
+	return testTag{tag: tag, typ: 5, count: uint32(len(values) / 2), value: v}
+}
+
+func TestDecodeLocation(t *testing.T) {
+	tests := []struct {
+		name        string
+		gps         []testTag
+		hasLocation bool
+		lat, lon    float64
+	}{
+		{
+			name: "south west",
+			gps: []testTag{
+				asciiTag(tagGPSLatitudeRef, "S"),
+				rationalTag(tagGPSLatitude, 33, 1, 51, 1, 3540, 100),
+				asciiTag(tagGPSLongitudeRef, "W"),
+				rationalTag(tagGPSLongitude, 70, 1, 30, 1, 0, 1),
+			},
+			hasLocation: true,
+			lat:         -(33 + 51.0/60 + 35.4/3600),
+			lon:         -70.5,
+		},
+		{
+			name: "north east",
+			gps: []testTag{
+				asciiTag(tagGPSLatitudeRef, "N"),
+				rationalTag(tagGPSLatitude, 40, 1, 42, 1, 0, 1),
+				asciiTag(tagGPSLongitudeRef, "E"),
+				rationalTag(tagGPSLongitude, 74, 1, 0, 1, 0, 1),
+			},
+			hasLocation: true,
+			lat:         40.7,
+			lon:         74,
+		},
+		{
+			name: "zero coordinates are ignored",
+			gps: []testTag{
+				rationalTag(tagGPSLatitude, 0, 1, 0, 1, 0, 1),
+				rationalTag(tagGPSLongitude, 0, 1, 0, 1, 0, 1),
+			},
+		},
+		{
+			name: "missing longitude",
+			gps: []testTag{
+				rationalTag(tagGPSLatitude, 40, 1, 0, 1, 0, 1),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Decode(wrapJpeg(makeTiff(nil, map[uint16][]testTag{tagGPSIFD: tt.gps})))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.HasLocation != tt.hasLocation {
+				t.Fatalf("HasLocation = %v, want %v", d.HasLocation, tt.hasLocation)
+			}
+			if math.Abs(d.Latitude-tt.lat) > 1e-9 || math.Abs(d.Longitude-tt.lon) > 1e-9 {
+				t.Errorf("location = %f,%f want %f,%f", d.Latitude, d.Longitude, tt.lat, tt.lon)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed web/gallery/favorites.js
+var favoritesJs []byte
+
+// Gallery folder under which favorite media is listed
+const favoritesFolder = "favorites"
+
+// Favorite is media marked as favorite
+type Favorite struct {
+	Path  string    `json:"path"`
+	Added time.Time `json:"added"`
+}
+
+type favoritesFile struct {
+	Favorites []Favorite `json:"favorites"`
+}
+
+// favoriteStore keeps favorite media in memory and persists it to a JSON
+// file on every change. The file is small, so it is rewritten as a whole.
+type favoriteStore struct {
+	mu        sync.RWMutex
+	file      string
+	index     *mediaIndex
+	favorites map[string]time.Time
+}
+
+func newFavoriteStore(file string, index *mediaIndex) *favoriteStore {
+	return &favoriteStore{file: file, index: index, favorites: make(map[string]time.Time)}
+}
+
+// load reads favorites file. Missing file means there are no favorites yet.
+func (s *favoriteStore) load() error {
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading favorites file %s: %w", s.file, err)
+	}
+
+	ff := favoritesFile{}
+	if err := json.Unmarshal(data, &ff); err != nil {
+		return fmt.Errorf("error parsing favorites file %s: %w", s.file, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.favorites = make(map[string]time.Time)
+	for _, f := range ff.Favorites {
+		s.favorites[f.Path] = f.Added
+	}
+
+	return nil
+}
+
+// save writes favorites into a temporary file and renames it over the
+// old one so a crash never leaves a half written file. Caller must hold the lock.
+func (s *favoriteStore) save() error {
+	ff := favoritesFile{Favorites: s.sorted()}
+
+	data, err := json.MarshalIndent(ff, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".favorites-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// sorted returns favorites most recently added first. Caller must hold the lock.
+func (s *favoriteStore) sorted() []Favorite {
+	list := []Favorite{}
+	for p, added := range s.favorites {
+		list = append(list, Favorite{Path: p, Added: added})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Added.Equal(list[j].Added) {
+			return list[i].Added.After(list[j].Added)
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// set marks or unmarks media as favorite and persists the change
+func (s *favoriteStore) set(p string, favorite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added, exists := s.favorites[p]
+	if exists == favorite {
+		return nil
+	}
+
+	if favorite {
+		s.favorites[p] = time.Now()
+	} else {
+		delete(s.favorites, p)
+	}
+
+	err := s.save()
+	if err != nil {
+		// Keep memory in sync with the file
+		if favorite {
+			delete(s.favorites, p)
+		} else {
+			s.favorites[p] = added
+		}
+	}
+
+	return err
+}
+
+// move keeps favorites of media that was moved to a new path, media moved to
+// empty path was deleted and is no longer favorite
+func (s *favoriteStore) move(moved map[string]string) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := make(map[string]time.Time, len(s.favorites))
+	for p, added := range s.favorites {
+		old[p] = added
+	}
+
+	changed := false
+	for p, added := range old {
+		to, ok := moved[p]
+		if !ok {
+			continue
+		}
+		delete(s.favorites, p)
+		if to != "" {
+			s.favorites[to] = added
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	err := s.save()
+	if err != nil {
+		// Keep memory in sync with the file
+		s.favorites = old
+	}
+
+	return err
+}
+
+func (s *favoriteStore) has(p string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.favorites[p]
+	return ok
+}
+
+func (s *favoriteStore) list() []Favorite {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sorted()
+}
+
+// folder returns favorites as virtual folder if p is the favorites folder path
+func (s *favoriteStore) folder(p string) (virtualFolder, bool) {
+	if s == nil || strings.Trim(p, "/") != favoritesFolder {
+		return virtualFolder{}, false
+	}
+
+	vf := virtualFolder{Path: favoritesFolder, Title: "Favorites", Items: []MediaItem{}}
+	for _, f := range s.list() {
+		// Skip favorites that were removed from storage
+		if it, ok := s.index.get(f.Path); ok {
+			vf.Items = append(vf.Items, it)
+		}
+	}
+
+	return vf, true
+}
+
+// makeFavoritesHandler renders favorites folder and its media in the player
+func makeFavoritesHandler(favorites *favoriteStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isAnonymous(r) {
+			denyAccess(w, r)
+			return
+		}
+
+		vf, _ := favorites.folder(favoritesFolder)
+		mediaPath := strings.Trim(strings.TrimPrefix(r.URL.Path, "/"+favoritesFolder), "/")
+		vf.serve(w, r, mediaPath, urlPrefix)
+	}
+}
+
+// makeFavoritesApiHandler lists favorites on GET and marks media as favorite on POST.
+// POST takes "path" form value and optional "favorite" (true or false),
+// without it favorite state is toggled. GET with "download" parameter
+// exports favorites as a JSON file.
+func makeFavoritesApiHandler(favorites *favoriteStore, index *mediaIndex) http.HandlerFunc {
+	type favoriteMedia struct {
+		apiMedia
+		Added time.Time `json:"added"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isAnonymous(r) {
+			denyAccess(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			list := []favoriteMedia{}
+			for _, f := range favorites.list() {
+				it, ok := index.get(f.Path)
+				if !ok || !isVisible(r, "", it.Path, false) {
+					continue
+				}
+				list = append(list, favoriteMedia{apiMedia: makeApiMedia(it), Added: f.Added})
+			}
+
+			if r.URL.Query().Get("download") != "" {
+				w.Header().Set("Content-Disposition", "attachment; filename=\"favorites.json\"")
+			}
+			writeJSON(w, list)
+
+		case http.MethodPost:
+			it, ok := index.get(r.FormValue("path"))
+			if !ok || !canRead(r, it.Path) {
+				writeError(w, http.StatusNotFound, "Not Found")
+				return
+			}
+
+			favorite := !favorites.has(it.Path)
+			if v := r.FormValue("favorite"); v != "" {
+				var err error
+				favorite, err = strconv.ParseBool(v)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "invalid favorite value")
+					return
+				}
+			}
+
+			if err := favorites.set(it.Path, favorite); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			writeJSON(w, map[string]any{"path": it.Path, "favorite": favorite})
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestFavorites(t *testing.T) (*favoriteStore, *mediaIndex) {
+	t.Helper()
+
+	mockFs := fstest.MapFS{
+		"kif/2023/post_1700000000_0.jpg":  &fstest.MapFile{},
+		"snay/2021/post_1600000000_0.jpg": &fstest.MapFile{},
+	}
+	index := newMediaIndex(mockFs, func(string) ([]byte, error) { return nil, nil }, func(string) int64 { return 0 })
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	favorites := newFavoriteStore(filepath.Join(t.TempDir(), "favorites.json"), index)
+	if err := favorites.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return favorites, index
+}
+
+func TestFavoriteStore(t *testing.T) {
+	favorites, index := newTestFavorites(t)
+
+	if err := favorites.set("kif/2023/post_1700000000_0.jpg", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := favorites.set("snay/2021/post_1600000000_0.jpg", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := favorites.set("kif/2023/post_1700000000_0.jpg", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Favorites survive restart
+	reloaded := newFavoriteStore(favorites.file, index)
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.has("kif/2023/post_1700000000_0.jpg") || !reloaded.has("snay/2021/post_1600000000_0.jpg") {
+		t.Errorf("unexpected favorites after reload %+v", reloaded.list())
+	}
+
+	vf, ok := reloaded.folder("/favorites/")
+	if !ok || len(vf.Items) != 1 || vf.Items[0].Path != "snay/2021/post_1600000000_0.jpg" {
+		t.Errorf("unexpected favorites folder %+v", vf)
+	}
+	if _, ok := reloaded.folder("favorites/x"); ok {
+		t.Error("only favorites folder itself must resolve")
+	}
+}
+
+func TestFavoritesApiHandler(t *testing.T) {
+	favorites, index := newTestFavorites(t)
+	handler := makeFavoritesApiHandler(favorites, index)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", urlPrefix+"/api/favorites", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler(w, r)
+		return w
+	}
+
+	// Toggle without explicit value
+	if w := post(url.Values{"path": {"kif/2023/post_1700000000_0.jpg"}}); w.Code != 200 || !favorites.has("kif/2023/post_1700000000_0.jpg") {
+		t.Fatalf("toggle returned %d: %s", w.Code, w.Body.String())
+	}
+	if w := post(url.Values{"path": {"kif/2023/post_1700000000_0.jpg"}, "favorite": {"true"}}); w.Code != 200 || !favorites.has("kif/2023/post_1700000000_0.jpg") {
+		t.Fatalf("explicit favorite returned %d: %s", w.Code, w.Body.String())
+	}
+	if w := post(url.Values{"path": {"kif/missing.jpg"}}); w.Code != 404 {
+		t.Errorf("unknown media returned %d, want 404", w.Code)
+	}
+	if w := post(url.Values{"path": {"kif/2023/post_1700000000_0.jpg"}, "favorite": {"maybe"}}); w.Code != 400 {
+		t.Errorf("invalid value returned %d, want 400", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", urlPrefix+"/api/favorites?download=1", nil))
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "favorites.json") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	var list []struct {
+		Path  string
+		Added string
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Path != "kif/2023/post_1700000000_0.jpg" || list[0].Added == "" {
+		t.Errorf("unexpected favorites list %+v", list)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("DELETE", urlPrefix+"/api/favorites", nil))
+	if w.Code != 405 {
+		t.Errorf("DELETE returned %d, want 405", w.Code)
+	}
+}
+
+func TestFavoritesApiHandler_CrossOrigin(t *testing.T) {
+	favorites, index := newTestFavorites(t)
+	handler := requireSameOrigin(makeFavoritesApiHandler(favorites, index))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", urlPrefix+"/api/favorites", strings.NewReader("path=kif/2023/post_1700000000_0.jpg"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	handler(w, r)
+	if w.Code != 403 || favorites.has("kif/2023/post_1700000000_0.jpg") {
+		t.Errorf("cross-site toggle returned %d, favorite %v", w.Code, favorites.has("kif/2023/post_1700000000_0.jpg"))
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+//go:embed web/gallery/map.css
+var mapCss []byte
+
+// Size of the rendered map in SVG user units
+const (
+	mapWidth  = 1000
+	mapHeight = 600
+)
+
+// MapPoint is a media marker projected onto the map
+type MapPoint struct {
+	X, Y  float64
+	Label string
+	Media Media
+}
+
+// MapLine is a graticule line drawn on the map with its degree label
+type MapLine struct {
+	X1, Y1, X2, Y2 float64
+	Label          string
+}
+
+type MapPage struct {
+	Title       string
+	Points      []MapPoint
+	Lines       []MapLine
+	Width       int
+	Height      int
+	Total       int
+	BackLink    string
+	GeoJSONLink string
+	Filter      string
+	Styles      template.CSS
+	JS          template.JS
+}
+
+type geoJSONGeometry struct {
+	Type string `json:"type"`
+	// Longitude first as required by GeoJSON spec
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties apiMedia        `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// filterMediaItems keeps items whose file name contains any of the filter
+// words. It follows the same rules as filterDirEntries.
+func filterMediaItems(items []MediaItem, filter string) []MediaItem {
+	if filter == "" {
+		return items
+	}
+
+	parts := strings.Split(filter, " ")
+	for _, word := range parts {
+		if word == "" {
+			return items
+		}
+	}
+
+	filtered := []MediaItem{}
+	for _, it := range items {
+		name := path.Base(it.Path)
+		for _, word := range parts {
+			if strings.Contains(name, word) {
+				filtered = append(filtered, it)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// locatedMedia returns media under folder that matches filter and has a known location
+func locatedMedia(index *mediaIndex, folder string, filter string) []MediaItem {
+	located := []MediaItem{}
+	for _, it := range filterMediaItems(index.all(folder), filter) {
+		if it.Location != nil {
+			located = append(located, it)
+		}
+	}
+	return located
+}
+
+func makeGeoJSON(items []MediaItem) geoJSONFeatureCollection {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, it := range items {
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONGeometry{
+				Type:        "Point",
+				Coordinates: [2]float64{it.Location.Longitude, it.Location.Latitude},
+			},
+			Properties: makeApiMedia(it),
+		})
+	}
+	return fc
+}
+
+// mapBounds returns bounding box of the items padded so markers do not touch the edges
+func mapBounds(items []MediaItem) (minLon, minLat, maxLon, maxLat float64) {
+	minLon, minLat, maxLon, maxLat = 180, 90, -180, -90
+	for _, it := range items {
+		minLon = math.Min(minLon, it.Location.Longitude)
+		maxLon = math.Max(maxLon, it.Location.Longitude)
+		minLat = math.Min(minLat, it.Location.Latitude)
+		maxLat = math.Max(maxLat, it.Location.Latitude)
+	}
+	if len(items) == 0 {
+		return -180, -90, 180, 90
+	}
+
+	// Keep at least ~1km span so a single point is not a division by zero
+	padLon := math.Max((maxLon-minLon)*0.1, 0.01)
+	padLat := math.Max((maxLat-minLat)*0.1, 0.01)
+
+	return math.Max(minLon-padLon, -180), math.Max(minLat-padLat, -90),
+		math.Min(maxLon+padLon, 180), math.Min(maxLat+padLat, 90)
+}
+
+// gridStep picks a round graticule interval giving a handful of lines for the span
+func gridStep(span float64) float64 {
+	for _, step := range []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30} {
+		if span/step <= 8 {
+			return step
+		}
+	}
+	return 60
+}
+
+// projectMap places items on equirectangular map fitted to their bounding box.
+// No map tiles are used so the page works without any external service.
+func projectMap(items []MediaItem) ([]MapPoint, []MapLine) {
+	minLon, minLat, maxLon, maxLat := mapBounds(items)
+	spanLon, spanLat := maxLon-minLon, maxLat-minLat
+
+	x := func(lon float64) float64 { return (lon - minLon) / spanLon * mapWidth }
+	y := func(lat float64) float64 { return (maxLat - lat) / spanLat * mapHeight }
+
+	points := []MapPoint{}
+	for _, it := range items {
+		points = append(points, MapPoint{
+			X:     x(it.Location.Longitude),
+			Y:     y(it.Location.Latitude),
+			Label: fmt.Sprintf("%s (%s)", path.Base(it.Path), it.Time.Format("2 Jan 2006")),
+			Media: makeIndexedMedia(it),
+		})
+	}
+
+	lines := []MapLine{}
+	step := gridStep(math.Max(spanLon, spanLat))
+	for lon := math.Ceil(minLon/step) * step; lon <= maxLon; lon += step {
+		lines = append(lines, MapLine{X1: x(lon), Y1: 0, X2: x(lon), Y2: mapHeight, Label: formatDegrees(lon)})
+	}
+	for lat := math.Ceil(minLat/step) * step; lat <= maxLat; lat += step {
+		lines = append(lines, MapLine{X1: 0, Y1: y(lat), X2: mapWidth, Y2: y(lat), Label: formatDegrees(lat)})
+	}
+
+	return points, lines
+}
+
+func formatDegrees(v float64) string {
+	s := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", v), "0"), ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return s + "°"
+}
+
+// makeMapHandler renders located media of a folder as markers on a map.
+// The folder is taken from URL path after /map/ and can be narrowed with "filter" parameter.
+func makeMapHandler(index *mediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		folder := strings.Trim(strings.TrimPrefix(r.URL.Path, urlPrefix+"/map"), "/")
+		filter := r.URL.Query().Get("filter")
+
+		if !canBrowse(r, folder) {
+			denyAccess(w, r)
+			return
+		}
+
+		items := visibleItems(r, folder, locatedMedia(index, folder, filter))
+		points, lines := projectMap(items)
+
+		title := "Map"
+		if folder != "" {
+			title = "Map: " + folder
+		}
+
+		geoLink := path.Join(urlPrefix, "api/geojson", folder) + "?download=1"
+		if filter != "" {
+			geoLink += "&filter=" + url.QueryEscape(filter)
+		}
+
+		page := MapPage{
+			Title:       title,
+			Points:      points,
+			Lines:       lines,
+			Width:       mapWidth,
+			Height:      mapHeight,
+			Total:       len(items),
+			BackLink:    path.Join(urlPrefix, folder),
+			GeoJSONLink: geoLink,
+			Filter:      filter,
+			Styles:      template.CSS(append(mapCss, globalCss...)),
+			JS:          template.JS(globalJs),
+		}
+
+		err := tmpl.ExecuteTemplate(w, "map.html", page)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+}
+
+// makeGeoJSONHandler returns located media of a folder as GeoJSON FeatureCollection.
+// With "download" parameter the response is sent as attachment for offline use.
+func makeGeoJSONHandler(index *mediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		folder := strings.Trim(strings.TrimPrefix(r.URL.Path, urlPrefix+"/api/geojson"), "/")
+		if !canBrowse(r, folder) {
+			denyAccess(w, r)
+			return
+		}
+
+		items := visibleItems(r, folder, locatedMedia(index, folder, r.URL.Query().Get("filter")))
+
+		if r.URL.Query().Get("download") != "" {
+			name := path.Base(folder)
+			if folder == "" {
+				name = "gallery"
+			}
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+name+".geojson\"")
+		}
+
+		w.Header().Set("Content-Type", "application/geo+json")
+		writeJSON(w, makeGeoJSON(items))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProjectMap(t *testing.T) {
+	items := []MediaItem{
+		{Path: "a/1.jpg", Location: &Location{Latitude: 10, Longitude: 20}},
+		{Path: "a/2.jpg", Location: &Location{Latitude: 20, Longitude: 40}},
+	}
+
+	points, lines := projectMap(items)
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2", len(points))
+	}
+	if len(lines) == 0 {
+		t.Error("expected graticule lines")
+	}
+
+	for _, p := range points {
+		if p.X < 0 || p.X > mapWidth || p.Y < 0 || p.Y > mapHeight {
+			t.Errorf("point %s at %f,%f is outside of the map", p.Media.FileName, p.X, p.Y)
+		}
+	}
+
+	// North east point must be to the right and above the other one
+	if points[1].X <= points[0].X || points[1].Y >= points[0].Y {
+		t.Errorf("unexpected projection %+v", points)
+	}
+
+	// Single point must not produce NaN coordinates
+	single, _ := projectMap(items[:1])
+	if single[0].X != mapWidth/2 || single[0].Y != mapHeight/2 {
+		t.Errorf("single point at %f,%f, want map center", single[0].X, single[0].Y)
+	}
+}
+
+func TestFilterMediaItems(t *testing.T) {
+	items := []MediaItem{
+		{Path: "kif/2024/post_1_0.jpg"},
+		{Path: "kif/2024/story_1_0.mp4"},
+		{Path: "post/2024/reel_1_0.mp4"},
+	}
+
+	tests := []struct {
+		filter   string
+		expected int
+	}{
+		{"", 3},
+		{"post", 1},
+		{"post story", 2},
+		{"post  story", 3},
+		{"mp4", 2},
+	}
+
+	for _, tt := range tests {
+		if got := len(filterMediaItems(items, tt.filter)); got != tt.expected {
+			t.Errorf("filterMediaItems(%q) returned %d items, want %d", tt.filter, got, tt.expected)
+		}
+	}
+}
+
+func TestGeoJSONHandler(t *testing.T) {
+	index := &mediaIndex{items: []MediaItem{
+		{Path: "kif/2024/post_1_0.jpg", Time: time.Unix(1, 0), Location: &Location{Latitude: 40.7, Longitude: -74}},
+		{Path: "kif/2024/post_2_0.jpg", Time: time.Unix(2, 0)},
+		{Path: "snay/2024/post_3_0.jpg", Time: time.Unix(3, 0), Location: &Location{Latitude: 1, Longitude: 2}},
+	}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", urlPrefix+"/api/geojson/kif?download=1", nil)
+	makeGeoJSONHandler(index)(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/geo+json" {
+		t.Errorf("Content-Type = %q, want application/geo+json", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="kif.geojson"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	var fc geoJSONFeatureCollection
+	if err := json.NewDecoder(w.Body).Decode(&fc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 1 {
+		t.Fatalf("unexpected feature collection %+v", fc)
+	}
+	if c := fc.Features[0].Geometry.Coordinates; c[0] != -74 || c[1] != 40.7 {
+		t.Errorf("coordinates = %v, want [-74 40.7]", c)
+	}
+}
@@ -1,7 +1,10 @@
 module github.com/kif11/gallery2
 
-go 1.19
+go 1.24.0
 
-require github.com/aws/aws-sdk-go v1.54.18
+require (
+	github.com/aws/aws-sdk-go v1.54.18
+	golang.org/x/crypto v0.48.0
+)
 
 require github.com/jmespath/go-jmespath v0.4.0 // indirect
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kif11/gallery2/exif"
+	"github.com/kif11/gallery2/sidecar"
+)
+
+// Sources of media capture time in order of preference
+const (
+	TimeFromFileName = "filename"
+	TimeFromSidecar  = "sidecar"
+	TimeFromExif     = "exif"
+	TimeFromModTime  = "mtime"
+)
+
+// MediaItem is a single media file known to the media index
+type MediaItem struct {
+	// Path relative to the gallery root e.g. kif/2024/story_1700000000_0.jpg
+	Path string
+	Size int64
+	// Capture time of the media
+	Time time.Time
+	// Where capture time came from, one of TimeFrom* constants
+	TimeSource string
+	// Where media was captured, nil if unknown
+	Location *Location
+	// Caption from the sidecar file
+	Caption string
+}
+
+// Location is a geographic position in decimal degrees
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type exifCacheEntry struct {
+	size int64
+	data *exif.Data
+}
+
+type sidecarCacheEntry struct {
+	size    int64
+	modTime time.Time
+	data    *sidecar.Metadata
+}
+
+// mediaIndex keeps flat list of all media in the gallery in memory.
+// Walking storage (and especially S3) on every request is slow so pages that
+// need a view across all folders read from the index instead.
+// The index is rebuilt together with the storage listing by refresh().
+type mediaIndex struct {
+	mu sync.RWMutex
+	// Serializes concurrent refresh calls
+	refreshMu sync.Mutex
+
+	fSys     fs.FS
+	readFile readFileFunc
+	sizeFn   func(string) int64
+	// Reads first n bytes of a file, EXIF is at the start of JPEG files
+	readHead func(p string, n int64) ([]byte, error)
+	// Read EXIF of JPEG files for capture time and GPS location.
+	// With S3 backend this requests every JPEG once so it can be turned off.
+	readExif bool
+
+	items     []MediaItem
+	byPath    map[string]int
+	updatedAt time.Time
+	err       error
+	// Sizes of the caches when items were last set, caches are only safe
+	// to read under refreshMu which is held for the whole refresh
+	exifCached    int
+	sidecarCached int
+
+	// EXIF data keyed by path, so refresh does not download the same files again
+	exifCache map[string]exifCacheEntry
+	// Parsed sidecar files keyed by sidecar path
+	sidecarCache map[string]sidecarCacheEntry
+}
+
+func newMediaIndex(fSys fs.FS, readFile readFileFunc, sizeFn func(string) int64) *mediaIndex {
+	return &mediaIndex{
+		fSys:         fSys,
+		readFile:     readFile,
+		sizeFn:       sizeFn,
+		readHead:     fsReadHead(fSys),
+		readExif:     true,
+		exifCache:    make(map[string]exifCacheEntry),
+		sidecarCache: make(map[string]sidecarCacheEntry),
+	}
+}
+
+// refreshFrom reloads the storage listing with update and rebuilds the
+// index. A failed listing is kept as the refresh error, the index keeps the
+// media of the last listing.
+func (idx *mediaIndex) refreshFrom(update func() error) error {
+	if err := update(); err != nil {
+		idx.mu.Lock()
+		idx.updatedAt = time.Now()
+		idx.err = fmt.Errorf("error listing storage: %w", err)
+		idx.mu.Unlock()
+		return err
+	}
+	return idx.refresh()
+}
+
+// refresh walks the storage and rebuilds the list of media items
+func (idx *mediaIndex) refresh() error {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+
+	items := []MediaItem{}
+
+	err := fs.WalkDir(idx.fSys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Deleted media is not part of the gallery
+		if d.IsDir() && p == trashFolder {
+			return fs.SkipDir
+		}
+		if d.IsDir() || getMediaType(path.Ext(p)) == Other {
+			return nil
+		}
+
+		items = append(items, idx.makeItem(p, d))
+
+		return nil
+	})
+
+	sortMediaItems(items)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.updatedAt = time.Now()
+	idx.err = err
+	if err != nil {
+		return err
+	}
+	idx.setItems(items)
+
+	return nil
+}
+
+// reindex updates index entries of the given paths without walking the
+// whole storage, after media was added or changed through the gallery.
+// Paths that no longer exist are removed from the index.
+func (idx *mediaIndex) reindex(paths ...string) {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+
+	changed := make(map[string]*MediaItem)
+	for _, p := range paths {
+		p = strings.Trim(p, "/")
+		info, err := fs.Stat(idx.fSys, p)
+		if err != nil || info.IsDir() || isTrashPath(p) || getMediaType(path.Ext(p)) == Other {
+			changed[p] = nil
+			continue
+		}
+		item := idx.makeItem(p, fs.FileInfoToDirEntry(info))
+		changed[p] = &item
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	items := make([]MediaItem, 0, len(idx.items)+len(changed))
+	for _, it := range idx.items {
+		if _, ok := changed[it.Path]; !ok {
+			items = append(items, it)
+		}
+	}
+	for _, it := range changed {
+		if it != nil {
+			items = append(items, *it)
+		}
+	}
+
+	sortMediaItems(items)
+	idx.setItems(items)
+}
+
+// makeItem reads what is known about media at p
+func (idx *mediaIndex) makeItem(p string, d fs.DirEntry) MediaItem {
+	item := MediaItem{Path: p, Size: idx.sizeFn(p)}
+	ex := idx.exifData(p, item.Size)
+	meta := idx.sidecarData(p)
+	item.Time, item.TimeSource = captureTime(p, meta, ex, d)
+
+	if ex != nil && ex.HasLocation {
+		item.Location = &Location{Latitude: ex.Latitude, Longitude: ex.Longitude}
+	} else if meta != nil && meta.Location != nil {
+		item.Location = &Location{Latitude: meta.Location.Latitude, Longitude: meta.Location.Longitude}
+	}
+	if meta != nil {
+		item.Caption = meta.Caption
+	}
+
+	return item
+}
+
+// sortMediaItems sorts newest media first, same as folder view
+func sortMediaItems(items []MediaItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].Time.Equal(items[j].Time) {
+			return items[i].Time.After(items[j].Time)
+		}
+		return items[i].Path < items[j].Path
+	})
+}
+
+// setItems replaces indexed items, idx.mu must be held
+func (idx *mediaIndex) setItems(items []MediaItem) {
+	idx.items = items
+	idx.byPath = make(map[string]int, len(items))
+	for i, it := range items {
+		idx.byPath[it.Path] = i
+	}
+	idx.exifCached = len(idx.exifCache)
+	idx.sidecarCached = len(idx.sidecarCache)
+}
+
+// indexStatus describes the index for the admin dashboard
+type indexStatus struct {
+	items         int
+	updatedAt     time.Time
+	err           error
+	exifCached    int
+	sidecarCached int
+}
+
+func (idx *mediaIndex) status() indexStatus {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return indexStatus{
+		items:         len(idx.items),
+		updatedAt:     idx.updatedAt,
+		err:           idx.err,
+		exifCached:    idx.exifCached,
+		sidecarCached: idx.sidecarCached,
+	}
+}
+
+// purgeCaches forgets EXIF and sidecar data, the next refresh reads them again
+func (idx *mediaIndex) purgeCaches() {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+
+	idx.exifCache = make(map[string]exifCacheEntry)
+	idx.sidecarCache = make(map[string]sidecarCacheEntry)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.exifCached = 0
+	idx.sidecarCached = 0
+}
+
+// captureTime resolves when media was captured. It uses timestamp encoded
+// in the file name by the ingest tool, then sidecar timestamp, EXIF date
+// for JPEGs and finally file modification time.
+func captureTime(p string, meta *sidecar.Metadata, ex *exif.Data, d fs.DirEntry) (time.Time, string) {
+	if _, ts, _, ok := parseMediaName(path.Base(p)); ok {
+		return time.Unix(ts, 0), TimeFromFileName
+	}
+
+	if meta != nil && meta.Timestamp != 0 {
+		return time.Unix(meta.Timestamp, 0), TimeFromSidecar
+	}
+
+	if ex != nil && !ex.DateTime.IsZero() {
+		return ex.DateTime, TimeFromExif
+	}
+
+	info, err := d.Info()
+	if err == nil && info != nil {
+		return info.ModTime(), TimeFromModTime
+	}
+
+	return time.Time{}, TimeFromModTime
+}
+
+// Bytes read from the start of JPEG files for EXIF. EXIF sits in the APP1
+// segment right after the start of the image and is at most 64 KB.
+const exifHeadSize = 128 << 10
+
+// fsReadHead returns function reading the first n bytes of files of fSys
+func fsReadHead(fSys fs.FS) func(string, int64) ([]byte, error) {
+	return func(p string, n int64) ([]byte, error) {
+		f, err := fSys.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, n))
+	}
+}
+
+// exifData returns EXIF of JPEG file or nil if it has none
+func (idx *mediaIndex) exifData(p string, size int64) *exif.Data {
+	if !idx.readExif {
+		return nil
+	}
+	switch strings.ToLower(path.Ext(p)) {
+	case ".jpg", ".jpeg":
+	default:
+		return nil
+	}
+
+	if c, ok := idx.exifCache[p]; ok && c.size == size {
+		return c.data
+	}
+
+	data, err := idx.readHead(p, exifHeadSize)
+	if err != nil {
+		// Do not cache failed reads so the next refresh tries again
+		return nil
+	}
+	ex, _ := exif.Decode(data)
+	idx.exifCache[p] = exifCacheEntry{size: size, data: ex}
+
+	return ex
+}
+
+// sidecarData returns parsed sidecar of media file or nil if there is none
+func (idx *mediaIndex) sidecarData(p string) *sidecar.Metadata {
+	sp := sidecar.Path(p)
+	info, err := fs.Stat(idx.fSys, sp)
+	if err != nil {
+		return nil
+	}
+
+	size := idx.sizeFn(sp)
+	if c, ok := idx.sidecarCache[sp]; ok && c.size == size && c.modTime.Equal(info.ModTime()) {
+		return c.data
+	}
+
+	data, err := idx.readFile(sp)
+	if err != nil {
+		return nil
+	}
+	meta, _ := sidecar.Decode(data)
+	idx.sidecarCache[sp] = sidecarCacheEntry{size: size, modTime: info.ModTime(), data: meta}
+
+	return meta
+}
+
+// makeIndexedMedia returns page media for indexed item including its caption
+func makeIndexedMedia(it MediaItem) Media {
+	m := makeMedia(it.Path, assetsRoute, urlPrefix)
+	m.Caption = it.Caption
+	return m
+}
+
+// get returns indexed media item by its path
+func (idx *mediaIndex) get(p string) (MediaItem, bool) {
+	if idx == nil {
+		return MediaItem{}, false
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	i, ok := idx.byPath[strings.Trim(p, "/")]
+	if !ok {
+		return MediaItem{}, false
+	}
+	return idx.items[i], true
+}
+
+// all returns every media item under the given folder, newest first
+func (idx *mediaIndex) all(folder string) []MediaItem {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	folder = strings.Trim(folder, "/")
+	if folder == "" || folder == "." {
+		return idx.items
+	}
+
+	items := []MediaItem{}
+	for _, it := range idx.items {
+		if strings.HasPrefix(it.Path, folder+"/") {
+			items = append(items, it)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMediaIndexRefresh(t *testing.T) {
+	mtime := time.Date(2022, 3, 4, 10, 0, 0, 0, time.Local)
+	mockFs := fstest.MapFS{
+		"kif/2023/story_1700000000_0.jpg": &fstest.MapFile{},
+		"kif/2023/story_1700000000_1.mp4": &fstest.MapFile{},
+		"kif/2022/holiday.png":            &fstest.MapFile{ModTime: mtime},
+		"kif/2022/notes.txt":              &fstest.MapFile{},
+	}
+	readFile := func(p string) ([]byte, error) { return mockFs[p].Data, nil }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+	if err := index.refresh(); err != nil {
+		t.Fatalf("refresh() returned unexpected error: %v", err)
+	}
+
+	items := index.all("")
+	expected := []struct {
+		path   string
+		source string
+	}{
+		{"kif/2023/story_1700000000_0.jpg", TimeFromFileName},
+		{"kif/2023/story_1700000000_1.mp4", TimeFromFileName},
+		{"kif/2022/holiday.png", TimeFromModTime},
+	}
+
+	if len(items) != len(expected) {
+		t.Fatalf("index has %d items, want %d", len(items), len(expected))
+	}
+	for i, e := range expected {
+		if items[i].Path != e.path || items[i].TimeSource != e.source {
+			t.Errorf("item %d = %s (%s), want %s (%s)", i, items[i].Path, items[i].TimeSource, e.path, e.source)
+		}
+	}
+
+	if !items[2].Time.Equal(mtime) {
+		t.Errorf("mtime fallback = %v, want %v", items[2].Time, mtime)
+	}
+
+	if got := len(index.all("kif/2022")); got != 1 {
+		t.Errorf("all(kif/2022) returned %d items, want 1", got)
+	}
+	if got := len(index.all("kif/202")); got != 0 {
+		t.Errorf("all(kif/202) returned %d items, want 0", got)
+	}
+}
+
+func TestMediaIndexRefreshFrom(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/2023/story_1700000000_0.jpg": &fstest.MapFile{},
+	}
+	readFile := func(p string) ([]byte, error) { return mockFs[p].Data, nil }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+
+	listed := func() error { return nil }
+	if err := index.refreshFrom(listed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listing S3 failed, the dashboard shows why and the media stays
+	failed := func() error { return errors.New("connection refused") }
+	if err := index.refreshFrom(failed); err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	st := index.status()
+	if st.err == nil || !strings.Contains(st.err.Error(), "connection refused") {
+		t.Errorf("expected listing error in index status, got %v", st.err)
+	}
+	if st.items != 1 {
+		t.Errorf("expected media of the last listing to stay indexed, got %d", st.items)
+	}
+
+	if err := index.refreshFrom(listed); err != nil {
+		t.Fatal(err)
+	}
+	if st := index.status(); st.err != nil {
+		t.Errorf("expected error to be cleared after refresh, got %v", st.err)
+	}
+}
+
+func TestMediaIndexSidecar(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/2023/story_1700000000_0.jpg":      &fstest.MapFile{},
+		"kif/2023/story_1700000000_0.jpg.json": &fstest.MapFile{Data: []byte(`{"caption": "Sunset", "location": {"latitude": 1.5, "longitude": 2.5}}`)},
+		"kif/2023/camera.jpg":                  &fstest.MapFile{},
+		"kif/2023/camera.jpg.json":             &fstest.MapFile{Data: []byte(`{"timestamp": 1600000000}`)},
+		"kif/2023/broken.jpg":                  &fstest.MapFile{},
+		"kif/2023/broken.jpg.json":             &fstest.MapFile{Data: []byte(`{`)},
+	}
+	readFile := func(p string) ([]byte, error) { return mockFs[p].Data, nil }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(index.all("")); got != 3 {
+		t.Fatalf("index has %d items, want 3 (sidecars must not be indexed)", got)
+	}
+
+	story, ok := index.get("kif/2023/story_1700000000_0.jpg")
+	if !ok {
+		t.Fatal("story is not indexed")
+	}
+	if story.Caption != "Sunset" {
+		t.Errorf("Caption = %q, want Sunset", story.Caption)
+	}
+	if story.Location == nil || story.Location.Latitude != 1.5 || story.Location.Longitude != 2.5 {
+		t.Errorf("Location = %+v, want 1.5,2.5", story.Location)
+	}
+	if story.TimeSource != TimeFromFileName {
+		t.Errorf("file name timestamp must win over sidecar, got %s", story.TimeSource)
+	}
+
+	camera, _ := index.get("/kif/2023/camera.jpg")
+	if camera.TimeSource != TimeFromSidecar || camera.Time.Unix() != 1600000000 {
+		t.Errorf("camera time = %v (%s), want sidecar timestamp", camera.Time, camera.TimeSource)
+	}
+
+	broken, _ := index.get("kif/2023/broken.jpg")
+	if broken.Caption != "" || broken.TimeSource != TimeFromModTime {
+		t.Errorf("broken sidecar must be ignored, got %+v", broken)
+	}
+}
+
+func TestGalleryRootHandler_Caption(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/story_1700000000_0.jpg":      &fstest.MapFile{},
+		"kif/story_1700000000_0.jpg.json": &fstest.MapFile{Data: []byte(`{"caption": "Hello <world>"}`)},
+	}
+	readFile := func(p string) ([]byte, error) { return mockFs[p].Data, nil }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	handler := makeGalleryRootHandler(mockFs, sizeFn, index, nil, nil)
+
+	for _, p := range []string{"/kif", "/kif/story_1700000000_0.jpg"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", p, nil))
+		if !strings.Contains(w.Body.String(), "Hello &lt;world&gt;") {
+			t.Errorf("page %s does not contain escaped caption", p)
+		}
+	}
+}
+
+func TestMediaIndexReindex(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/2023/story_1700000000_0.jpg": &fstest.MapFile{},
+		"kif/2023/story_1600000000_0.jpg": &fstest.MapFile{},
+	}
+	readFile := func(p string) ([]byte, error) { return mockFs[p].Data, nil }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	mockFs["kif/2024/story_1710000000_0.jpg"] = &fstest.MapFile{}
+	mockFs["kif/2023/story_1700000000_0.jpg.json"] = &fstest.MapFile{Data: []byte(`{"caption": "Edited"}`)}
+	delete(mockFs, "kif/2023/story_1600000000_0.jpg")
+
+	index.reindex("kif/2024/story_1710000000_0.jpg", "/kif/2023/story_1700000000_0.jpg", "kif/2023/story_1600000000_0.jpg")
+
+	items := index.all("")
+	if len(items) != 2 || items[0].Path != "kif/2024/story_1710000000_0.jpg" || items[1].Path != "kif/2023/story_1700000000_0.jpg" {
+		t.Fatalf("unexpected items after reindex %+v", items)
+	}
+	if items[1].Caption != "Edited" {
+		t.Errorf("expected changed sidecar to be picked up, got caption %q", items[1].Caption)
+	}
+	if _, ok := index.get("kif/2023/story_1600000000_0.jpg"); ok {
+		t.Error("expected removed media to be removed from index")
+	}
+}
+
+func TestMediaIndexExifHead(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/IMG_0001.jpg": &fstest.MapFile{Data: make([]byte, 4*exifHeadSize)},
+	}
+	readFile := func(p string) ([]byte, error) {
+		t.Errorf("expected only the start of %s to be read", p)
+		return mockFs[p].Data, nil
+	}
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	index := newMediaIndex(mockFs, readFile, sizeFn)
+	read := int64(0)
+	readHead := index.readHead
+	index.readHead = func(p string, n int64) ([]byte, error) {
+		data, err := readHead(p, n)
+		read += int64(len(data))
+		return data, err
+	}
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if read != exifHeadSize {
+		t.Errorf("expected %d bytes to be read for EXIF, got %d", exifHeadSize, read)
+	}
+}
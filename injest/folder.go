@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kif11/gallery2/exif"
+	"github.com/kif11/gallery2/sidecar"
+)
+
+// JPEG EXIF is in the first segments of the file, the rest is image data
+const exifReadLimit = 256 << 10
+
+// Seconds between QuickTime epoch 1904-01-01 and unix epoch
+const quickTimeEpochOffset = 2082844800
+
+var errNoDate = errors.New("no capture date found")
+
+// readPhotoDate reads capture time and location from EXIF of JPEG file
+func readPhotoDate(p string) (time.Time, *sidecar.Location, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, exifReadLimit))
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	ex, err := exif.Decode(data)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	var loc *sidecar.Location
+	if ex.HasLocation {
+		loc = &sidecar.Location{Latitude: ex.Latitude, Longitude: ex.Longitude}
+	}
+	if ex.DateTime.IsZero() {
+		return time.Time{}, loc, errNoDate
+	}
+	return ex.DateTime, loc, nil
+}
+
+// readVideoDate reads creation time from movie header (moov/mvhd) of MP4 and
+// MOV files. The header is often written after the media data, atoms are
+// skipped without reading them.
+func readVideoDate(p string) (time.Time, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	moov, moovSize, err := findAtom(f, 0, info.Size(), "moov")
+	if err != nil {
+		return time.Time{}, err
+	}
+	mvhd, _, err := findAtom(f, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Version and flags, then creation time of 4 bytes in version 0 and 8 in version 1
+	header := make([]byte, 12)
+	if _, err := f.ReadAt(header, mvhd); err != nil {
+		return time.Time{}, err
+	}
+	created := uint64(binary.BigEndian.Uint32(header[4:]))
+	if header[0] == 1 {
+		created = binary.BigEndian.Uint64(header[4:])
+	}
+	// Cameras without a clock write zero
+	if created <= quickTimeEpochOffset {
+		return time.Time{}, errNoDate
+	}
+
+	return time.Unix(int64(created-quickTimeEpochOffset), 0), nil
+}
+
+// findAtom looks for atom of type name between start and end offsets of the
+// file and returns offset and size of its content
+func findAtom(r io.ReaderAt, start int64, end int64, name string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// Atom extends to the end of the file
+			size = end - pos
+		case 1:
+			// 64 bit size follows the type
+			if _, err := r.ReadAt(header[8:16], pos+8); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || pos+size > end {
+			return 0, 0, fmt.Errorf("invalid %s atom at %d", header[4:8], pos)
+		}
+
+		if string(header[4:8]) == name {
+			return pos + headerSize, size - headerSize, nil
+		}
+		pos += size
+	}
+	return 0, 0, errNoDate
+}
+
+// captureTime returns when media file was captured from its metadata,
+// falling back to the file modification time
+func captureTime(p string, mediaType InstType, info fs.FileInfo) (int64, *sidecar.Location) {
+	var date time.Time
+	var loc *sidecar.Location
+	var err error
+	if mediaType == Video {
+		date, err = readVideoDate(p)
+	} else {
+		date, loc, err = readPhotoDate(p)
+	}
+
+	if err != nil {
+		fmt.Printf("No capture date for %s, using file modification time\n", p)
+		return info.ModTime().Unix(), loc
+	}
+	return date.Unix(), loc
+}
+
+// processFolder imports photos and videos from any folder e.g. a camera card
+// into gallery folder of user by their capture date
+func processFolder(user string, srcDir string, dstDir string) error {
+	if _, err := os.Stat(srcDir); err != nil {
+		return err
+	}
+
+	imported, existing, skipped := 0, 0, 0
+
+	// WalkDir goes in lexical order so files get the same index on every run
+	err := filepath.WalkDir(srcDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		mediaType, ok := galleryMediaType(p)
+		if !ok {
+			skipped++
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		ts, loc := captureTime(p, mediaType, info)
+		uri, _ := filepath.Rel(srcDir, p)
+		media := Media{
+			URI:               filepath.ToSlash(uri),
+			CreationTimestamp: ts,
+			Type:              mediaType,
+			User:              user,
+		}
+
+		done, err := importMedia(p, media, dstDir, sidecar.Metadata{
+			SourceURI: media.URI,
+			Type:      string(media.Type),
+			Timestamp: ts,
+			Location:  loc,
+		})
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		if done {
+			imported++
+		} else {
+			existing++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading folder %s: %w", srcDir, err)
+	}
+
+	fmt.Printf("Imported: %d, already imported: %d, skipped files the gallery can not show: %d\n", imported, existing, skipped)
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// atom builds QuickTime atom with 32 bit size
+func atom(name string, content ...[]byte) []byte {
+	data := bytes.Join(content, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, name...), data...)
+}
+
+// atom64 builds QuickTime atom with 64 bit size after the type
+func atom64(name string, content ...[]byte) []byte {
+	data := bytes.Join(content, nil)
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint64(b, uint64(16+len(data)))
+	return append(b, data...)
+}
+
+// mvhd builds movie header of version 0 with creation time in QuickTime epoch
+func mvhd(created uint32) []byte {
+	content := make([]byte, 100)
+	binary.BigEndian.PutUint32(content[4:], created)
+	return atom("mvhd", content)
+}
+
+func TestReadVideoDate(t *testing.T) {
+	date := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	created := uint32(date.Unix() + quickTimeEpochOffset)
+	ftyp := atom("ftyp", []byte("isom\x00\x00\x02\x00"))
+	mdat := atom("mdat", bytes.Repeat([]byte{0xAB}, 64))
+
+	mvhd1 := make([]byte, 112)
+	mvhd1[0] = 1
+	binary.BigEndian.PutUint64(mvhd1[4:], uint64(created))
+
+	tests := []struct {
+		name        string
+		data        []byte
+		expectedErr error
+	}{
+		{"moov before mdat", bytes.Join([][]byte{ftyp, atom("moov", mvhd(created)), mdat}, nil), nil},
+		{"moov after mdat", bytes.Join([][]byte{ftyp, mdat, atom("moov", mvhd(created))}, nil), nil},
+		{"64 bit mdat size", bytes.Join([][]byte{ftyp, atom64("mdat", bytes.Repeat([]byte{0xAB}, 64)), atom("moov", mvhd(created))}, nil), nil},
+		{"version 1 header", bytes.Join([][]byte{ftyp, atom("moov", atom("mvhd", mvhd1))}, nil), nil},
+		{"moov extends to the end", bytes.Join([][]byte{ftyp, {0, 0, 0, 0}, []byte("moov"), mvhd(created)}, nil), nil},
+		{"mdat extends to the end", bytes.Join([][]byte{ftyp, {0, 0, 0, 0}, []byte("mdat"), atom("moov", mvhd(created))}, nil), errNoDate},
+		{"camera without clock", bytes.Join([][]byte{ftyp, atom("moov", mvhd(0))}, nil), errNoDate},
+		{"no moov", bytes.Join([][]byte{ftyp, mdat}, nil), errNoDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(p, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readVideoDate(p)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(date) {
+				t.Errorf("expected %v, got %v", date, got)
+			}
+		})
+	}
+}
+
+func TestFindAtom(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []byte
+		expectedPos  int64
+		expectedSize int64
+		expectError  bool
+	}{
+		{"first atom", atom("moov", []byte("data")), 8, 4, false},
+		{"after other atom", append(atom("free", []byte("xx")), atom("moov", []byte("data"))...), 18, 4, false},
+		{"after 64 bit atom", append(atom64("mdat", []byte("xx")), atom("moov", []byte("data"))...), 26, 4, false},
+		{"64 bit moov", atom64("moov", []byte("data")), 16, 4, false},
+		{"size zero", append([]byte{0, 0, 0, 0}, append([]byte("moov"), "rest"...)...), 8, 4, false},
+		{"size smaller than header", append([]byte{0, 0, 0, 4}, "moov"...), 0, 0, true},
+		{"size past the end", append([]byte{0, 0, 0, 99}, "free"...), 0, 0, true},
+		{"truncated 64 bit size", append([]byte{0, 0, 0, 1}, "mdat"...), 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, size, err := findAtom(bytes.NewReader(tt.data), 0, int64(len(tt.data)), "moov")
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got atom at %d", pos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != tt.expectedPos || size != tt.expectedSize {
+				t.Errorf("expected atom at %d of %d bytes, got %d of %d", tt.expectedPos, tt.expectedSize, pos, size)
+			}
+		})
+	}
+}
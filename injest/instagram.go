@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Folders at the root of Instagram export of one account
+var instagramSections = []string{"content", "media", "your_instagram_activity"}
+
+// Instagram names export archives instagram-<account>-<date>-<id>.zip
+var instagramArchivePattern = regexp.MustCompile(`^instagram-(.+)-\d{4}-\d{2}-\d{2}-[^-]+\.zip$`)
+
+// instagramExport is export of one account inside of the source folder or archive
+type instagramExport struct {
+	user string
+	root string
+}
+
+// isInstagramExport reports whether root folder of fsys is export of an account
+func isInstagramExport(fsys fs.FS, root string) bool {
+	for _, s := range instagramSections {
+		if info, err := fs.Stat(fsys, path.Join(root, s)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// findInstagramExports returns accounts in the source. Export extracted
+// into the source root or an archive of a single account is imported as
+// user, the account is taken from the archive name if user is not set.
+// Otherwise every folder is export of the user it is named after.
+func findInstagramExports(fsys fs.FS, srcName string, user string) ([]instagramExport, error) {
+	if isInstagramExport(fsys, ".") {
+		if user == "" {
+			m := instagramArchivePattern.FindStringSubmatch(srcName)
+			if m == nil {
+				return nil, fmt.Errorf("-user is required to import export of a single account")
+			}
+			user = m[1]
+		}
+		return []instagramExport{{user: user, root: "."}}, nil
+	}
+
+	dirs, err := listDirs(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	exports := []instagramExport{}
+	for _, d := range dirs {
+		exports = append(exports, instagramExport{user: d, root: d})
+	}
+	return exports, nil
+}
+
+// importInstagram imports Instagram export folder or ZIP archive. Media is
+// streamed out of the archive without extracting it.
+func importInstagram(user string, src string, dstDir string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	var fsys fs.FS
+	if info.IsDir() {
+		fsys = os.DirFS(src)
+	} else {
+		archive, err := zip.OpenReader(src)
+		if err != nil {
+			return fmt.Errorf("error opening archive %s: %w", src, err)
+		}
+		defer archive.Close()
+		fsys = archive
+	}
+
+	exports, err := findInstagramExports(fsys, filepath.Base(src), user)
+	if err != nil {
+		return err
+	}
+
+	users := []string{}
+	for _, e := range exports {
+		users = append(users, e.user)
+	}
+	fmt.Println("Users found:", users)
+
+	for _, e := range exports {
+		err := processUserMedia(fsys, e.root, e.user, dstDir)
+		if err != nil {
+			fmt.Printf("Error processing user %s: %v\n", e.user, err)
+			continue
+		}
+	}
+
+	return nil
+}
+
+// Folders with metadata of the export, newest export layout first
+var instagramContentDirs = []string{"your_instagram_activity/content", "your_instagram_activity/media", "content"}
+
+// instagramContentDir returns metadata folder of export in root
+func instagramContentDir(fsys fs.FS, root string) (string, bool) {
+	for _, d := range instagramContentDirs {
+		dir := path.Join(root, d)
+		if info, err := fs.Stat(fsys, dir); err == nil && info.IsDir() {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// Posts are split into numbered files
+var instagramPostsPattern = regexp.MustCompile(`^posts_(\d+)\.json$`)
+
+// instagramPostsFiles returns posts files of metadata folder dir in their order
+func instagramPostsFiles(fsys fs.FS, dir string) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type postsFile struct {
+		name   string
+		number int
+	}
+	found := []postsFile{}
+	for _, e := range entries {
+		m := instagramPostsPattern.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		found = append(found, postsFile{path.Join(dir, e.Name()), n})
+	}
+	// posts_10.json goes after posts_9.json
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].number < found[j].number
+	})
+
+	files := []string{}
+	for _, f := range found {
+		files = append(files, f.name)
+	}
+	return files, nil
+}
+
+// instagramSection counts files and media read from a part of the export
+type instagramSection struct {
+	name  string
+	files int
+	items int
+	// Metadata files that could not be read
+	failed int
+}
+
+// read reads metadata file of the section, missing file means the account
+// has nothing in the section
+func (s *instagramSection) read(fsys fs.FS, name string, target any) bool {
+	err := readJsonFS(fsys, name, target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		fmt.Printf("error reading %s metadata: %s. Skipping\n", strings.ToLower(s.name), err)
+		s.failed++
+		return false
+	}
+	s.files++
+	return true
+}
+
+// add appends media of the section to all media
+func (s *instagramSection) add(all *[]Media, media []Media) {
+	s.items += len(media)
+	*all = append(*all, media...)
+}
+
+// report prints what was found in the section
+func (s instagramSection) report() {
+	switch {
+	case s.files == 0 && s.failed == 0:
+		fmt.Printf("%s: not found\n", s.name)
+	case s.failed > 0:
+		fmt.Printf("%s: %d media in %d file(s), %d file(s) could not be read\n", s.name, s.items, s.files, s.failed)
+	default:
+		fmt.Printf("%s: %d media in %d file(s)\n", s.name, s.items, s.files)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestInstagramPostsFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		expected []string
+	}{
+		{
+			name:     "single file",
+			files:    []string{"posts_1.json"},
+			expected: []string{"content/posts_1.json"},
+		},
+		{
+			name:     "numbers past 9",
+			files:    []string{"posts_10.json", "posts_2.json", "posts_1.json", "posts_9.json", "posts_11.json"},
+			expected: []string{"content/posts_1.json", "content/posts_2.json", "content/posts_9.json", "content/posts_10.json", "content/posts_11.json"},
+		},
+		{
+			name:     "other files",
+			files:    []string{"posts_1.json", "stories.json", "reels.json", "posts_1.json.bak", "archived_posts.json"},
+			expected: []string{"content/posts_1.json"},
+		},
+		{
+			name:     "no posts",
+			files:    []string{"stories.json"},
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, f := range tt.files {
+				fsys["content/"+f] = &fstest.MapFile{Data: []byte("[]")}
+			}
+			// Folder named like posts file is not read
+			fsys["content/posts_3.json/file"] = &fstest.MapFile{}
+
+			files, err := instagramPostsFiles(fsys, "content")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(files, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, files)
+			}
+		})
+	}
+}
+
+func TestInstagramContentDir(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		expected   string
+		expectedOk bool
+	}{
+		{"current layout", []string{"kif_20240101/your_instagram_activity/content/posts_1.json"}, "kif_20240101/your_instagram_activity/content", true},
+		{"media layout", []string{"kif_20240101/your_instagram_activity/media/posts_1.json"}, "kif_20240101/your_instagram_activity/media", true},
+		{"older layout", []string{"kif_20240101/content/posts_1.json"}, "kif_20240101/content", true},
+		{"newest layout wins", []string{"kif_20240101/content/posts_1.json", "kif_20240101/your_instagram_activity/content/posts_1.json"}, "kif_20240101/your_instagram_activity/content", true},
+		{"file named like folder", []string{"kif_20240101/content"}, "", false},
+		{"not an export", []string{"kif_20240101/photos/photo.jpg"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, f := range tt.files {
+				fsys[f] = &fstest.MapFile{}
+			}
+
+			dir, ok := instagramContentDir(fsys, "kif_20240101")
+			if dir != tt.expected || ok != tt.expectedOk {
+				t.Errorf("expected %q %v, got %q %v", tt.expected, tt.expectedOk, dir, ok)
+			}
+		})
+	}
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/kif11/gallery2/sidecar"
 )
 
 type PhotoMetadata struct {
@@ -46,6 +52,9 @@ type MediaMetadata struct {
 		ExifData []struct {
 			Latitude      float64 `json:"latitude"`
 			Longitude     float64 `json:"longitude"`
+			DeviceID      string  `json:"device_id"`
+			LensMake      string  `json:"lens_make"`
+			LensModel     string  `json:"lens_model"`
 			PhotoMetadata `json:"photo_metadata,omitempty"`
 			VideoMetadata `json:"video_metadata,omitempty"`
 		} `json:"exif_data"`
@@ -59,6 +68,13 @@ const (
 	IgTv  InstType = "igtv"
 	Story InstType = "story"
 	Reel  InstType = "reel"
+	// Media from sources without posts e.g. Google Photos
+	Photo InstType = "photo"
+	Video InstType = "video"
+	// Media attached to a tweet in Twitter/X archive
+	Tweet InstType = "tweet"
+	// Media of a message in Telegram export
+	Message InstType = "message"
 )
 
 type Media struct {
@@ -87,8 +103,8 @@ type Reels struct {
 	IGReels []MediaList `json:"ig_reels_media"`
 }
 
-func listDirs(path string) ([]string, error) {
-	entries, err := os.ReadDir(path)
+func listDirs(fsys fs.FS, path string) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, path)
 	if err != nil {
 		fmt.Println("Error reading directory:", err)
 		return []string{}, err
@@ -111,14 +127,23 @@ func copyFile(srcPath, dstPath string) error {
 	}
 	defer srcFile.Close()
 
+	return writeFile(srcFile, dstPath)
+}
+
+// writeFile writes content of src to dstPath. Partly written file is removed
+// so the next import does not take it for imported media.
+func writeFile(src io.Reader, dstPath string) error {
 	dstFile, err := os.Create(dstPath)
 	if err != nil {
 		return fmt.Errorf("could not create destination file: %w", err)
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
+	_, err = io.Copy(dstFile, src)
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(dstPath)
 		return fmt.Errorf("error while copying file: %w", err)
 	}
 
@@ -150,7 +175,12 @@ func fileName(path string) string {
 }
 
 func readJson(jsonFile string, target any) error {
-	file, err := os.Open(jsonFile)
+	return readJsonFS(os.DirFS(filepath.Dir(jsonFile)), filepath.Base(jsonFile), target)
+}
+
+// readJsonFS reads JSON file from a folder or an archive
+func readJsonFS(fsys fs.FS, name string, target any) error {
+	file, err := fsys.Open(name)
 	if err != nil {
 		return err
 	}
@@ -208,121 +238,341 @@ func hydrateMedia(media []Media, mediaType InstType, user string) []Media {
 	return newMedia
 }
 
-func processUserMedia(user string, srcDir string, dstDir string) error {
-	// Read posts metadata
-	postsFile := fmt.Sprintf("%s/%s/content/posts_1.json", srcDir, user)
-	mediaList := []MediaList{}
+// galleryMediaType returns whether file p is a photo or a video. HEIC and
+// other formats are left out, the gallery would not list them.
+func galleryMediaType(p string) (InstType, bool) {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return Photo, true
+	case ".mp4", ".mov", ".webm":
+		return Video, true
+	}
+	return "", false
+}
+
+// mediaIndices numbers media of the same type taken in the same second, so
+// every file gets its own name
+type mediaIndices map[string]int
+
+// next returns index of media, media must be passed in a stable order so
+// the numbers stay the same when the import runs again
+func (n mediaIndices) next(m Media) int {
+	key := fmt.Sprintf("%s_%d", m.Type, m.CreationTimestamp)
+	idx := n[key]
+	n[key]++
+	return idx
+}
+
+// processUserMedia imports Instagram export of user found in root folder of fsys,
+// which is a folder on disk or an export archive
+func processUserMedia(fsys fs.FS, root string, user string, dstDir string) error {
+	contentDir, ok := instagramContentDir(fsys, root)
+	if !ok {
+		return fmt.Errorf("no content folder found in %s", root)
+	}
+	fmt.Printf("Export layout: %s\n", strings.TrimPrefix(contentDir, root+"/"))
+
 	allMedia := []Media{}
+	sections := []instagramSection{}
 
-	err := readJson(postsFile, &mediaList)
+	// Read posts metadata, large accounts have posts_1.json, posts_2.json, ...
+	postsFiles, err := instagramPostsFiles(fsys, contentDir)
 	if err != nil {
-		fmt.Printf("error reading posts metadata: %s. Skipping\n", err)
+		return err
+	}
+	posts := instagramSection{name: "Posts"}
+	for _, f := range postsFiles {
+		mediaList := []MediaList{}
+		if !posts.read(fsys, f, &mediaList) {
+			continue
+		}
+		for _, m := range mediaList {
+			posts.add(&allMedia, hydrateMedia(m.Media, Post, user))
+		}
 	}
+	sections = append(sections, posts)
 
 	// Read IgTv metadata
-	igTvFile := fmt.Sprintf("%s/%s/content/igtv_videos.json", srcDir, user)
 	igTvList := IgTvMedia{}
-
-	err = readJson(igTvFile, &igTvList)
-	if err != nil {
-		fmt.Printf("error reading igtv metadata: %s. Skipping\n", err)
+	igTv := instagramSection{name: "IGTV"}
+	if igTv.read(fsys, path.Join(contentDir, "igtv_videos.json"), &igTvList) {
+		for _, m := range igTvList.IgTvMedia {
+			igTv.add(&allMedia, hydrateMedia(m.Media, IgTv, user))
+		}
 	}
+	sections = append(sections, igTv)
 
 	// Process stories
-	storiesFile := fmt.Sprintf("%s/%s/content/stories.json", srcDir, user)
 	stories := Stories{}
-
-	err = readJson(storiesFile, &stories)
-	if err != nil {
-		fmt.Printf("error reading stories metadata: %s. Skipping\n", err)
+	storiesSection := instagramSection{name: "Stories"}
+	if storiesSection.read(fsys, path.Join(contentDir, "stories.json"), &stories) {
+		storiesSection.add(&allMedia, hydrateMedia(stories.IGStories, Story, user))
 	}
+	sections = append(sections, storiesSection)
 
 	// Process reels
-	reelsFile := fmt.Sprintf("%s/%s/content/reels.json", srcDir, user)
 	reels := Reels{}
+	reelsSection := instagramSection{name: "Reels"}
+	if reelsSection.read(fsys, path.Join(contentDir, "reels.json"), &reels) {
+		for _, m := range reels.IGReels {
+			reelsSection.add(&allMedia, hydrateMedia(m.Media, Reel, user))
+		}
+	}
+	sections = append(sections, reelsSection)
+
+	for _, section := range sections {
+		section.report()
+	}
 
-	err = readJson(reelsFile, &reels)
+	// Each post can have multiple images and videos
+	for _, media := range allMedia {
+		dstPath := makeDstPath(media, dstDir)
+
+		err := importFromFS(fsys, path.Join(root, media.URI), dstPath)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		// Sidecar is written for already imported media too so re-running
+		// ingest adds metadata to older imports
+		err = writeSidecar(media, dstPath)
+		if err != nil {
+			fmt.Println("Error writing sidecar:", err)
+		}
+	}
+
+	return nil
+}
+
+// sameContent reports whether files a and b have the same content
+func sameContent(a string, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
 	if err != nil {
-		fmt.Printf("error reading reels metadata: %s. Skipping\n", err)
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
 	}
 
-	// Append post
-	for _, m := range mediaList {
-		allMedia = append(allMedia, hydrateMedia(m.Media, Post, user)...)
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, 64<<10)
+	bufB := make([]byte, 64<<10)
+	for {
+		n, errA := io.ReadFull(fa, bufA)
+		_, errB := io.ReadFull(fb, bufB[:n])
+		if errB != nil {
+			return false, errB
+		}
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return true, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
 	}
+}
 
-	// Append igTv
-	for _, m := range igTvList.IgTvMedia {
-		allMedia = append(allMedia, hydrateMedia(m.Media, IgTv, user)...)
+// importDstPath returns where media file src is imported to. The index is
+// bumped while another file is already at the path, the same file found
+// there means it was imported before.
+func importDstPath(media Media, src string, dstDir string) (string, bool, error) {
+	for {
+		dstPath := makeDstPath(media, dstDir)
+		exists, err := fileExists(dstPath)
+		if err != nil || !exists {
+			return dstPath, false, err
+		}
+
+		same, err := sameContent(src, dstPath)
+		if err != nil || same {
+			return dstPath, same, err
+		}
+		media.Index++
 	}
+}
 
-	// Append stories
-	allMedia = append(allMedia, hydrateMedia(stories.IGStories, Story, user)...)
+// importMedia copies media into the gallery and writes its sidecar. Media
+// taken in the same second as another file in the gallery gets the next free
+// index, so neither the file nor its sidecar is lost. Reports false when the
+// media was imported before.
+func importMedia(srcPath string, media Media, dstDir string, meta sidecar.Metadata) (bool, error) {
+	dstPath, done, err := importDstPath(media, srcPath, dstDir)
+	if err != nil || done {
+		return false, err
+	}
 
-	// Append reels
-	for _, m := range reels.IGReels {
-		allMedia = append(allMedia, hydrateMedia(m.Media, Reel, user)...)
+	err = copyFile(srcPath, dstPath)
+	if err != nil {
+		return false, fmt.Errorf("error copying file: %w", err)
 	}
 
-	// // Debug
-	// for i, v := range allMedia {
-	// 	if i < 100 {
-	// 		fmt.Printf("Idx: %d, %+v\n", i, v)
-	// 	}
-	// }
-	// os.Exit(0)
+	err = writeMetadata(dstPath, meta)
+	if err != nil {
+		fmt.Println("Error writing sidecar:", err)
+	}
+	return true, nil
+}
 
-	newMedia := make(map[string][]Media)
+// importFromFS copies media from a folder or an archive into the gallery
+// unless it was imported already. Archive entries are streamed without
+// extracting them first.
+func importFromFS(fsys fs.FS, name string, dstPath string) error {
+	exists, err := fileExists(dstPath)
+	if err != nil || exists {
+		return err
+	}
 
-	// Each post can have multiple images and videos
-	for _, media := range allMedia {
-		srcPath := filepath.Join(srcDir, user, media.URI)
-		dstPath := makeDstPath(media, dstDir)
+	src, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("error copying file: %w", err)
+	}
+	defer src.Close()
 
-		exists, err := fileExists(dstPath)
-		if err != nil {
-			fmt.Println(err)
-			continue
+	err = writeFile(src, dstPath)
+	if err != nil {
+		return fmt.Errorf("error copying file: %w", err)
+	}
+	return nil
+}
+
+// Instagram export stores UTF-8 text as if every byte was a separate
+// latin-1 character e.g. "caf\u00c3\u00a9" instead of "café".
+// fixEncoding reverses that. Text that is not mangled this way is returned as is.
+func fixEncoding(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			return s
 		}
+		b = append(b, byte(r))
+	}
+	if !utf8.Valid(b) {
+		return s
+	}
+	return string(b)
+}
 
-		if exists {
-			continue
+// location returns coordinates of the media from Instagram EXIF data if present
+func (m Media) location() *sidecar.Location {
+	for _, e := range m.MediaMetadata.PhotoMetadata.ExifData {
+		if e.Latitude != 0 || e.Longitude != 0 {
+			return &sidecar.Location{Latitude: e.Latitude, Longitude: e.Longitude}
 		}
+	}
+	return nil
+}
 
-		err = copyFile(srcPath, dstPath)
-		if err != nil {
-			fmt.Println("Error copying file:", err)
+// device returns name of the camera the media was captured with
+func (m Media) device() string {
+	for _, e := range m.MediaMetadata.PhotoMetadata.ExifData {
+		if name := strings.TrimSpace(e.LensMake + " " + e.LensModel); name != "" {
+			return name
+		}
+	}
+	for _, e := range m.MediaMetadata.PhotoMetadata.ExifData {
+		if e.DeviceID != "" {
+			return e.DeviceID
 		}
+	}
+	return ""
+}
 
-		newMedia[dstPath] = append(newMedia[dstPath], media)
+// writeSidecar stores media metadata from the export next to imported file
+func writeSidecar(media Media, dstPath string) error {
+	return writeMetadata(dstPath, sidecar.Metadata{
+		Caption:   fixEncoding(media.Title),
+		SourceURI: media.URI,
+		Type:      string(media.Type),
+		Timestamp: media.CreationTimestamp,
+		Location:  media.location(),
+		Device:    media.device(),
+	})
+}
+
+// writeMetadata writes sidecar of imported file, existing sidecar is kept
+func writeMetadata(dstPath string, meta sidecar.Metadata) error {
+	exists, err := fileExists(sidecar.Path(dstPath))
+	if err != nil || exists {
+		return err
 	}
 
-	return nil
+	return sidecar.Write(dstPath, meta)
 }
 
 func main() {
+	source := flag.String("source", "instagram", "format of the export: instagram, takeout, twitter, telegram, folder")
+	user := flag.String("user", "", "gallery folder to import into, required for exports without user folders unless the archive is named after the account")
+	flag.Usage = func() {
+		fmt.Printf("Usage: ingest [-source instagram|takeout|twitter|telegram|folder] [-user name] <data_dir> <destination_dir>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	if len(os.Args) < 3 {
-		fmt.Printf("Usage: ingest <instagram_data_dir> <destination_dir>\n")
+	if flag.NArg() < 2 {
+		flag.Usage()
 		os.Exit(0)
 	}
 
-	srcMetadataDir := os.Args[1]
-	destinationDir := os.Args[2]
+	srcMetadataDir := flag.Arg(0)
+	destinationDir := flag.Arg(1)
 
-	users, err := listDirs(srcMetadataDir)
-	if err != nil {
-		fmt.Println(err)
+	if *source != "instagram" && *user == "" {
+		fmt.Printf("-user is required to import %s export\n", *source)
 		os.Exit(1)
 	}
 
-	fmt.Println("Users found:", users)
-
-	for _, user := range users {
-		err := processUserMedia(user, srcMetadataDir, destinationDir)
+	switch *source {
+	case "instagram":
+		err := importInstagram(*user, srcMetadataDir, destinationDir)
 		if err != nil {
-			fmt.Printf("Error processing user %s: %v\n", user, err)
-			continue
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "takeout":
+		err := processTakeout(*user, srcMetadataDir, destinationDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "twitter":
+		err := processTwitter(*user, srcMetadataDir, destinationDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	case "telegram":
+		err := processTelegram(*user, srcMetadataDir, destinationDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "folder":
+		err := processFolder(*user, srcMetadataDir, destinationDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Unknown source %s\n", *source)
+		flag.Usage()
+		os.Exit(1)
 	}
 }
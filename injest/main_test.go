@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kif11/gallery2/sidecar"
+)
+
+func TestImportMedia(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	first := filepath.Join(srcDir, "first.jpg")
+	second := filepath.Join(srcDir, "second.jpg")
+	os.WriteFile(first, []byte("first"), 0644)
+	os.WriteFile(second, []byte("second"), 0644)
+
+	media := Media{URI: "first.jpg", CreationTimestamp: 1700000000, Type: Tweet, User: "kif"}
+	dst := filepath.Join(dstDir, "kif", "2023")
+
+	tests := []struct {
+		name     string
+		src      string
+		caption  string
+		imported bool
+		dstPath  string
+	}{
+		{"new media", first, "first caption", true, "tweet_1700000000_0.jpg"},
+		{"imported again", first, "first caption", false, ""},
+		{"other file in the same second", second, "second caption", true, "tweet_1700000000_1.jpg"},
+		{"other file imported again", second, "second caption", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imported, err := importMedia(tt.src, media, dstDir, sidecar.Metadata{Caption: tt.caption})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imported != tt.imported {
+				t.Fatalf("expected imported %v, got %v", tt.imported, imported)
+			}
+			if !tt.imported {
+				return
+			}
+
+			p := filepath.Join(dst, tt.dstPath)
+			same, err := sameContent(tt.src, p)
+			if err != nil || !same {
+				t.Errorf("expected %s to be copied to %s: %v", tt.src, p, err)
+			}
+			data, err := os.ReadFile(sidecar.Path(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := sidecar.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Caption != tt.caption {
+				t.Errorf("expected caption %q, got %q", tt.caption, meta.Caption)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kif11/gallery2/sidecar"
+)
+
+// TakeoutMetadata is the JSON file Google Photos Takeout stores next to every media file
+type TakeoutMetadata struct {
+	Title          string           `json:"title"`
+	Description    string           `json:"description"`
+	PhotoTakenTime TakeoutTimestamp `json:"photoTakenTime"`
+	CreationTime   TakeoutTimestamp `json:"creationTime"`
+	GeoData        TakeoutGeoData   `json:"geoData"`
+	GeoDataExif    TakeoutGeoData   `json:"geoDataExif"`
+}
+
+type TakeoutTimestamp struct {
+	// Unix timestamp in seconds as a string
+	Timestamp string `json:"timestamp"`
+}
+
+type TakeoutGeoData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// timestamp returns when media was taken, falling back to upload time
+func (m TakeoutMetadata) timestamp() int64 {
+	for _, t := range []TakeoutTimestamp{m.PhotoTakenTime, m.CreationTime} {
+		if ts, err := strconv.ParseInt(t.Timestamp, 10, 64); err == nil && ts > 0 {
+			return ts
+		}
+	}
+	return 0
+}
+
+// location returns coordinates set in Google Photos or read from EXIF.
+// Takeout writes zeros for media without location.
+func (m TakeoutMetadata) location() *sidecar.Location {
+	for _, g := range []TakeoutGeoData{m.GeoData, m.GeoDataExif} {
+		if g.Latitude != 0 || g.Longitude != 0 {
+			return &sidecar.Location{Latitude: g.Latitude, Longitude: g.Longitude}
+		}
+	}
+	return nil
+}
+
+// Copies of media with the same name get a number, IMG_1234(1).jpg
+var takeoutCopyPattern = regexp.MustCompile(`^(.*)(\(\d+\))(\.[^.]*)$`)
+
+// takeoutSidecars returns candidate names of the JSON file of media file name.
+// Takeout names it after the media with ".json" or ".supplemental-metadata.json",
+// puts the copy number after the extension, shares the JSON of the original
+// with "-edited" media and cuts long names to 51 characters.
+func takeoutSidecars(name string) []string {
+	names := []string{name}
+	if m := takeoutCopyPattern.FindStringSubmatch(name); m != nil {
+		names = []string{m[1] + m[3] + m[2]}
+	}
+	ext := filepath.Ext(name)
+	if base, ok := strings.CutSuffix(strings.TrimSuffix(name, ext), "-edited"); ok {
+		names = append(names, base+ext)
+	}
+
+	candidates := []string{}
+	for _, n := range names {
+		for _, suffix := range []string{".supplemental-metadata.json", ".json"} {
+			c := n + suffix
+			if len(c) > 51 {
+				c = c[:51-len(".json")] + ".json"
+			}
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
+
+// takeoutMedia is media file found in the export with its metadata
+type takeoutMedia struct {
+	Media
+	meta TakeoutMetadata
+}
+
+// readTakeout finds media in Takeout folder and pairs it with its JSON file.
+// The same media is listed in the year folder and in every album it is in,
+// only one copy is returned.
+func readTakeout(user string, srcDir string) ([]takeoutMedia, error) {
+	found := []takeoutMedia{}
+	seen := make(map[string]bool)
+	skipped := 0
+
+	err := filepath.WalkDir(srcDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".json") {
+			return nil
+		}
+
+		mediaType, ok := galleryMediaType(p)
+		if !ok {
+			skipped++
+			return nil
+		}
+
+		meta := TakeoutMetadata{}
+		metaFound := false
+		for _, c := range takeoutSidecars(d.Name()) {
+			candidate := TakeoutMetadata{}
+			if err := readJson(filepath.Join(filepath.Dir(p), c), &candidate); err == nil {
+				meta = candidate
+				metaFound = true
+				break
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		ts := meta.timestamp()
+		if !metaFound || ts == 0 {
+			fmt.Printf("No metadata for %s, using file modification time\n", p)
+			ts = info.ModTime().Unix()
+		}
+
+		uri, _ := filepath.Rel(srcDir, p)
+		m := takeoutMedia{
+			Media: Media{
+				URI:               filepath.ToSlash(uri),
+				CreationTimestamp: ts,
+				Type:              mediaType,
+				User:              user,
+			},
+			meta: meta,
+		}
+
+		// Edited media shares the title of the original
+		edited := strings.Contains(d.Name(), "-edited")
+		key := fmt.Sprintf("%s %t %d %d", takeoutTitle(m), edited, ts, info.Size())
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+		found = append(found, m)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if skipped > 0 {
+		fmt.Printf("Skipped %d files the gallery can not show\n", skipped)
+	}
+
+	indexMedia(found)
+
+	return found, nil
+}
+
+// indexMedia numbers media taken in the same second. Media is sorted by its
+// path in the export so the numbers stay the same when the import runs again.
+func indexMedia(media []takeoutMedia) {
+	sort.Slice(media, func(i, j int) bool {
+		return media[i].URI < media[j].URI
+	})
+
+	indices := mediaIndices{}
+	for i := range media {
+		media[i].Index = indices.next(media[i].Media)
+	}
+}
+
+// processTakeout imports Google Photos Takeout folder into gallery folder of user
+func processTakeout(user string, srcDir string, dstDir string) error {
+	if _, err := os.Stat(srcDir); err != nil {
+		return err
+	}
+
+	media, err := readTakeout(user, srcDir)
+	if err != nil {
+		return fmt.Errorf("error reading takeout %s: %w", srcDir, err)
+	}
+
+	fmt.Printf("Media found: %d\n", len(media))
+
+	for _, m := range media {
+		srcPath := filepath.Join(srcDir, filepath.FromSlash(m.URI))
+		_, err := importMedia(srcPath, m.Media, dstDir, sidecar.Metadata{
+			Caption:   m.meta.Description,
+			SourceURI: m.URI,
+			Type:      string(m.Type),
+			Timestamp: m.CreationTimestamp,
+			Location:  m.meta.location(),
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// takeoutTitle returns name media had when it was uploaded to Google Photos
+func takeoutTitle(m takeoutMedia) string {
+	if m.meta.Title != "" {
+		return m.meta.Title
+	}
+	return path.Base(m.URI)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kif11/gallery2/sidecar"
+)
+
+// TelegramExport is result.json of Telegram Desktop export. Export of a
+// single chat has messages at the top, export of the account has a list of chats.
+type TelegramExport struct {
+	TelegramChat
+	Chats struct {
+		List []TelegramChat `json:"list"`
+	} `json:"chats"`
+}
+
+type TelegramChat struct {
+	Name     string            `json:"name"`
+	Messages []TelegramMessage `json:"messages"`
+}
+
+type TelegramMessage struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+	// Local time of the export e.g. "2023-11-14T22:13:20"
+	Date         string `json:"date"`
+	DateUnixtime string `json:"date_unixtime"`
+	// Messages sent together as an album share the group id
+	GroupedID int64        `json:"grouped_id"`
+	Text      telegramText `json:"text"`
+	// Paths relative to result.json, e.g. photos/photo_1@14-11-2023_22-13-20.jpg
+	Photo     string `json:"photo"`
+	File      string `json:"file"`
+	MediaType string `json:"media_type"`
+}
+
+// telegramText is message text, a string or a list of strings and
+// formatted parts like {"type": "bold", "text": "..."}
+type telegramText string
+
+func (t *telegramText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = telegramText(s)
+		return nil
+	}
+
+	parts := []json.RawMessage{}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	b := strings.Builder{}
+	for _, p := range parts {
+		var part struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(p, &s); err == nil {
+			b.WriteString(s)
+		} else if err := json.Unmarshal(p, &part); err == nil {
+			b.WriteString(part.Text)
+		}
+	}
+	*t = telegramText(b.String())
+	return nil
+}
+
+// timestamp returns when message was sent
+func (m TelegramMessage) timestamp() (int64, error) {
+	if ts, err := strconv.ParseInt(m.DateUnixtime, 10, 64); err == nil {
+		return ts, nil
+	}
+	// Older exports only have local time
+	date, err := time.ParseInLocation("2006-01-02T15:04:05", m.Date, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return date.Unix(), nil
+}
+
+// mediaPath returns exported file of the message the gallery can show.
+// Files left out of the export have a note instead of the path.
+func (m TelegramMessage) mediaPath() (string, bool) {
+	p := m.Photo
+	if p == "" {
+		// Stickers, voice messages and music are not gallery media
+		switch m.MediaType {
+		case "sticker", "voice_message", "audio_file":
+			return "", false
+		}
+		p = m.File
+	}
+	if p == "" || strings.HasPrefix(p, "(") {
+		return "", false
+	}
+	if _, ok := galleryMediaType(p); !ok {
+		return "", false
+	}
+	return filepath.ToSlash(p), true
+}
+
+// telegramMedia is media file of a message found in the export
+type telegramMedia struct {
+	Media
+	caption string
+}
+
+// findTelegramMedia returns media of messages in the order they were sent.
+// Media of an album gets the time of its first message and the following
+// indices, the album caption is shared by all of its media. Indices are
+// shared by chats of the export, their media goes into the same folder.
+func findTelegramMedia(user string, messages []TelegramMessage, indices mediaIndices) []telegramMedia {
+	type album struct {
+		ts      int64
+		caption string
+	}
+	albums := make(map[int64]*album)
+	for _, m := range messages {
+		if m.GroupedID == 0 {
+			continue
+		}
+		a := albums[m.GroupedID]
+		if a == nil {
+			ts, err := m.timestamp()
+			if err != nil {
+				continue
+			}
+			a = &album{ts: ts}
+			albums[m.GroupedID] = a
+		}
+		if a.caption == "" {
+			a.caption = strings.TrimSpace(string(m.Text))
+		}
+	}
+
+	found := []telegramMedia{}
+	for _, m := range messages {
+		p, ok := m.mediaPath()
+		if m.Type != "message" || !ok {
+			continue
+		}
+
+		ts, err := m.timestamp()
+		if err != nil {
+			fmt.Printf("Invalid time of message %d: %s. Skipping\n", m.ID, err)
+			continue
+		}
+		caption := strings.TrimSpace(string(m.Text))
+		if a := albums[m.GroupedID]; a != nil {
+			ts, caption = a.ts, a.caption
+		}
+
+		media := Media{URI: p, CreationTimestamp: ts, Type: Message, User: user}
+		media.Index = indices.next(media)
+		found = append(found, telegramMedia{Media: media, caption: caption})
+	}
+
+	return found
+}
+
+// processTelegram imports media of Telegram Desktop JSON export into gallery folder of user
+func processTelegram(user string, srcDir string, dstDir string) error {
+	export := TelegramExport{}
+	err := readJson(filepath.Join(srcDir, "result.json"), &export)
+	if err != nil {
+		return fmt.Errorf("error reading telegram export: %w", err)
+	}
+
+	chats := append([]TelegramChat{export.TelegramChat}, export.Chats.List...)
+	indices := mediaIndices{}
+	for _, chat := range chats {
+		if len(chat.Messages) == 0 {
+			continue
+		}
+
+		media := findTelegramMedia(user, chat.Messages, indices)
+		fmt.Printf("Chat %q messages found: %d, media found: %d\n", chat.Name, len(chat.Messages), len(media))
+
+		for _, m := range media {
+			srcPath := filepath.Join(srcDir, filepath.FromSlash(m.URI))
+			_, err := importMedia(srcPath, m.Media, dstDir, sidecar.Metadata{
+				Caption:   m.caption,
+				SourceURI: m.URI,
+				Type:      string(m.Type),
+				Timestamp: m.CreationTimestamp,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+
+	return nil
+}
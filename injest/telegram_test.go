@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTelegramTextUnmarshal(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		expected    string
+		expectError bool
+	}{
+		{"plain text", `"Sunset at the beach"`, "Sunset at the beach", false},
+		{"empty text", `""`, "", false},
+		{"formatted parts", `["Sunset ", {"type": "bold", "text": "at the beach"}, " #travel"]`, "Sunset at the beach #travel", false},
+		{"link part", `[{"type": "link", "text": "https://example.com"}]`, "https://example.com", false},
+		{"empty list", `[]`, "", false},
+		{"number", `42`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var text telegramText
+			err := json.Unmarshal([]byte(tt.data), &text)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(text) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, text)
+			}
+		})
+	}
+}
+
+func TestFindTelegramMedia(t *testing.T) {
+	type found struct {
+		URI     string
+		Ts      int64
+		Index   int
+		Caption string
+	}
+
+	tests := []struct {
+		name     string
+		messages string
+		expected []found
+	}{
+		{
+			name: "single photos",
+			messages: `[
+				{"id": 1, "type": "message", "date_unixtime": "1700000000", "photo": "photos/photo_1.jpg", "text": "first"},
+				{"id": 2, "type": "message", "date_unixtime": "1700000000", "photo": "photos/photo_2.jpg", "text": ""}
+			]`,
+			expected: []found{
+				{"photos/photo_1.jpg", 1700000000, 0, "first"},
+				{"photos/photo_2.jpg", 1700000000, 1, ""},
+			},
+		},
+		{
+			name: "album shares time and caption of its messages",
+			messages: `[
+				{"id": 1, "type": "message", "date_unixtime": "1700000000", "grouped_id": 77, "photo": "photos/photo_1.jpg", "text": ""},
+				{"id": 2, "type": "message", "date_unixtime": "1700000001", "grouped_id": 77, "file": "video_files/video_2.mp4", "media_type": "video_file", "text": ["Trip ", {"type": "bold", "text": "day 1"}]},
+				{"id": 3, "type": "message", "date_unixtime": "1700000002", "photo": "photos/photo_3.jpg", "text": "alone"}
+			]`,
+			expected: []found{
+				{"photos/photo_1.jpg", 1700000000, 0, "Trip day 1"},
+				{"video_files/video_2.mp4", 1700000000, 1, "Trip day 1"},
+				{"photos/photo_3.jpg", 1700000002, 0, "alone"},
+			},
+		},
+		{
+			name: "service messages, stickers and files left out are skipped",
+			messages: `[
+				{"id": 1, "type": "service", "date_unixtime": "1700000000", "photo": "photos/photo_1.jpg"},
+				{"id": 2, "type": "message", "date_unixtime": "1700000000", "file": "stickers/sticker.webp", "media_type": "sticker"},
+				{"id": 3, "type": "message", "date_unixtime": "1700000000", "file": "(File not included. Change data exporting settings to download.)", "media_type": "video_file"},
+				{"id": 4, "type": "message", "date_unixtime": "1700000000", "file": "files/notes.pdf"},
+				{"id": 5, "type": "message", "date_unixtime": "1700000000", "photo": "photos/photo_5.jpg"}
+			]`,
+			expected: []found{
+				{"photos/photo_5.jpg", 1700000000, 0, ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := []TelegramMessage{}
+			if err := json.Unmarshal([]byte(tt.messages), &messages); err != nil {
+				t.Fatal(err)
+			}
+
+			got := []found{}
+			for _, m := range findTelegramMedia("kif", messages, mediaIndices{}) {
+				got = append(got, found{m.URI, m.CreationTimestamp, m.Index, m.caption})
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
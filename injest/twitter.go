@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kif11/gallery2/sidecar"
+)
+
+// TweetEntry is an element of the array in tweets.js
+type TweetEntry struct {
+	Tweet TweetData `json:"tweet"`
+}
+
+type TweetData struct {
+	ID        string `json:"id_str"`
+	CreatedAt string `json:"created_at"`
+	FullText  string `json:"full_text"`
+	Entities  struct {
+		Media []TweetMedia `json:"media"`
+	} `json:"entities"`
+	// All media of the tweet, entities only have the first one
+	ExtendedEntities struct {
+		Media []TweetMedia `json:"media"`
+	} `json:"extended_entities"`
+}
+
+type TweetMedia struct {
+	ID       string `json:"id_str"`
+	Type     string `json:"type"`
+	MediaURL string `json:"media_url_https"`
+	// Shortened link to the media appended to the tweet text
+	URL       string `json:"url"`
+	VideoInfo struct {
+		Variants []struct {
+			// String in the archive e.g. "2176000", missing for playlists
+			Bitrate     string `json:"bitrate"`
+			ContentType string `json:"content_type"`
+			URL         string `json:"url"`
+		} `json:"variants"`
+	} `json:"video_info"`
+}
+
+// Time layout of created_at e.g. "Wed Oct 10 20:19:24 +0000 2018"
+const tweetTimeLayout = "Mon Jan 02 15:04:05 -0700 2006"
+
+// media returns media of the tweet in the order it was attached
+func (t TweetData) media() []TweetMedia {
+	if len(t.ExtendedEntities.Media) > 0 {
+		return t.ExtendedEntities.Media
+	}
+	return t.Entities.Media
+}
+
+// caption returns tweet text without links to its own media. Text in the
+// archive is HTML escaped.
+func (t TweetData) caption() string {
+	text := t.FullText
+	for _, m := range t.media() {
+		if m.URL != "" {
+			text = strings.ReplaceAll(text, m.URL, "")
+		}
+	}
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// fileNames returns names the archive may store media with. Files in
+// tweets_media are named <tweet id>-<name of the media URL>, videos use the
+// name of one of their variants. MP4 variants with the highest bitrate come
+// first, the archive keeps the best one and never the m3u8 playlist.
+func (m TweetMedia) fileNames(tweetID string) []string {
+	variants := slices.Clone(m.VideoInfo.Variants)
+	bitrate := func(s string) int64 {
+		b, _ := strconv.ParseInt(s, 10, 64)
+		return b
+	}
+	sort.SliceStable(variants, func(i, j int) bool {
+		mp4I, mp4J := variants[i].ContentType == "video/mp4", variants[j].ContentType == "video/mp4"
+		if mp4I != mp4J {
+			return mp4I
+		}
+		return bitrate(variants[i].Bitrate) > bitrate(variants[j].Bitrate)
+	})
+
+	urls := []string{}
+	for _, v := range variants {
+		urls = append(urls, v.URL)
+	}
+	// Videos should be imported instead of their preview image
+	if m.Type == "photo" || len(urls) == 0 {
+		urls = append([]string{m.MediaURL}, urls...)
+	} else {
+		urls = append(urls, m.MediaURL)
+	}
+
+	names := []string{}
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Path == "" {
+			continue
+		}
+		names = append(names, tweetID+"-"+path.Base(parsed.Path))
+	}
+	return names
+}
+
+// parseTweetsJs reads tweets from JavaScript file of the archive. The JSON is
+// assigned to a variable: window.YTD.tweets.part0 = [ ... ]
+func parseTweetsJs(data []byte) ([]TweetEntry, error) {
+	start := bytes.IndexByte(data, '=')
+	if start < 0 {
+		return nil, fmt.Errorf("no tweets assignment found")
+	}
+
+	entries := []TweetEntry{}
+	if err := json.Unmarshal(data[start+1:], &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// twitterDataDir returns folder with tweets.js, the archive root or its data folder
+func twitterDataDir(srcDir string) string {
+	if _, err := os.Stat(filepath.Join(srcDir, "data")); err == nil {
+		return filepath.Join(srcDir, "data")
+	}
+	return srcDir
+}
+
+// readTweets reads tweets from every part of the archive. Older archives
+// name the files tweet.js and tweet-part1.js.
+func readTweets(dataDir string) ([]TweetData, error) {
+	files := []string{}
+	for _, pattern := range []string{"tweets.js", "tweets-part*.js", "tweet.js", "tweet-part*.js"} {
+		matches, err := filepath.Glob(filepath.Join(dataDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no tweets.js found in %s", dataDir)
+	}
+
+	tweets := []TweetData{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseTweetsJs(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", f, err)
+		}
+		for _, e := range entries {
+			tweets = append(tweets, e.Tweet)
+		}
+	}
+	return tweets, nil
+}
+
+// tweetMedia is media file of a tweet found in the archive
+type tweetMedia struct {
+	Media
+	caption string
+}
+
+// findTweetMedia pairs media of tweets with files in the media folder and
+// numbers media of tweets posted in the same second
+func findTweetMedia(user string, srcDir string, tweets []TweetData) []tweetMedia {
+	dataDir := twitterDataDir(srcDir)
+	mediaDir := "tweets_media"
+	if _, err := os.Stat(filepath.Join(dataDir, mediaDir)); err != nil {
+		mediaDir = "tweet_media"
+	}
+
+	type datedTweet struct {
+		TweetData
+		ts int64
+	}
+	dated := []datedTweet{}
+	for _, t := range tweets {
+		if len(t.media()) == 0 {
+			continue
+		}
+		created, err := time.Parse(tweetTimeLayout, t.CreatedAt)
+		if err != nil {
+			fmt.Printf("Invalid time of tweet %s: %s. Skipping\n", t.ID, err)
+			continue
+		}
+		dated = append(dated, datedTweet{t, created.Unix()})
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		if dated[i].ts != dated[j].ts {
+			return dated[i].ts < dated[j].ts
+		}
+		return dated[i].ID < dated[j].ID
+	})
+
+	found := []tweetMedia{}
+	indices := mediaIndices{}
+	for _, t := range dated {
+		for _, m := range t.media() {
+			uri := ""
+			for _, name := range m.fileNames(t.ID) {
+				p := filepath.Join(dataDir, mediaDir, name)
+				if _, err := os.Stat(p); err == nil {
+					rel, _ := filepath.Rel(srcDir, p)
+					uri = filepath.ToSlash(rel)
+					break
+				}
+			}
+			// Media of retweets is not part of the archive
+			if uri == "" {
+				fmt.Printf("Media %s of tweet %s not found in archive. Skipping\n", m.ID, t.ID)
+				continue
+			}
+
+			media := Media{URI: uri, CreationTimestamp: t.ts, Type: Tweet, User: user}
+			media.Index = indices.next(media)
+			found = append(found, tweetMedia{Media: media, caption: t.caption()})
+		}
+	}
+
+	return found
+}
+
+// processTwitter imports media of Twitter/X archive into gallery folder of user
+func processTwitter(user string, srcDir string, dstDir string) error {
+	tweets, err := readTweets(twitterDataDir(srcDir))
+	if err != nil {
+		return err
+	}
+
+	media := findTweetMedia(user, srcDir, tweets)
+	fmt.Printf("Tweets found: %d, media found: %d\n", len(tweets), len(media))
+
+	for _, m := range media {
+		srcPath := filepath.Join(srcDir, filepath.FromSlash(m.URI))
+		_, err := importMedia(srcPath, m.Media, dstDir, sidecar.Metadata{
+			Caption:   m.caption,
+			SourceURI: m.URI,
+			Type:      string(m.Type),
+			Timestamp: m.CreationTimestamp,
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseTweetsJs(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		expectedIDs []string
+		expectError bool
+	}{
+		{
+			name:        "tweets with media",
+			data:        `window.YTD.tweets.part0 = [{"tweet": {"id_str": "1", "extended_entities": {"media": [{"id_str": "10"}]}}}, {"tweet": {"id_str": "2"}}]`,
+			expectedIDs: []string{"1", "2"},
+		},
+		{
+			name:        "split archive part",
+			data:        "window.YTD.tweets.part1 = [\n  {\n    \"tweet\" : {\n      \"id_str\" : \"3\"\n    }\n  }\n]",
+			expectedIDs: []string{"3"},
+		},
+		{
+			name:        "no tweets",
+			data:        `window.YTD.tweets.part0 = []`,
+			expectedIDs: []string{},
+		},
+		{
+			name:        "no assignment",
+			data:        `[{"tweet": {"id_str": "1"}}]`,
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			data:        `window.YTD.tweets.part0 = [{"tweet": `,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseTweetsJs([]byte(tt.data))
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []string{}
+			for _, e := range entries {
+				ids = append(ids, e.Tweet.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Errorf("expected tweets %v, got %v", tt.expectedIDs, ids)
+			}
+		})
+	}
+}
+
+func TestTweetMediaFileNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		media    string
+		expected []string
+	}{
+		{
+			name:     "photo",
+			media:    `{"type": "photo", "media_url_https": "https://pbs.twimg.com/media/FxAbC.jpg"}`,
+			expected: []string{"1-FxAbC.jpg"},
+		},
+		{
+			name: "mp4 variants by bitrate before playlist",
+			media: `{"type": "video", "media_url_https": "https://pbs.twimg.com/ext_tw_video_thumb/10/pu/img/thumb.jpg", "video_info": {"variants": [
+				{"content_type": "application/x-mpegURL", "url": "https://video.twimg.com/ext_tw_video/10/pu/pl/list.m3u8?tag=12"},
+				{"bitrate": "832000", "content_type": "video/mp4", "url": "https://video.twimg.com/ext_tw_video/10/pu/vid/640x360/low.mp4?tag=12"},
+				{"bitrate": "2176000", "content_type": "video/mp4", "url": "https://video.twimg.com/ext_tw_video/10/pu/vid/1280x720/high.mp4?tag=12"}
+			]}}`,
+			expected: []string{"1-high.mp4", "1-low.mp4", "1-list.m3u8", "1-thumb.jpg"},
+		},
+		{
+			name: "animated gif",
+			media: `{"type": "animated_gif", "media_url_https": "https://pbs.twimg.com/tweet_video_thumb/gif.jpg", "video_info": {"variants": [
+				{"bitrate": "0", "content_type": "video/mp4", "url": "https://video.twimg.com/tweet_video/gif.mp4"}
+			]}}`,
+			expected: []string{"1-gif.mp4", "1-gif.jpg"},
+		},
+		{
+			name:     "video without variants",
+			media:    `{"type": "video", "media_url_https": "https://pbs.twimg.com/media/thumb.jpg"}`,
+			expected: []string{"1-thumb.jpg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := TweetMedia{}
+			if err := json.Unmarshal([]byte(tt.media), &m); err != nil {
+				t.Fatal(err)
+			}
+			if names := m.fileNames("1"); !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
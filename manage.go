@@ -0,0 +1,240 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kif11/gallery2/sidecar"
+)
+
+//go:embed web/gallery/manage.js
+var manageJs []byte
+
+var errInvalidPath = errors.New("invalid path")
+
+// canModify reports whether the request may add, move or delete media. Only
+// admins can, and only when authentication is configured, without it
+// everybody is an admin.
+func canModify(r *http.Request) bool {
+	ra := requestAuthFromContext(r)
+	if ra == nil || !ra.user.isAdmin() {
+		return false
+	}
+	_, public := ra.auth.(noAuth)
+	return !public
+}
+
+// mediaManager moves, renames and deletes media and whole folders in the
+// storage. Sidecar files go together with their media, and the index and
+// favorites follow every change. Deleted media is kept in the trash.
+type mediaManager struct {
+	// Serializes changes, so checks for conflicts hold until files are moved
+	mu        sync.Mutex
+	fSys      fs.FS
+	storage   storageWriter
+	index     *mediaIndex
+	favorites *favoriteStore
+	// How long deleted media is kept in the trash, zero keeps it until purged
+	trashRetention time.Duration
+	// Reads deleted media for admins, the trash is not served with other assets
+	readFile readFileFunc
+}
+
+func newMediaManager(fSys fs.FS, storage storageWriter, index *mediaIndex, favorites *favoriteStore) *mediaManager {
+	return &mediaManager{
+		fSys:      fSys,
+		storage:   storage,
+		index:     index,
+		favorites: favorites,
+		readFile:  func(p string) ([]byte, error) { return fs.ReadFile(fSys, p) },
+	}
+}
+
+// managedPath cleans p and checks that it is media or folder in the storage
+// that can be changed. The gallery root and virtual folders can not.
+func managedPath(p string) (string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" || p == "." || !fs.ValidPath(p) || isVirtualPath(p) || isTrashPath(p) {
+		return "", fmt.Errorf("%w %q", errInvalidPath, p)
+	}
+	return p, nil
+}
+
+// files returns storage files that belong to p. A file comes with its sidecar,
+// a folder with every file under it.
+func (m *mediaManager) files(p string) ([]string, bool, error) {
+	info, err := fs.Stat(m.fSys, p)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !info.IsDir() {
+		files := []string{p}
+		if _, err := fs.Stat(m.fSys, sidecar.Path(p)); err == nil {
+			files = append(files, sidecar.Path(p))
+		}
+		return files, false, nil
+	}
+
+	files := []string{}
+	err = fs.WalkDir(m.fSys, p, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, fp)
+		}
+		return nil
+	})
+	return files, true, err
+}
+
+// move renames media or folder src to dst, which can be in another folder
+func (m *mediaManager) move(src string, dst string) error {
+	src, err := managedPath(src)
+	if err != nil {
+		return err
+	}
+	dst, err = managedPath(dst)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files, dir, err := m.files(src)
+	if err != nil {
+		return err
+	}
+
+	// The gallery tells files from folders by extension
+	if dir && (path.Ext(dst) != "" || dst == src || strings.HasPrefix(dst, src+"/")) {
+		return fmt.Errorf("%w: can not move folder %s to %s", errInvalidPath, src, dst)
+	}
+	if !dir && (getMediaType(path.Ext(src)) == Other || getMediaType(path.Ext(dst)) == Other) {
+		return fmt.Errorf("%w: can not move %s to %s", errInvalidPath, src, dst)
+	}
+
+	if _, err := fs.Stat(m.fSys, dst); err == nil {
+		return fmt.Errorf("%s %w", dst, fs.ErrExist)
+	}
+
+	// Nothing is moved if any of the files would replace another one
+	targets := make(map[string]string)
+	for _, f := range files {
+		to := dst + strings.TrimPrefix(f, src)
+		if _, err := fs.Stat(m.fSys, to); err == nil {
+			return fmt.Errorf("%s %w", to, fs.ErrExist)
+		}
+		targets[f] = to
+	}
+
+	moved := make(map[string]string)
+	defer m.finish(moved)
+
+	for _, f := range files {
+		if err := m.storage.rename(f, targets[f]); err != nil {
+			// The file may be left at both paths if only the copy succeeded
+			m.index.reindex(f, targets[f])
+			return err
+		}
+		moved[f] = targets[f]
+	}
+	fmt.Printf("[+] Moved %s to %s\n", src, dst)
+
+	return nil
+}
+
+// remove moves media with its sidecar or folder with everything in it to
+// the trash and returns ID of the trash item
+func (m *mediaManager) remove(p string) (string, error) {
+	p, err := managedPath(p)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files, _, err := m.files(p)
+	if err != nil {
+		return "", err
+	}
+
+	return m.moveToTrash(p, files)
+}
+
+// finish updates the index and favorites with files that were moved to new
+// paths, or deleted when the new path is empty. It also runs after a failed
+// change, so files changed before the failure are not left behind.
+func (m *mediaManager) finish(moved map[string]string) {
+	if len(moved) == 0 {
+		return
+	}
+
+	paths := []string{}
+	for from, to := range moved {
+		paths = append(paths, from)
+		if to != "" {
+			paths = append(paths, to)
+		}
+	}
+	m.index.reindex(paths...)
+	zipCRCs.remove(paths...)
+
+	if err := m.favorites.move(moved); err != nil {
+		logError("Error updating favorites: %v", err)
+	}
+}
+
+// writeMediaError responds with status that matches error of a media change
+func writeMediaError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, http.StatusNotFound, "Not Found")
+	case errors.Is(err, fs.ErrExist):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errInvalidPath):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// makeMediaApiHandler changes media under /api/media:
+//   - POST /api/media/move with "path" and "to" form values moves or renames media or folder
+//   - POST /api/media/delete with "path" form value moves media or folder to the trash
+func makeMediaApiHandler(media *mediaManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Trim(r.FormValue("path"), "/")
+
+		switch strings.TrimPrefix(r.URL.Path, urlPrefix+"/api/media/") {
+		case "move":
+			to := strings.Trim(r.FormValue("to"), "/")
+			if err := media.move(p, to); err != nil {
+				writeMediaError(w, err)
+				return
+			}
+			writeJSON(w, map[string]any{"path": p, "to": to})
+
+		case "delete":
+			id, err := media.remove(p)
+			if err != nil {
+				writeMediaError(w, err)
+				return
+			}
+			writeJSON(w, map[string]any{"path": p, "trash": id})
+
+		default:
+			writeError(w, http.StatusNotFound, "Not Found")
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestMediaManager returns media manager of a temporary local gallery
+func newTestMediaManager(t *testing.T) (*mediaManager, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	files := map[string]string{
+		"kif/2023/post_1700000000_0.jpg":      "a",
+		"kif/2023/post_1700000000_0.jpg.json": `{"caption": "first"}`,
+		"kif/2023/post_1700000000_1.jpg":      "b",
+		"kif/2023/trip/reel_1700000001_0.mp4": "c",
+		"snay/post_1700000002_0.jpg":          "d",
+	}
+	for p, data := range files {
+		f := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(f), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fSys, readFile, sizeFn, _, storage := localFS(root)
+	index := newMediaIndex(fSys, readFile, sizeFn)
+	index.readExif = false
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	favorites := newFavoriteStore(filepath.Join(t.TempDir(), "favorites.json"), index)
+	if err := favorites.set("kif/2023/post_1700000000_0.jpg", true); err != nil {
+		t.Fatal(err)
+	}
+
+	return newMediaManager(fSys, storage, index, favorites), root
+}
+
+func postMedia(handler http.HandlerFunc, action string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", urlPrefix+"/api/media/"+action, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func fileExists(root string, p string) bool {
+	_, err := os.Stat(filepath.Join(root, filepath.FromSlash(p)))
+	return err == nil
+}
+
+func TestMediaMove(t *testing.T) {
+	media, root := newTestMediaManager(t)
+	handler := makeMediaApiHandler(media)
+	zipCRCs.set("kif/2023/post_1700000000_0.jpg", 1, time.Time{}, 1)
+	defer zipCRCs.purge()
+
+	rr := postMedia(handler, "move", url.Values{"path": {"kif/2023/post_1700000000_0.jpg"}, "to": {"snay/2024/first.jpg"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected media to be moved, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	if fileExists(root, "kif/2023/post_1700000000_0.jpg") || fileExists(root, "kif/2023/post_1700000000_0.jpg.json") {
+		t.Error("expected media and its sidecar to be moved away")
+	}
+	if !fileExists(root, "snay/2024/first.jpg") || !fileExists(root, "snay/2024/first.jpg.json") {
+		t.Error("expected media and its sidecar at the new path")
+	}
+
+	if _, ok := media.index.get("kif/2023/post_1700000000_0.jpg"); ok {
+		t.Error("expected old path to be removed from the index")
+	}
+	if it, ok := media.index.get("snay/2024/first.jpg"); !ok || it.Caption != "first" {
+		t.Errorf("expected moved media to be indexed with its caption, got %+v", it)
+	}
+	if media.favorites.has("kif/2023/post_1700000000_0.jpg") || !media.favorites.has("snay/2024/first.jpg") {
+		t.Error("expected favorite to follow moved media")
+	}
+	if zipCRCs.len() != 0 {
+		t.Error("expected CRC of moved media to be forgotten")
+	}
+}
+
+func TestMediaMoveFolder(t *testing.T) {
+	media, root := newTestMediaManager(t)
+
+	if err := media.move("kif/2023", "archive/kif-2023"); err != nil {
+		t.Fatal(err)
+	}
+
+	if fileExists(root, "kif") {
+		t.Error("expected folders left empty to be removed")
+	}
+	for _, p := range []string{"archive/kif-2023/post_1700000000_0.jpg.json", "archive/kif-2023/trip/reel_1700000001_0.mp4"} {
+		if !fileExists(root, p) {
+			t.Errorf("expected %s to be moved", p)
+		}
+	}
+	if got := len(media.index.all("archive")); got != 3 {
+		t.Errorf("expected 3 indexed media in the moved folder, got %d", got)
+	}
+	if got := len(media.index.all("kif")); got != 0 {
+		t.Errorf("expected no indexed media in the old folder, got %d", got)
+	}
+	if !media.favorites.has("archive/kif-2023/post_1700000000_0.jpg") {
+		t.Error("expected favorite to follow moved folder")
+	}
+}
+
+// copyOnlyWriter copies files on rename but fails to delete the source
+type copyOnlyWriter struct {
+	storageWriter
+	root string
+}
+
+func (s copyOnlyWriter) rename(p string, dst string) error {
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(p)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.writeFile(dst, f); err != nil {
+		return err
+	}
+	return fmt.Errorf("error deleting %s: access denied", p)
+}
+
+func TestMediaMovePartial(t *testing.T) {
+	media, root := newTestMediaManager(t)
+	media.storage = copyOnlyWriter{storageWriter: media.storage, root: root}
+
+	if err := media.move("kif/2023/post_1700000000_0.jpg", "snay/2024/first.jpg"); err == nil {
+		t.Fatal("expected move to fail")
+	}
+
+	// The index follows the storage, media is at both paths now
+	for _, p := range []string{"kif/2023/post_1700000000_0.jpg", "snay/2024/first.jpg"} {
+		if _, ok := media.index.get(p); !ok {
+			t.Errorf("expected %s to be indexed", p)
+		}
+	}
+	if !media.favorites.has("kif/2023/post_1700000000_0.jpg") {
+		t.Error("expected favorite to stay with media that was not moved")
+	}
+}
+
+func TestMediaMoveRejected(t *testing.T) {
+	media, root := newTestMediaManager(t)
+	handler := makeMediaApiHandler(media)
+
+	tests := []struct {
+		name     string
+		path     string
+		to       string
+		expected int
+	}{
+		{"missing media", "kif/2023/missing.jpg", "kif/2023/other.jpg", http.StatusNotFound},
+		{"existing media", "kif/2023/post_1700000000_1.jpg", "snay/post_1700000002_0.jpg", http.StatusConflict},
+		{"existing folder", "kif/2023", "snay", http.StatusConflict},
+		{"into itself", "kif", "kif/2023/kif", http.StatusBadRequest},
+		{"not media", "kif/2023/post_1700000000_1.jpg", "kif/2023/post.txt", http.StatusBadRequest},
+		{"sidecar", "kif/2023/post_1700000000_0.jpg.json", "kif/2023/other.json", http.StatusBadRequest},
+		{"outside of the gallery", "kif/2023/post_1700000000_1.jpg", "../post.jpg", http.StatusBadRequest},
+		{"into virtual folder", "kif/2023/post_1700000000_1.jpg", favoritesFolder + "/post.jpg", http.StatusBadRequest},
+		{"gallery root", "/", "all", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postMedia(handler, "move", url.Values{"path": {tt.path}, "to": {tt.to}})
+			if rr.Code != tt.expected {
+				t.Errorf("expected %d, got %d %s", tt.expected, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if !fileExists(root, "kif/2023/post_1700000000_1.jpg") || !fileExists(root, "snay/post_1700000002_0.jpg") {
+		t.Error("expected rejected moves to leave media in place")
+	}
+}
+
+func TestMediaDelete(t *testing.T) {
+	media, root := newTestMediaManager(t)
+	handler := makeMediaApiHandler(media)
+
+	if rr := postMedia(handler, "delete", url.Values{"path": {"kif/2023/post_1700000000_0.jpg"}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected media to be deleted, got %d %s", rr.Code, rr.Body.String())
+	}
+	if fileExists(root, "kif/2023/post_1700000000_0.jpg") || fileExists(root, "kif/2023/post_1700000000_0.jpg.json") {
+		t.Error("expected media and its sidecar to be deleted")
+	}
+	if _, ok := media.index.get("kif/2023/post_1700000000_0.jpg"); ok {
+		t.Error("expected deleted media to be removed from the index")
+	}
+	if media.favorites.has("kif/2023/post_1700000000_0.jpg") {
+		t.Error("expected deleted media to be removed from favorites")
+	}
+
+	if rr := postMedia(handler, "delete", url.Values{"path": {"kif"}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected folder to be deleted, got %d %s", rr.Code, rr.Body.String())
+	}
+	if fileExists(root, "kif") {
+		t.Error("expected folder to be deleted")
+	}
+	if got := len(media.index.all("")); got != 1 {
+		t.Errorf("expected 1 media left in the index, got %d", got)
+	}
+
+	if rr := postMedia(handler, "delete", url.Values{"path": {"kif"}}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing folder not to be found, got %d", rr.Code)
+	}
+}
+
+func TestCanModify(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     authProvider
+		expected bool
+	}{
+		{"admin", staticAuth{user: adminUser}, true},
+		{"viewer", staticAuth{user: kifUser}, false},
+		{"anonymous", staticAuth{}, false},
+		{"without authentication", noAuth{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+			handler := authMiddleware(tt.auth, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = canModify(r)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", urlPrefix, nil))
+
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
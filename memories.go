@@ -0,0 +1,170 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// MemoriesYear holds media captured on the same calendar day in one of previous years
+type MemoriesYear struct {
+	Year     int
+	YearsAgo int
+	Items    []MediaItem
+	Media    []Media
+}
+
+type MemoriesPage struct {
+	Title     string
+	Date      string
+	Years     []MemoriesYear
+	BackLink  string
+	Styles    template.CSS
+	JS        template.JS
+	GridSize  string
+	URLPrefix string
+}
+
+// apiMedia is JSON representation of indexed media item
+type apiMedia struct {
+	Path       string        `json:"path"`
+	Type       MediaFileType `json:"type"`
+	PageURL    string        `json:"page_url"`
+	PublicPath string        `json:"public_path"`
+	Time       time.Time     `json:"time"`
+	TimeSource string        `json:"time_source"`
+	Size       int64         `json:"size"`
+	Caption    string        `json:"caption,omitempty"`
+}
+
+func makeApiMedia(it MediaItem) apiMedia {
+	m := makeIndexedMedia(it)
+	return apiMedia{
+		Path:       it.Path,
+		Type:       m.Type,
+		PageURL:    m.AbsolutePageURL,
+		PublicPath: m.PublicPath,
+		Time:       it.Time,
+		TimeSource: it.TimeSource,
+		Size:       it.Size,
+		Caption:    it.Caption,
+	}
+}
+
+// isSameCalendarDay reports whether t falls on the month and day of day.
+// On February 28 of non leap years media from February 29 is included as well.
+func isSameCalendarDay(t time.Time, day time.Time) bool {
+	if t.Month() == day.Month() && t.Day() == day.Day() {
+		return true
+	}
+
+	isLeap := func(y int) bool { return y%4 == 0 && (y%100 != 0 || y%400 == 0) }
+
+	return day.Month() == time.February && day.Day() == 28 && !isLeap(day.Year()) &&
+		t.Month() == time.February && t.Day() == 29
+}
+
+// memoriesOn collects media captured on the calendar date of day in previous
+// years grouped by year, most recent year first
+func memoriesOn(items []MediaItem, day time.Time) []MemoriesYear {
+	years := []MemoriesYear{}
+
+	for _, it := range items {
+		t := it.Time.Local()
+		if t.Year() >= day.Year() || !isSameCalendarDay(t, day) {
+			continue
+		}
+
+		// Items are sorted newest first so each year forms a consecutive run
+		if len(years) == 0 || years[len(years)-1].Year != t.Year() {
+			years = append(years, MemoriesYear{Year: t.Year(), YearsAgo: day.Year() - t.Year()})
+		}
+
+		y := &years[len(years)-1]
+		y.Items = append(y.Items, it)
+		y.Media = append(y.Media, makeIndexedMedia(it))
+	}
+
+	return years
+}
+
+// memoriesDay returns the day to look up memories for. It defaults to
+// today and can be overridden with "date" query parameter in MM-DD format.
+func memoriesDay(r *http.Request) time.Time {
+	now := time.Now()
+
+	d, err := time.ParseInLocation("01-02", r.URL.Query().Get("date"), time.Local)
+	if err != nil {
+		return now
+	}
+
+	return time.Date(now.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// makeMemoriesHandler renders "On this day" page with media captured on
+// today's date in previous years across the whole gallery
+func makeMemoriesHandler(index *mediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canBrowse(r, "") {
+			denyAccess(w, r)
+			return
+		}
+
+		day := memoriesDay(r)
+
+		gridSize := r.URL.Query().Get("grid")
+		if gridSize == "" {
+			gridSize = "200px"
+		}
+
+		page := MemoriesPage{
+			Title:     "On this day",
+			Date:      day.Format("2 January"),
+			Years:     memoriesOn(visibleItems(r, "", index.all("")), day),
+			BackLink:  urlPrefix,
+			Styles:    template.CSS(append(append(galleryCss, timelineCss...), globalCss...)),
+			JS:        template.JS(append(globalJs, galleryJs...)),
+			GridSize:  gridSize,
+			URLPrefix: urlPrefix,
+		}
+
+		err := tmpl.ExecuteTemplate(w, "memories.html", page)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+}
+
+// makeMemoriesApiHandler returns the same data as memories page in JSON
+func makeMemoriesApiHandler(index *mediaIndex) http.HandlerFunc {
+	type year struct {
+		Year     int        `json:"year"`
+		YearsAgo int        `json:"years_ago"`
+		Media    []apiMedia `json:"media"`
+	}
+	type response struct {
+		Date  string `json:"date"`
+		Years []year `json:"years"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canBrowse(r, "") {
+			denyAccess(w, r)
+			return
+		}
+
+		day := memoriesDay(r)
+
+		resp := response{Date: day.Format("01-02"), Years: []year{}}
+		for _, y := range memoriesOn(visibleItems(r, "", index.all("")), day) {
+			jy := year{Year: y.Year, YearsAgo: y.YearsAgo, Media: []apiMedia{}}
+			for _, it := range y.Items {
+				jy.Media = append(jy.Media, makeApiMedia(it))
+			}
+			resp.Years = append(resp.Years, jy)
+		}
+
+		writeJSON(w, resp)
+	}
+}
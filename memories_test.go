@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMemoriesOn(t *testing.T) {
+	at := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 12, 0, 0, 0, time.Local) }
+	items := []MediaItem{
+		{Path: "a/today.jpg", Time: at(2025, 5, 17)},
+		{Path: "a/last_year_1.jpg", Time: at(2024, 5, 17)},
+		{Path: "a/last_year_0.jpg", Time: at(2024, 5, 17)},
+		{Path: "a/other_day.jpg", Time: at(2023, 5, 18)},
+		{Path: "b/long_ago.jpg", Time: at(2019, 5, 17)},
+	}
+
+	years := memoriesOn(items, at(2025, 5, 17))
+	if len(years) != 2 {
+		t.Fatalf("got %d years, want 2", len(years))
+	}
+	if years[0].Year != 2024 || years[0].YearsAgo != 1 || len(years[0].Media) != 2 {
+		t.Errorf("first year = %d (%d ago) with %d media, want 2024 (1 ago) with 2", years[0].Year, years[0].YearsAgo, len(years[0].Media))
+	}
+	if years[1].Year != 2019 || years[1].YearsAgo != 6 {
+		t.Errorf("second year = %d (%d ago), want 2019 (6 ago)", years[1].Year, years[1].YearsAgo)
+	}
+}
+
+func TestMemoriesOn_LeapDay(t *testing.T) {
+	items := []MediaItem{
+		{Path: "a/leap.jpg", Time: time.Date(2024, 2, 29, 12, 0, 0, 0, time.Local)},
+	}
+
+	if got := memoriesOn(items, time.Date(2025, 2, 28, 0, 0, 0, 0, time.Local)); len(got) != 1 {
+		t.Errorf("Feb 28 of non leap year returned %d years, want 1", len(got))
+	}
+	if got := memoriesOn(items, time.Date(2028, 2, 28, 0, 0, 0, 0, time.Local)); len(got) != 0 {
+		t.Errorf("Feb 28 of leap year returned %d years, want 0", len(got))
+	}
+}
+
+func TestMemoriesApiHandler(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/2023/story_1700000000_0.jpg": &fstest.MapFile{},
+	}
+	index := newMediaIndex(mockFs, func(string) ([]byte, error) { return nil, nil }, func(string) int64 { return 0 })
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Unix(1700000000, 0).Format("01-02")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", urlPrefix+"/api/memories?date="+date, nil)
+	makeMemoriesApiHandler(index)(w, r)
+
+	var resp struct {
+		Date  string
+		Years []struct {
+			Year  int
+			Media []struct {
+				Path string
+			}
+		}
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if resp.Date != date {
+		t.Errorf("date = %s, want %s", resp.Date, date)
+	}
+	if len(resp.Years) != 1 || len(resp.Years[0].Media) != 1 || resp.Years[0].Media[0].Path != "kif/2023/story_1700000000_0.jpg" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"archive/zip"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
@@ -17,19 +17,21 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"testing/fstest"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 type readFileFunc func(path string) ([]byte, error)
 
 type s3Object struct {
-	Name string
-	Size int64
+	Name         string
+	Size         int64
+	LastModified time.Time
 }
 
 //go:embed web/gallery/*.html
@@ -53,6 +55,12 @@ var galleryJs []byte
 //go:embed web/gallery/player.js
 var playerJs []byte
 
+//go:embed web/gallery/selection.js
+var selectionJs []byte
+
+// Most media a single selection download can contain
+const maxSelection = 5000
+
 // Prefix of your web server URL under which this gallery is hosted
 // e.g. if you have you main site on mysite.org and gallery under mysite.org/gallery
 // you should configure nginx (or other web server) reverse proxy to /gallery and set prefix to /gallery
@@ -84,6 +92,11 @@ type Media struct {
 	RelativePageURL string
 	// Full path to the page where asset is rendered e.g. example.com/gallery/kif/2024
 	AbsolutePageURL string
+	// Caption from the media sidecar file
+	Caption string
+	// Path of the media in the storage e.g. kif/2024/post_1700000000_0.jpg.
+	// Differs from RelativePageURL for media shown in virtual folders.
+	StoragePath string
 }
 
 type LinkedMedia struct {
@@ -103,15 +116,22 @@ type GalleryPage struct {
 	CurrentPath string
 	URLPrefix   string
 	AlbumSize   string
+	// Whether folder has subfolders that can be downloaded together with it
+	TreeDownload bool
+	// Whether the user can upload media into the folder and change it
+	CanModify bool
 }
 
 type PlayerPage struct {
-	Title    string
-	Image    LinkedMedia
-	URLParam string
-	BackLink string
-	Styles   template.CSS
-	JS       template.JS
+	Title     string
+	Image     LinkedMedia
+	URLParam  string
+	BackLink  string
+	Styles    template.CSS
+	JS        template.JS
+	URLPrefix string
+	// Whether the user can move and delete the media
+	CanModify bool
 }
 
 func isDir(path string) bool {
@@ -163,6 +183,7 @@ func makeMedia(relativeURL string, assetsRoute string, urlPrefix string) Media {
 		PublicPath:      publicPath,
 		RelativePageURL: relativeURL,
 		AbsolutePageURL: path.Join(urlPrefix, relativeURL),
+		StoragePath:     relativeURL,
 	}
 }
 
@@ -226,6 +247,7 @@ func s3List() ([]s3Object, error) {
 	svc := s3.New(newSession)
 
 	objects := []s3Object{}
+	trashFolderKey := getEnv("CCG_S3_TRASH_DIR", "")
 
 	// List all objects in the bucket with the specified prefix
 	err = svc.ListObjectsPages(&s3.ListObjectsInput{
@@ -233,15 +255,38 @@ func s3List() ([]s3Object, error) {
 		Prefix: aws.String(galleryFolder),
 	}, func(p *s3.ListObjectsOutput, last bool) (shouldContinue bool) {
 		for _, item := range p.Contents {
+			// Separate trash folder is listed below, it can share the prefix
+			if trashFolderKey != "" && strings.HasPrefix(*item.Key, trashFolderKey+"/") {
+				continue
+			}
 			objects = append(objects, s3Object{
-				Name: strings.TrimPrefix(*item.Key, galleryFolder+"/"),
-				Size: *item.Size,
+				Name:         strings.TrimPrefix(*item.Key, galleryFolder+"/"),
+				Size:         *item.Size,
+				LastModified: aws.TimeValue(item.LastModified),
 			})
 		}
 
 		return true
 	})
 
+	// Deleted media kept outside of the public gallery folder is listed as its .trash folder
+	if err == nil && trashFolderKey != "" {
+		err = svc.ListObjectsPages(&s3.ListObjectsInput{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(trashFolderKey + "/"),
+		}, func(p *s3.ListObjectsOutput, last bool) (shouldContinue bool) {
+			for _, item := range p.Contents {
+				objects = append(objects, s3Object{
+					Name:         trashFolder + strings.TrimPrefix(*item.Key, trashFolderKey),
+					Size:         *item.Size,
+					LastModified: aws.TimeValue(item.LastModified),
+				})
+			}
+
+			return true
+		})
+	}
+
 	if err != nil {
 		fmt.Println("failed to list objects", err)
 		return []s3Object{}, err
@@ -251,10 +296,10 @@ func s3List() ([]s3Object, error) {
 }
 
 // Returns `update` function which can be used to refresh s3 entries
-// that cached in memory map, and a readFile function for fetching file content from S3
-func s3FS(fileListFn func() ([]s3Object, error)) (fs.FS, readFileFunc, func(string) int64, func() error) {
-	var s3Fs fstest.MapFS = make(map[string]*fstest.MapFile)
-	sizes := make(map[string]int64)
+// that cached in memory map, a readFile function for fetching file content from S3
+// and a writer uploading new objects
+func s3FS(fileListFn func() ([]s3Object, error)) (fs.FS, readFileFunc, func(string) int64, func() error, storageWriter) {
+	listing := newS3Listing()
 
 	endpoint := getEnv("CCG_S3_ENDPOINT", "nyc3.digitaloceanspaces.com")
 	region := getEnv("CCG_S3_REGION", "nyc3")
@@ -271,14 +316,19 @@ func s3FS(fileListFn func() ([]s3Object, error)) (fs.FS, readFileFunc, func(stri
 	sess, _ := session.NewSession(s3Config)
 	svc := s3.New(sess)
 
+	writer := s3Writer{
+		svc:       svc,
+		uploader:  s3manager.NewUploaderWithClient(svc),
+		bucket:    bucket,
+		root:      galleryFolder,
+		trashRoot: getEnv("CCG_S3_TRASH_DIR", ""),
+		listing:   listing,
+	}
+
 	readFile := func(p string) ([]byte, error) {
-		key := galleryFolder + "/" + p
-		if galleryFolder == "" {
-			key = p
-		}
 		result, err := svc.GetObject(&s3.GetObjectInput{
 			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+			Key:    aws.String(writer.key(p)),
 		})
 		if err != nil {
 			return nil, err
@@ -287,34 +337,17 @@ func s3FS(fileListFn func() ([]s3Object, error)) (fs.FS, readFileFunc, func(stri
 		return io.ReadAll(result.Body)
 	}
 
-	sizeFn := func(p string) int64 {
-		return sizes[p]
-	}
-
-	return s3Fs, readFile, sizeFn, func() error {
+	update := func() error {
 		objects, err := fileListFn()
 		if err != nil {
 			return err
 		}
 
-		// Clear the maps
-		for k := range s3Fs {
-			delete(s3Fs, k)
-		}
-		for k := range sizes {
-			delete(sizes, k)
-		}
-
-		for _, obj := range objects {
-			if obj.Name == "" {
-				continue
-			}
-			s3Fs[obj.Name] = &fstest.MapFile{}
-			sizes[obj.Name] = obj.Size
-		}
-
+		listing.replace(objects)
 		return nil
 	}
+
+	return listing, readFile, listing.size, update, writer
 }
 
 func listFsItems(fSys fs.FS, path string) ([]fs.DirEntry, error) {
@@ -332,6 +365,10 @@ func listFsItems(fSys fs.FS, path string) ([]fs.DirEntry, error) {
 		if c.Name() == "." {
 			continue
 		}
+		// Deleted media is only shown to admins on the trash page
+		if path == "." && c.Name() == trashFolder {
+			continue
+		}
 
 		fsItems = append(fsItems, c)
 	}
@@ -339,15 +376,32 @@ func listFsItems(fSys fs.FS, path string) ([]fs.DirEntry, error) {
 	return fsItems, nil
 }
 
+// Media file name template {type}_{unix_timestamp}_{index}.{ext} produced by the ingest tool
+var mediaNamePattern = regexp.MustCompile(`^([^_]+)_(\d+)_(\d+)`)
+
+// parseMediaName extracts type, unix timestamp and index from file name
+// like story_12345_0.jpg. The last return value is false if the name does not follow the template.
+func parseMediaName(name string) (string, int64, int, bool) {
+	match := mediaNamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, 0, false
+	}
+
+	timestamp, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	index, _ := strconv.Atoi(match[3])
+
+	return match[1], timestamp, index, true
+}
+
 // This function assumes the following file names:
 // - story_12345_0.jpg which follow the template {type}_{unix_timestamp}_{index}.{ext}
 // - file.jpg (arbitrary name)
 // if file name match the template it will be sorted by timestamp (descending) and then by index (ascending)
 // if file name does not match the template it will be sorted by name (descending)
 func sortDirEntries(files []fs.DirEntry) []fs.DirEntry {
-	// Define regex pattern for special filenames
-	pattern := regexp.MustCompile(`^([^_]+)_(\d+)_(\d+)`)
-
 	// Make a copy to avoid modifying original slice
 	sorted := make([]fs.DirEntry, len(files))
 	copy(sorted, files)
@@ -366,23 +420,17 @@ func sortDirEntries(files []fs.DirEntry) []fs.DirEntry {
 		nameJ := sorted[j].Name()
 
 		// Try to match both filenames against the pattern
-		matchI := pattern.FindStringSubmatch(nameI)
-		matchJ := pattern.FindStringSubmatch(nameJ)
+		_, timestampI, indexI, okI := parseMediaName(nameI)
+		_, timestampJ, indexJ, okJ := parseMediaName(nameJ)
 
 		// If both files match the pattern
-		if matchI != nil && matchJ != nil {
+		if okI && okJ {
 			// Compare timestamps
-			timestampI, _ := strconv.ParseInt(matchI[2], 10, 64)
-			timestampJ, _ := strconv.ParseInt(matchJ[2], 10, 64)
-
 			if timestampI != timestampJ {
 				return timestampI > timestampJ // ascending order
 			}
 
 			// If timestamps are equal, compare indices
-			indexI, _ := strconv.Atoi(matchI[3])
-			indexJ, _ := strconv.Atoi(matchJ[3])
-
 			return indexI < indexJ // ascending order
 		}
 
@@ -432,11 +480,25 @@ func filterDirEntries(entries []fs.DirEntry, filter string) (filtered []fs.DirEn
 }
 
 func writeError(w http.ResponseWriter, header int, msg string) {
+	// Server side failures are shown on the dashboard
+	if header >= http.StatusInternalServerError {
+		recentErrors.add(msg)
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(header)
 	w.Write([]byte(msg))
 }
 
+func writeJSON(w http.ResponseWriter, v any) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
 // playerHandler render individual media on it's own page
 func playerHandler(li LinkedMedia, title string, backLink string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -460,9 +522,11 @@ func playerHandler(li LinkedMedia, title string, backLink string) http.HandlerFu
 			Title:    title,
 			Image:    li,
 			BackLink: backLink,
-			URLParam: "?" + params.Encode(),
-			Styles:   template.CSS(append(playerCss, globalCss...)),
-			JS:       template.JS(append(globalJs, playerJs...)),
+			URLParam:  "?" + params.Encode(),
+			Styles:    template.CSS(append(playerCss, globalCss...)),
+			JS:        template.JS(append(append(append(globalJs, playerJs...), favoritesJs...), manageJs...)),
+			URLPrefix: urlPrefix,
+			CanModify: canModify(r),
 		}
 
 		err = tmpl.ExecuteTemplate(w, "player.html", post)
@@ -474,7 +538,7 @@ func playerHandler(li LinkedMedia, title string, backLink string) http.HandlerFu
 }
 
 // galleryHandler renders folder with images as a gallery
-func galleryHandler(media []Media, title string, backLink string, currentPath string, albumSize string) http.HandlerFunc {
+func galleryHandler(media []Media, title string, backLink string, currentPath string, albumSize string, treeDownload bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get grid size from URL parameter, default to 300px if not specified
 		gridSize := r.URL.Query().Get("grid")
@@ -483,16 +547,18 @@ func galleryHandler(media []Media, title string, backLink string, currentPath st
 		}
 
 		gallery := GalleryPage{
-			Title:       title,
-			Images:      media,
-			URLParam:    "?" + r.URL.RawQuery,
-			BackLink:    backLink,
-			Styles:      template.CSS(append(galleryCss, globalCss...)),
-			GridSize:    gridSize,
-			JS:          template.JS(append(globalJs, galleryJs...)),
-			CurrentPath: currentPath,
-			URLPrefix:   urlPrefix,
-			AlbumSize:   albumSize,
+			Title:        title,
+			Images:       media,
+			URLParam:     "?" + r.URL.RawQuery,
+			BackLink:     backLink,
+			Styles:       template.CSS(append(galleryCss, globalCss...)),
+			GridSize:     gridSize,
+			JS:           template.JS(append(append(append(append(globalJs, galleryJs...), favoritesJs...), selectionJs...), manageJs...)),
+			CurrentPath:  currentPath,
+			URLPrefix:    urlPrefix,
+			AlbumSize:    albumSize,
+			TreeDownload: treeDownload,
+			CanModify:    canModify(r) && !isVirtualPath(currentPath),
 		}
 
 		err := tmpl.ExecuteTemplate(w, "gallery.html", gallery)
@@ -536,10 +602,24 @@ func getMediaSearchPath(url string) string {
 	return sp
 }
 
+// withCaption sets media caption from the media index
+func withCaption(m Media, index *mediaIndex) Media {
+	if it, ok := index.get(m.RelativePageURL); ok {
+		m.Caption = it.Caption
+	}
+	return m
+}
+
 // Root handler that select appropriate HTTP handler depending on the route requested
-func makeGalleryRootHandler(fSys fs.FS, sizeFn func(string) int64) func(w http.ResponseWriter, r *http.Request) {
+func makeGalleryRootHandler(fSys fs.FS, sizeFn func(string) int64, index *mediaIndex, albums *albumStore, favorites *favoriteStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		p := getMediaSearchPath(r.URL.Path)
+		isPlayer := getMediaType(path.Ext(r.URL.Path)) != Other
+
+		if isPlayer && !canRead(r, r.URL.Path) || !isPlayer && !canBrowse(r, p) {
+			denyAccess(w, r)
+			return
+		}
 
 		fsItems, err := listFsItems(fSys, p)
 		if err != nil {
@@ -547,6 +627,29 @@ func makeGalleryRootHandler(fSys fs.FS, sizeFn func(string) int64) func(w http.R
 			return
 		}
 
+		// Leave out what the user has no access to, but keep the opened media
+		// so hidden media can be played by direct link
+		visible := []fs.DirEntry{}
+		for _, f := range fsItems {
+			fp := path.Join(p, f.Name())
+			// Storage folders named like virtual ones are hidden by them
+			if p == "." && isVirtualPath(fp) {
+				continue
+			}
+			if isVisible(r, p, fp, f.IsDir()) || isPlayer && fp == strings.Trim(r.URL.Path, "/") {
+				visible = append(visible, f)
+			}
+		}
+		fsItems = visible
+
+		// Virtual albums and favorites are shown as folders in the gallery root
+		if p == "." && len(albums.list()) > 0 && !isAnonymous(r) {
+			fsItems = append(fsItems, virtualDirEntry{name: albumsFolder})
+		}
+		if p == "." && len(favorites.list()) > 0 && !isAnonymous(r) {
+			fsItems = append(fsItems, virtualDirEntry{name: favoritesFolder})
+		}
+
 		filter := r.URL.Query().Get("filter")
 		filtered := filterNonSupported(fsItems)
 		filtered = filterDirEntries(filtered, filter)
@@ -554,12 +657,12 @@ func makeGalleryRootHandler(fSys fs.FS, sizeFn func(string) int64) func(w http.R
 		sortedFsEntries := sortDirEntries(filtered)
 
 		// If media is a file and one of the supported media extensions when render it in the player
-		if getMediaType(path.Ext(r.URL.Path)) != Other {
+		if isPlayer {
 			/*
 			 * PLAYER
 			 */
 
-			m := makeMedia(r.URL.Path, assetsRoute, urlPrefix)
+			m := withCaption(makeMedia(r.URL.Path, assetsRoute, urlPrefix), index)
 			li, err := makeLinkMedia(m, sortedFsEntries)
 			if err != nil {
 				writeError(w, http.StatusNotFound, "Not Found")
@@ -573,12 +676,16 @@ func makeGalleryRootHandler(fSys fs.FS, sizeFn func(string) int64) func(w http.R
 			 */
 
 			var media []Media
+			hasFolders := false
 			for _, f := range sortedFsEntries {
-				m := makeMedia(path.Join(r.URL.Path, f.Name()), assetsRoute, urlPrefix)
+				m := withCaption(makeMedia(path.Join(r.URL.Path, f.Name()), assetsRoute, urlPrefix), index)
 				media = append(media, m)
+				if _, virtual := f.(virtualDirEntry); f.IsDir() && !virtual {
+					hasFolders = true
+				}
 			}
 
-			galleryHandler(media, r.URL.Path, path.Dir(urlPrefix+"/"+r.URL.Path), r.URL.Path, getAlbumSize(p, sortedFsEntries, sizeFn))(w, r)
+			galleryHandler(media, r.URL.Path, path.Dir(urlPrefix+"/"+r.URL.Path), r.URL.Path, getAlbumSize(p, sortedFsEntries, sizeFn), hasFolders)(w, r)
 		}
 	}
 }
@@ -586,7 +693,7 @@ func makeGalleryRootHandler(fSys fs.FS, sizeFn func(string) int64) func(w http.R
 // Handler that will update s3 file list.
 // Because fetching media from s3 is slow we prefetch the entire collection into RAM.
 // When user update media in the s3 bucket changed won't be reflected until
-// this server is restarted OR user call GET /update endpoint
+// this server is restarted OR admin call POST /update endpoint
 func makeUpdateHandler(update func() error) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := update()
@@ -628,26 +735,199 @@ func getAlbumSize(dirPath string, entries []fs.DirEntry, sizeFn func(string) int
 	return formatSize(total)
 }
 
+// zipEntry is a file written into ZIP archive
+type zipEntry struct {
+	// Name of the file inside of the archive
+	Name string
+	// Path of the file in the storage
+	Path string
+}
+
+// dirZipEntries returns archive entries for files of a single folder
+func dirZipEntries(entries []fs.DirEntry, dirPath string) []zipEntry {
+	zipEntries := []zipEntry{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		zipEntries = append(zipEntries, zipEntry{Name: entry.Name(), Path: path.Join(dirPath, entry.Name())})
+	}
+	return zipEntries
+}
+
+// treeZipEntries returns archive entries for media in dirPath and all of its
+// subfolders. Entries are named by their path relative to dirPath so the
+// archive keeps the folder structure.
+func treeZipEntries(fSys fs.FS, dirPath string) ([]zipEntry, error) {
+	entries := []zipEntry{}
+	err := fs.WalkDir(fSys, dirPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p == trashFolder && p != dirPath {
+			return fs.SkipDir
+		}
+		if d.IsDir() || getMediaType(path.Ext(p)) == Other {
+			return nil
+		}
+
+		name := p
+		if dirPath != "." {
+			name = strings.TrimPrefix(p, dirPath+"/")
+		}
+		entries = append(entries, zipEntry{Name: name, Path: p})
+
+		return nil
+	})
+	return entries, err
+}
+
+// uniqueZipEntries returns archive entries for files from arbitrary folders.
+// Files are stored by their name, files with the same name from different
+// folders get the folder path in their name.
+func uniqueZipEntries(paths []string) []zipEntry {
+	counts := make(map[string]int)
+	for _, p := range paths {
+		counts[path.Base(p)]++
+	}
+
+	entries := []zipEntry{}
+	for _, p := range paths {
+		name := path.Base(p)
+		if counts[name] > 1 {
+			name = strings.ReplaceAll(p, "/", "_")
+		}
+		entries = append(entries, zipEntry{Name: name, Path: p})
+	}
+
+	return entries
+}
+
 // calculateZipSize computes the exact byte size of a ZIP archive in Store mode
 // without downloading any file contents. It only needs filenames and sizes.
 // ZIP entry overhead: local header (30 + name) + data + data descriptor (16) + central dir entry (46 + name) + EOCD (22)
 func calculateZipSize(entries []fs.DirEntry, dirPath string, sizeFn func(string) int64) int64 {
-	var total int64
+	return zipEntriesSize(dirZipEntries(entries, dirPath), sizeFn)
+}
+
+// zipEntriesSize is calculateZipSize for entries coming from arbitrary folders.
+// Archives over 4 GB or with more than 65535 files use ZIP64 records,
+// sizes follow what zipArchive writes, the same records as archive/zip:
+// - files of 4 GB and more have 24 byte data descriptor and 16 byte sizes in ZIP64 extra field of central dir entry
+// - files starting after 4 GB have 8 byte offset in ZIP64 extra field
+// - ZIP64 extra field has 4 byte header
+// - ZIP64 end of central directory record (56) and locator (20) are added before EOCD
+func zipEntriesSize(entries []zipEntry, sizeFn func(string) int64) int64 {
+	const uint16max = 1<<16 - 1
+	const uint32max = 1<<32 - 1
+
+	var offset, dirSize int64
+	usedZip64 := false
 	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+		size := sizeFn(entry.Path)
+		headerOffset := offset
+
+		offset += 30 + int64(len(entry.Name)) // local file header
+		offset += size                        // file data
+		if size >= uint32max {
+			offset += 24 // ZIP64 data descriptor
+		} else {
+			offset += 16 // data descriptor
+		}
+
+		dirSize += 46 + int64(len(entry.Name)) // central directory entry
+		var extra int64
+		if size >= uint32max {
+			extra += 16 // uncompressed and compressed size
+		}
+		if headerOffset >= uint32max {
+			extra += 8 // local header offset
 		}
-		name := entry.Name()
-		total += 30 + int64(len(name)) // local file header
-		total += sizeFn(path.Join(dirPath, name)) // file data
-		total += 16                                // data descriptor
-		total += 46 + int64(len(name)) // central directory entry
+		if extra > 0 {
+			dirSize += 4 + extra
+			usedZip64 = true
+		}
+	}
+
+	total := offset + dirSize
+	if usedZip64 || len(entries) >= uint16max || dirSize >= uint32max || offset >= uint32max {
+		total += 56 + 20 // ZIP64 end of central directory record and locator
 	}
 	total += 22 // end of central directory record
 	return total
 }
 
-func makeDownloadHandler(fSys fs.FS, readFile readFileFunc, sizeFn func(string) int64) func(w http.ResponseWriter, r *http.Request) {
+// Archive formats of downloads
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// writeArchive serves entries as archive in the format requested by "format"
+// parameter, ZIP by default. Files are looked up before anything is sent so
+// missing ones can still be reported with an error status.
+func writeArchive(w http.ResponseWriter, r *http.Request, archiveName string, entries []zipEntry, fSys fs.FS, readFile readFileFunc, sizeFn func(string) int64) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = FormatZip
+	}
+	if format != FormatZip && format != FormatTar && format != FormatTarGz {
+		writeError(w, http.StatusBadRequest, "unsupported archive format: "+format)
+		return
+	}
+
+	files, failed := statZipFiles(entries, fSys, sizeFn)
+	for _, err := range failed {
+		logError("%s.%s: skipping %v", archiveName, format, err)
+	}
+	if len(failed) > 0 {
+		if !zipErrorManifest {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%d files of the archive can not be read", len(failed)))
+			return
+		}
+		files = append(files, errorsZipFile(failed))
+	}
+
+	activeDownloads.Add(1)
+	defer activeDownloads.Add(-1)
+
+	var err error
+	if format == FormatZip {
+		err = writeZip(w, r, archiveName, files, readFile)
+	} else {
+		err = writeTar(w, r, archiveName, files, readFile, format == FormatTarGz)
+	}
+
+	// Headers are already sent, close the connection so the client does not
+	// take the truncated body for a complete archive
+	if err != nil {
+		logError("%s.%s: download aborted: %v", archiveName, format, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeZip serves files as ZIP archive in Store mode with exact Content-Length.
+// The archive layout is deterministic, interrupted downloads can be resumed
+// with Range and If-Range requests.
+func writeZip(w http.ResponseWriter, r *http.Request, archiveName string, files []zipFile, readFile readFileFunc) error {
+	archive := newZipArchive(files, readFile, zipCRCs)
+	archive.prefetch(r.Context(), zipPrefetch, zipPrefetchBytes)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+archiveName+".zip\"")
+	w.Header().Set("ETag", archive.etag())
+
+	http.ServeContent(w, r, archiveName+".zip", archive.modTime(), archive)
+
+	if err := zipCRCs.save(); err != nil {
+		logError("Error saving CRC cache: %v", err)
+	}
+
+	return archive.err
+}
+
+func makeDownloadHandler(fSys fs.FS, readFile readFileFunc, sizeFn func(string) int64, albums *albumStore, favorites *favoriteStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Strip the urlPrefix + "/download" prefix from the path
 		p := strings.TrimPrefix(r.URL.Path, urlPrefix+"/download")
@@ -656,44 +936,112 @@ func makeDownloadHandler(fSys fs.FS, readFile readFileFunc, sizeFn func(string)
 			p = "."
 		}
 
-		fsItems, err := listFsItems(fSys, p)
-		if err != nil {
-			writeError(w, http.StatusNotFound, err.Error())
+		// Virtual albums and favorites collect media from many folders
+		vf, ok := albums.folder(p)
+		if !ok {
+			vf, ok = favorites.folder(p)
+		}
+		if ok {
+			if isAnonymous(r) {
+				denyAccess(w, r)
+				return
+			}
+			writeArchive(w, r, path.Base(p), vf.visibleTo(r).zipEntries(), fSys, readFile, sizeFn)
 			return
 		}
 
-		filtered := filterNonSupported(fsItems)
-		sorted := sortDirEntries(filtered)
+		if !canDownload(r, p) {
+			denyAccess(w, r)
+			return
+		}
 
-		w.Header().Set("Content-Type", "application/zip")
 		folderName := path.Base(p)
 		if folderName == "." {
 			folderName = "gallery"
 		}
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+folderName+".zip\"")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", calculateZipSize(sorted, p, sizeFn)))
 
-		zipWriter := zip.NewWriter(w)
-		defer zipWriter.Close()
+		// With "recursive" parameter subfolders are included as folders inside of the archive
+		if recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive")); recursive {
+			tree, err := treeZipEntries(fSys, p)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err.Error())
+				return
+			}
+
+			entries := []zipEntry{}
+			for _, e := range tree {
+				if isVisible(r, p, e.Path, false) {
+					entries = append(entries, e)
+				}
+			}
+
+			writeArchive(w, r, folderName, entries, fSys, readFile, sizeFn)
+			return
+		}
+
+		fsItems, err := listFsItems(fSys, p)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
 
-		for _, entry := range sorted {
-			if entry.IsDir() {
-				continue
+		visible := []fs.DirEntry{}
+		for _, f := range fsItems {
+			if isVisible(r, p, path.Join(p, f.Name()), f.IsDir()) {
+				visible = append(visible, f)
 			}
-			data, err := readFile(path.Join(p, entry.Name()))
-			if err != nil {
+		}
+
+		filtered := filterNonSupported(visible)
+		sorted := sortDirEntries(filtered)
+
+		writeArchive(w, r, folderName, dirZipEntries(sorted, p), fSys, readFile, sizeFn)
+	}
+}
+
+// makeSelectionDownloadHandler zips media picked in the gallery selection mode.
+// It takes repeated "path" form values relative to the gallery root, media
+// can come from different folders.
+func makeSelectionDownloadHandler(fSys fs.FS, readFile readFileFunc, sizeFn func(string) int64) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		paths := []string{}
+		seen := make(map[string]bool)
+		for _, p := range r.PostForm["path"] {
+			p = strings.Trim(p, "/")
+			if seen[p] {
 				continue
 			}
-			header := &zip.FileHeader{
-				Name:     entry.Name(),
-				Method:   zip.Store,
+			seen[p] = true
+
+			if !fs.ValidPath(p) || getMediaType(path.Ext(p)) == Other {
+				writeError(w, http.StatusBadRequest, "invalid media path: "+p)
+				return
 			}
-			f, err := zipWriter.CreateHeader(header)
-			if err != nil {
-				continue
+			// Media the user has no access to is reported the same as missing one
+			info, err := fs.Stat(fSys, p)
+			if err != nil || info.IsDir() || !canDownload(r, p) {
+				writeError(w, http.StatusNotFound, "media not found: "+p)
+				return
 			}
-			f.Write(data)
+
+			paths = append(paths, p)
+		}
+
+		if len(paths) == 0 {
+			writeError(w, http.StatusBadRequest, "no media selected")
+			return
+		}
+		if len(paths) > maxSelection {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("too many media selected, at most %d can be downloaded at once", maxSelection))
+			return
 		}
+
+		writeArchive(w, r, "selection", uniqueZipEntries(paths), fSys, readFile, sizeFn)
 	}
 }
 
@@ -702,7 +1050,7 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // Returns a local filesystem handler that implements fs.FS interface
-func localFS(folder string) (fs.FS, readFileFunc, func(string) int64, func() error) {
+func localFS(folder string) (fs.FS, readFileFunc, func(string) int64, func() error, storageWriter) {
 	readFile := func(p string) ([]byte, error) {
 		return os.ReadFile(filepath.Join(folder, p))
 	}
@@ -713,13 +1061,37 @@ func localFS(folder string) (fs.FS, readFileFunc, func(string) int64, func() err
 		}
 		return info.Size()
 	}
-	return os.DirFS(folder), readFile, sizeFn, func() error {
+	update := func() error {
 		// No-op update function since local filesystem is always up to date
 		return nil
 	}
+	return os.DirFS(folder), readFile, sizeFn, update, localWriter{root: folder}
 }
 
 func main() {
+	// Command line tools share configuration with the server
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "hash-password":
+			err = hashPasswordCommand(os.Args[2:])
+		case "share":
+			err = shareCommand(os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q, available commands: hash-password, share", os.Args[1])
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	auth, err := newAuthProvider()
+	if err != nil {
+		panic(err)
+	}
+
 	mux := http.NewServeMux()
 	galleryMux := http.NewServeMux()
 
@@ -729,39 +1101,174 @@ func main() {
 	var readFile readFileFunc
 	var sizeFn func(string) int64
 	var update func() error
+	var storage storageWriter
+	var backend string
 
 	if assetsFolder != "" {
 		// Use local folder as a media backend
-		rootFS, readFile, sizeFn, update = localFS(assetsFolder)
+		rootFS, readFile, sizeFn, update, storage = localFS(assetsFolder)
+		backend = "local:" + assetsFolder
 
 		// Handle public assets from public directory under example.com/assets URL
 		fs := http.FileServer(http.Dir(assetsFolder))
-		mux.Handle(assetsRoute+"/", http.StripPrefix(assetsRoute, fs))
+		mux.Handle(assetsRoute+"/", http.StripPrefix(assetsRoute, requireReadable(fs)))
 	} else {
 		// Use s3 as media backend
-		rootFS, readFile, sizeFn, update = s3FS(s3List)
+		rootFS, readFile, sizeFn, update, storage = s3FS(s3List)
+		backend = strings.TrimSuffix("s3://"+getEnv("CCG_S3_BUCKET", "cc-storage")+"/"+getEnv("CCG_S3_ROOT_DIR", ""), "/")
 	}
 
-	err := update()
+	// Media index is rebuilt every time storage listing is updated
+	index := newMediaIndex(rootFS, readFile, sizeFn)
+	// Reading EXIF makes a request per JPEG on S3, there it has to be turned on
+	readExif := "true"
+	if s3, ok := storage.(s3Writer); ok {
+		index.readHead = s3.readHead
+		readExif = "false"
+	}
+	index.readExif = getEnv("CCG_INDEX_EXIF", readExif) == "true"
+	zipErrorManifest = getEnv("CCG_ZIP_ERROR_MANIFEST", "false") == "true"
+	zipPrefetch, err = strconv.Atoi(getEnv("CCG_ZIP_PREFETCH", "8"))
+	if err != nil {
+		panic(fmt.Errorf("CCG_ZIP_PREFETCH: %w", err))
+	}
+	prefetchMB, err := strconv.Atoi(getEnv("CCG_ZIP_PREFETCH_MB", "256"))
 	if err != nil {
+		panic(fmt.Errorf("CCG_ZIP_PREFETCH_MB: %w", err))
+	}
+	zipPrefetchBytes = int64(prefetchMB) << 20
+	zipCRCs.file = getEnv("CCG_ZIP_CRC_FILE", "zip-crcs.json")
+	if err := zipCRCs.load(); err != nil {
 		panic(err)
 	}
+	// Albums and access rules are defined in JSON files and reloaded on update as well
+	albums := newAlbumStore(getEnv("CCG_ALBUMS_FILE", "albums.json"), index)
+	acl := newACLStore(getEnv("CCG_ACL_FILE", "acl.json"))
 
-	galleryRootHandler := makeGalleryRootHandler(rootFS, sizeFn)
-	downloadHandler := makeDownloadHandler(rootFS, readFile, sizeFn)
+	refresh := func() error {
+		if err := index.refreshFrom(update); err != nil {
+			return err
+		}
+		for _, name := range shadowedFolders(rootFS) {
+			logError("Folder %q in the storage root is hidden by the virtual %s folder, rename it to browse its media", name, name)
+		}
+		zipCRCs.prune(rootFS, sizeFn)
+		if err := zipCRCs.save(); err != nil {
+			logError("Error saving CRC cache: %v", err)
+		}
+		if err := acl.load(); err != nil {
+			return err
+		}
+		return albums.load()
+	}
+
+	err = refresh()
+	if err != nil {
+		panic(err)
+	}
+
+	favorites := newFavoriteStore(getEnv("CCG_FAVORITES_FILE", "favorites.json"), index)
+	err = favorites.load()
+	if err != nil {
+		panic(err)
+	}
+
+	galleryRootHandler := makeGalleryRootHandler(rootFS, sizeFn, index, albums, favorites)
+	downloadHandler := makeDownloadHandler(rootFS, readFile, sizeFn, albums, favorites)
+	selectionDownloadHandler := makeSelectionDownloadHandler(rootFS, readFile, sizeFn)
+	albumsHandler := makeAlbumsHandler(albums)
+	favoritesHandler := makeFavoritesHandler(favorites)
+	favoritesApiHandler := requireSameOrigin(makeFavoritesApiHandler(favorites, index))
+	timelineHandler := makeTimelineHandler(index)
+	memoriesHandler := makeMemoriesHandler(index)
+	memoriesApiHandler := makeMemoriesApiHandler(index)
+	mapHandler := makeMapHandler(index)
+	geoJSONHandler := makeGeoJSONHandler(index)
 
 	// Configure gallery mux
 	galleryMux.HandleFunc("/", galleryRootHandler)
+	galleryMux.HandleFunc("/"+albumsFolder, albumsHandler)
+	galleryMux.HandleFunc("/"+albumsFolder+"/", albumsHandler)
+	galleryMux.HandleFunc("/"+favoritesFolder, favoritesHandler)
+	galleryMux.HandleFunc("/"+favoritesFolder+"/", favoritesHandler)
 
-	updateHandler := makeUpdateHandler(update)
+	updateHandler := makeUpdateHandler(refresh)
+	dash := newDashboard(backend, rootFS, sizeFn, index)
 
 	// Configure main mux
-	mux.HandleFunc(urlPrefix+"/update", updateHandler)
+	mux.HandleFunc(urlPrefix+"/update", requireAdmin(requireSameOrigin(requireMethod(http.MethodPost, updateHandler))))
+	mux.HandleFunc(urlPrefix+"/dashboard", requireAdmin(makeDashboardHandler(dash)))
+	mux.HandleFunc(urlPrefix+"/api/dashboard", requireAdmin(requireMethod(http.MethodGet, makeDashboardApiHandler(dash))))
+	mux.HandleFunc(urlPrefix+"/download", requireMethod(http.MethodPost, selectionDownloadHandler))
 	mux.HandleFunc(urlPrefix+"/download/", downloadHandler)
+	mux.HandleFunc(urlPrefix+"/timeline/", timelineHandler)
+	mux.HandleFunc(urlPrefix+"/memories", memoriesHandler)
+	mux.HandleFunc(urlPrefix+"/api/memories", memoriesApiHandler)
+	mux.HandleFunc(urlPrefix+"/map/", mapHandler)
+	mux.HandleFunc(urlPrefix+"/api/geojson/", geoJSONHandler)
+	mux.HandleFunc(urlPrefix+"/api/favorites", favoritesApiHandler)
 	mux.Handle(urlPrefix+"/", http.StripPrefix(urlPrefix, galleryMux))
 	mux.HandleFunc("/", rootHandler)
 
+	// Uploads and changes need authentication, without it everybody is an admin
+	if _, public := auth.(noAuth); !public {
+		maxUploadMB, err := strconv.Atoi(getEnv("CCG_UPLOAD_MAX_MB", "4096"))
+		if err != nil {
+			panic(fmt.Errorf("CCG_UPLOAD_MAX_MB: %w", err))
+		}
+		uploads := newUploadStore(getEnv("CCG_UPLOAD_DIR", filepath.Join(os.TempDir(), "gallery-uploads")), int64(maxUploadMB)<<20, rootFS, storage, index)
+		if err := uploads.load(); err != nil {
+			panic(err)
+		}
+
+		uploadPageHandler := requireAdmin(makeUploadPageHandler(uploads))
+		uploadApiHandler := requireAdmin(requireSameOrigin(makeUploadApiHandler(uploads)))
+		mux.HandleFunc(urlPrefix+"/upload", uploadPageHandler)
+		mux.HandleFunc(urlPrefix+"/upload/", uploadPageHandler)
+		mux.HandleFunc(urlPrefix+"/api/upload", uploadApiHandler)
+		mux.HandleFunc(urlPrefix+"/api/upload/", uploadApiHandler)
+
+		trashDays, err := strconv.Atoi(getEnv("CCG_TRASH_DAYS", "30"))
+		if err != nil {
+			panic(fmt.Errorf("CCG_TRASH_DAYS: %w", err))
+		}
+		media := newMediaManager(rootFS, storage, index, favorites)
+		media.trashRetention = time.Duration(trashDays) * 24 * time.Hour
+		media.readFile = readFile
+
+		// Deleted media older than the retention is purged on start and then every hour
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			for {
+				if err := media.purgeExpired(time.Now(), false); err != nil {
+					logError("Error purging trash: %v", err)
+				}
+				<-ticker.C
+			}
+		}()
+
+		trashApiHandler := requireAdmin(requireSameOrigin(makeTrashApiHandler(media)))
+		mux.HandleFunc(urlPrefix+"/api/media/", requireAdmin(requireSameOrigin(requireMethod(http.MethodPost, makeMediaApiHandler(media)))))
+		mux.HandleFunc(urlPrefix+"/trash", requireAdmin(makeTrashPageHandler(media)))
+		mux.HandleFunc(urlPrefix+"/api/trash", trashApiHandler)
+		mux.HandleFunc(urlPrefix+"/api/trash/", trashApiHandler)
+
+		// Purging caches changes the server, it needs authentication like other changes
+		mux.HandleFunc(urlPrefix+"/api/dashboard/", requireAdmin(requireSameOrigin(makeDashboardApiHandler(dash))))
+	}
+
+	if sa, ok := auth.(sessionAuth); ok {
+		mux.HandleFunc(urlPrefix+"/login", makeLoginHandler(sa))
+		mux.HandleFunc(urlPrefix+"/logout", logoutHandler)
+	}
+
+	// Share links are verified in front of the gallery when there is a secret to sign them
+	var handler http.Handler = mux
+	if shares := newShareStoreFromEnv(); shares != nil {
+		handler = shares.middleware(mux)
+	}
+
 	address := getEnv("CCG_SERVER_ADDRESS", "localhost:8080")
 	fmt.Printf("[+] Listening on %s\n", address)
-	log.Fatal(http.ListenAndServe(address, mux))
+	log.Fatal(http.ListenAndServe(address, authMiddleware(auth, acl, handler)))
 }
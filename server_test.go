@@ -4,11 +4,14 @@ import (
 	"archive/zip"
 	"bytes"
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"testing/fstest"
 )
@@ -552,7 +555,7 @@ func TestS3FS(t *testing.T) {
 	}
 
 	// Create the filesystem
-	fs, _, _, update := s3FS(mockFileListFn)
+	fs, _, _, update, _ := s3FS(mockFileListFn)
 
 	// Test initial state before update
 	if _, err := fs.Open("folder1/image1.jpg"); err == nil {
@@ -583,7 +586,7 @@ func TestS3FS(t *testing.T) {
 	errorFileListFn := func() ([]s3Object, error) {
 		return nil, fmt.Errorf("mock error")
 	}
-	_, _, _, errorUpdate := s3FS(errorFileListFn)
+	_, _, _, errorUpdate, _ := s3FS(errorFileListFn)
 	if err := errorUpdate(); err == nil {
 		t.Error("Expected error from update with failing fileListFn, got nil")
 	}
@@ -763,3 +766,175 @@ func TestCalculateZipSize_WithRealZip(t *testing.T) {
 		t.Errorf("calculated %d but actual zip is %d bytes", calculated, buf.Len())
 	}
 }
+
+func TestSelectionDownload(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/2023/post_1700000000_0.jpg":  &fstest.MapFile{Data: []byte("kif 2023")},
+		"kif/2024/post_1700000000_0.jpg":  &fstest.MapFile{Data: []byte("kif 2024")},
+		"snay/2023/reel_1700000001_0.mp4": &fstest.MapFile{Data: []byte("snay reel")},
+		"snay/2023/notes.txt":             &fstest.MapFile{Data: []byte("notes")},
+	}
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	handler := makeSelectionDownloadHandler(mockFs, readFile, sizeFn)
+
+	post := func(paths ...string) *httptest.ResponseRecorder {
+		form := url.Values{"path": paths}
+		req := httptest.NewRequest("POST", "/gallery/download", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := post("kif/2023/post_1700000000_0.jpg", "/snay/2023/reel_1700000001_0.mp4", "kif/2024/post_1700000000_0.jpg", "kif/2023/post_1700000000_0.jpg")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Length") != fmt.Sprintf("%d", rr.Body.Len()) {
+		t.Errorf("Content-Length %s does not match body size %d", rr.Header().Get("Content-Length"), rr.Body.Len())
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"kif_2023_post_1700000000_0.jpg", "reel_1700000001_0.mp4", "kif_2024_post_1700000000_0.jpg"}
+	if len(zr.File) != len(expected) {
+		t.Fatalf("expected %d files, got %d", len(expected), len(zr.File))
+	}
+	for i, f := range zr.File {
+		if f.Name != expected[i] {
+			t.Errorf("file %d: expected %q, got %q", i, expected[i], f.Name)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		paths        []string
+		expectedCode int
+	}{
+		{"nothing selected", nil, http.StatusBadRequest},
+		{"missing media", []string{"kif/2023/post_1_0.jpg"}, http.StatusNotFound},
+		{"dot segments", []string{"kif/2023/../2024/post_1700000000_0.jpg"}, http.StatusBadRequest},
+		{"unsupported file", []string{"snay/2023/notes.txt"}, http.StatusBadRequest},
+		{"path outside of the gallery", []string{"../etc/post_1_0.jpg"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := post(tt.paths...); rr.Code != tt.expectedCode {
+				t.Errorf("expected status %d, got %d", tt.expectedCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestRecursiveDownload(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/post_1700000003_0.jpg":         &fstest.MapFile{Data: []byte("kif")},
+		"kif/2023/post_1700000000_0.jpg":    &fstest.MapFile{Data: []byte("kif 2023")},
+		"kif/2023/notes.txt":                &fstest.MapFile{Data: []byte("notes")},
+		"kif/2024/01/reel_1700000001_0.mp4": &fstest.MapFile{Data: []byte("kif 2024")},
+		"snay/2023/post_1700000002_0.jpg":   &fstest.MapFile{Data: []byte("snay")},
+	}
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	handler := makeDownloadHandler(mockFs, readFile, sizeFn, nil, nil)
+
+	download := func(url string) []string {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("GET", url, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rr.Code)
+		}
+		if rr.Header().Get("Content-Length") != fmt.Sprintf("%d", rr.Body.Len()) {
+			t.Errorf("Content-Length %s does not match body size %d", rr.Header().Get("Content-Length"), rr.Body.Len())
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		url      string
+		expected []string
+	}{
+		{"/gallery/download/kif", []string{"post_1700000003_0.jpg"}},
+		{"/gallery/download/kif?recursive=1", []string{"2023/post_1700000000_0.jpg", "2024/01/reel_1700000001_0.mp4", "post_1700000003_0.jpg"}},
+		{"/gallery/download/kif/2024?recursive=true", []string{"01/reel_1700000001_0.mp4"}},
+		{"/gallery/download/?recursive=1", []string{"kif/2023/post_1700000000_0.jpg", "kif/2024/01/reel_1700000001_0.mp4", "kif/post_1700000003_0.jpg", "snay/2023/post_1700000002_0.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			names := download(tt.url)
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+// zipSize writes ZIP archive of zero filled files the same way as writeZip and returns its size
+func zipSize(t *testing.T, entries []zipEntry, sizeFn func(string) int64) int64 {
+	t.Helper()
+
+	cw := &countingWriter{}
+	zw := zip.NewWriter(cw)
+	zeros := bytes.NewReader(make([]byte, 1<<20))
+	for _, e := range entries {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for left := sizeFn(e.Path); left > 0; {
+			zeros.Seek(0, 0)
+			n, _ := io.CopyN(f, zeros, min(left, 1<<20))
+			left -= n
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return cw.n
+}
+
+func TestZipEntriesSize_ManyFiles(t *testing.T) {
+	// More than 65535 entries need ZIP64 end of central directory
+	entries := []zipEntry{}
+	for i := 0; i < 70000; i++ {
+		entries = append(entries, zipEntry{Name: fmt.Sprintf("dir/%d.jpg", i), Path: fmt.Sprintf("%d", i)})
+	}
+	sizeFn := func(string) int64 { return 3 }
+
+	if expected, got := zipSize(t, entries, sizeFn), zipEntriesSize(entries, sizeFn); expected != got {
+		t.Errorf("calculated %d but actual zip is %d bytes", got, expected)
+	}
+}
+
+func TestZipEntriesSize_Zip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes more than 4 GB of data")
+	}
+
+	// File over 4 GB followed by a file that starts after 4 GB
+	sizes := map[string]int64{"big": 1<<32 + 10, "after": 5, "before": 7}
+	sizeFn := func(p string) int64 { return sizes[p] }
+	entries := []zipEntry{
+		{Name: "before.jpg", Path: "before"},
+		{Name: "2023/big.mp4", Path: "big"},
+		{Name: "2023/after.jpg", Path: "after"},
+	}
+
+	if expected, got := zipSize(t, entries, sizeFn), zipEntriesSize(entries, sizeFn); expected != got {
+		t.Errorf("calculated %d but actual zip is %d bytes", got, expected)
+	}
+}
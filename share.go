@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the cookie holding share token of the visitor
+const shareCookie = "ccg_share"
+
+// Share is a link that gives access to a folder or a single media to anyone
+// who has it. Shares are listed in the shares file so they can be revoked.
+type Share struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	// Whether ZIP download of shared folders is allowed
+	Download bool      `json:"download"`
+	Password bool      `json:"password"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	Revoked  bool      `json:"revoked"`
+}
+
+// shareToken is signed payload of the share URL
+type shareToken struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Expires  int64  `json:"exp"`
+	Download bool   `json:"dl,omitempty"`
+	// HMAC of the share password, so the password can be checked without storing it
+	Password string `json:"pw,omitempty"`
+}
+
+type sharesFile struct {
+	Shares []Share `json:"shares"`
+}
+
+// shareStore mints and verifies share tokens. Shares file is written by the
+// share command and reread by the server when it changes, so revoked links
+// stop working without restart.
+type shareStore struct {
+	mu      sync.Mutex
+	file    string
+	secret  []byte
+	modTime time.Time
+	size    int64
+	shares  map[string]Share
+}
+
+func newShareStore(file string, secret []byte) *shareStore {
+	return &shareStore{file: file, secret: secret, shares: make(map[string]Share)}
+}
+
+func (s *shareStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *shareStore) passwordMAC(id string, password string) string {
+	return s.sign("password|" + id + "|" + password)
+}
+
+// reload rereads shares file if it changed since the last read. Caller must hold the lock.
+func (s *shareStore) reload() error {
+	info, err := os.Stat(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		s.shares = make(map[string]Share)
+		s.modTime, s.size = time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading shares file %s: %w", s.file, err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return fmt.Errorf("error reading shares file %s: %w", s.file, err)
+	}
+	sf := sharesFile{}
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return fmt.Errorf("error parsing shares file %s: %w", s.file, err)
+	}
+
+	s.shares = make(map[string]Share)
+	for _, sh := range sf.Shares {
+		s.shares[sh.ID] = sh
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+
+	return nil
+}
+
+// save writes shares file oldest share first. Caller must hold the lock.
+func (s *shareStore) save() error {
+	sf := sharesFile{Shares: s.sorted()}
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".shares-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// sorted returns shares oldest first. Caller must hold the lock.
+func (s *shareStore) sorted() []Share {
+	list := []Share{}
+	for _, sh := range s.shares {
+		list = append(list, sh)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+func (s *shareStore) list() ([]Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s.sorted(), nil
+}
+
+// create mints a new share of p valid for ttl and returns its token
+func (s *shareStore) create(p string, ttl time.Duration, password string, download bool) (Share, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return Share{}, "", err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Share{}, "", err
+	}
+
+	now := time.Now()
+	sh := Share{
+		ID:       hex.EncodeToString(id),
+		Path:     cleanACLPath(p),
+		Download: download,
+		Password: password != "",
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}
+
+	st := shareToken{ID: sh.ID, Path: sh.Path, Expires: sh.Expires.Unix(), Download: download}
+	if password != "" {
+		st.Password = s.passwordMAC(sh.ID, password)
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return Share{}, "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	s.shares[sh.ID] = sh
+	if err := s.save(); err != nil {
+		delete(s.shares, sh.ID)
+		return Share{}, "", err
+	}
+
+	return sh, payload + "." + s.sign(payload), nil
+}
+
+func (s *shareStore) revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	sh, ok := s.shares[id]
+	if !ok {
+		return fmt.Errorf("share %s not found", id)
+	}
+	sh.Revoked = true
+	s.shares[id] = sh
+
+	return s.save()
+}
+
+// verify checks token signature, expiry and revocation and returns the token payload
+func (s *shareStore) verify(token string) (*shareToken, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return nil, false
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+	st := &shareToken{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, false
+	}
+	if time.Now().Unix() > st.Expires {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		fmt.Println("[-]", err)
+		return nil, false
+	}
+	// Only shares listed in the shares file are valid
+	if sh, ok := s.shares[st.ID]; !ok || sh.Revoked {
+		return nil, false
+	}
+
+	return st, true
+}
+
+// unlockProof is appended to share cookie after the visitor entered the share password
+func (s *shareStore) unlockProof(st *shareToken) string {
+	return s.sign("unlock|" + st.ID + "|" + st.Password)
+}
+
+// fromCookie returns share of the visitor if it is valid and unlocked
+func (s *shareStore) fromCookie(r *http.Request) *shareToken {
+	c, err := r.Cookie(shareCookie)
+	if err != nil {
+		return nil
+	}
+
+	token, proof := c.Value, ""
+	if i := strings.LastIndex(c.Value, "~"); i >= 0 {
+		token, proof = c.Value[:i], c.Value[i+1:]
+	}
+
+	st, ok := s.verify(token)
+	if !ok {
+		return nil
+	}
+	if st.Password != "" && !hmac.Equal([]byte(proof), []byte(s.unlockProof(st))) {
+		return nil
+	}
+	return st
+}
+
+// allows reports whether share gives access to p
+func (st *shareToken) allows(p string) bool {
+	return st != nil && isWithin(cleanACLPath(p), st.Path)
+}
+
+type SharePage struct {
+	Title  string
+	Error  string
+	Styles template.CSS
+}
+
+// shareLink returns page that share opens, parent folder of shared media
+// is not accessible so single media opens in the player
+func shareLink(st *shareToken) string {
+	return path.Join(urlPrefix, st.Path)
+}
+
+// middleware opens share links and attaches share of the visitor to the request.
+// Share link /share/<token> asks for password if the share has one, stores
+// the token in a cookie and redirects to the shared folder or media.
+func (s *shareStore) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.URL.Path, urlPrefix+"/share/"); ok {
+			s.serveShareLink(w, r, token)
+			return
+		}
+
+		if ra := requestAuthFromContext(r); ra != nil && ra.user == nil {
+			if st := s.fromCookie(r); st != nil {
+				shared := *ra
+				shared.share = st
+				r = r.WithContext(context.WithValue(r.Context(), requestAuthContextKey{}, &shared))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *shareStore) serveShareLink(w http.ResponseWriter, r *http.Request, token string) {
+	st, ok := s.verify(token)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Share link is invalid or expired")
+		return
+	}
+
+	value := token
+	if st.Password != "" {
+		page := SharePage{Title: "Shared media", Styles: template.CSS(append(loginCss, globalCss...))}
+
+		if r.Method != http.MethodPost {
+			if err := tmpl.ExecuteTemplate(w, "share.html", page); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		mac := s.passwordMAC(st.ID, r.FormValue("password"))
+		if !hmac.Equal([]byte(mac), []byte(st.Password)) {
+			page.Error = "Invalid password"
+			w.WriteHeader(http.StatusUnauthorized)
+			if err := tmpl.ExecuteTemplate(w, "share.html", page); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		value += "~" + s.unlockProof(st)
+	}
+
+	// Assets may be served outside of the url prefix so the cookie is set for the whole site
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Unix(st.Expires, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, shareLink(st), http.StatusSeeOther)
+}
+
+// newShareStoreFromEnv configures share links. Share links are disabled
+// (nil store) when there is no secret to sign them with.
+func newShareStoreFromEnv() *shareStore {
+	secret := getEnv("CCG_SHARE_SECRET", getEnv("CCG_AUTH_SECRET", ""))
+	if secret == "" {
+		return nil
+	}
+	return newShareStore(getEnv("CCG_SHARES_FILE", "shares.json"), []byte(secret))
+}
+
+// shareCommand mints, lists and revokes share links
+func shareCommand(args []string) error {
+	usage := errors.New("usage: gallery share create [-expires 168h] [-download] [-password] <path> | list | revoke <id>")
+	if len(args) < 1 {
+		return usage
+	}
+
+	shares := newShareStoreFromEnv()
+	if shares == nil {
+		return errors.New("set CCG_SHARE_SECRET (or CCG_AUTH_SECRET) to sign share links")
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("share create", flag.ContinueOnError)
+		expires := flags.Duration("expires", 7*24*time.Hour, "how long the link is valid")
+		download := flags.Bool("download", false, "allow ZIP download")
+		withPassword := flags.Bool("password", false, "protect the link with password read from stdin")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return usage
+		}
+
+		password := ""
+		if *withPassword {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			password = strings.TrimRight(line, "\r\n")
+			if password == "" {
+				return fmt.Errorf("error reading password: %v", err)
+			}
+		}
+
+		sh, token, err := shares.create(flags.Arg(0), *expires, password, *download)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s %s/share/%s\n", sh.ID, getEnv("CCG_PUBLIC_URL", "")+urlPrefix, token)
+
+	case "list":
+		list, err := shares.list()
+		if err != nil {
+			return err
+		}
+		for _, sh := range list {
+			state := "active"
+			if sh.Revoked {
+				state = "revoked"
+			} else if time.Now().After(sh.Expires) {
+				state = "expired"
+			}
+			fmt.Printf("%s\t%s\t%s\texpires %s\tdownload=%v\tpassword=%v\n",
+				sh.ID, state, "/"+sh.Path, sh.Expires.Format(time.RFC3339), sh.Download, sh.Password)
+		}
+
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+		return shares.revoke(args[1])
+
+	default:
+		return usage
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestShares(t *testing.T) *shareStore {
+	t.Helper()
+	return newShareStore(filepath.Join(t.TempDir(), "shares.json"), []byte("share secret"))
+}
+
+func TestShareVerify(t *testing.T) {
+	shares := newTestShares(t)
+
+	sh, token, err := shares.create("/kif/2023/", time.Hour, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sh.Path != "kif/2023" {
+		t.Errorf("expected cleaned path kif/2023, got %q", sh.Path)
+	}
+
+	st, ok := shares.verify(token)
+	if !ok || st.Path != "kif/2023" || !st.Download {
+		t.Fatalf("expected valid share of kif/2023, got %+v", st)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	if _, ok := shares.verify(payload + "." + strings.Repeat("0", len(sig))); ok {
+		t.Error("expected token with wrong signature to be rejected")
+	}
+	if _, ok := shares.verify("x" + token); ok {
+		t.Error("expected tampered token to be rejected")
+	}
+
+	other := newShareStore(shares.file, []byte("other secret"))
+	if _, ok := other.verify(token); ok {
+		t.Error("expected token signed with other secret to be rejected")
+	}
+
+	_, expired, err := shares.create("kif", -time.Minute, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := shares.verify(expired); ok {
+		t.Error("expected expired token to be rejected")
+	}
+
+	// Revoking from another process (the share command) is picked up from the file
+	cli := newShareStore(shares.file, shares.secret)
+	if err := cli.revoke(sh.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := shares.verify(token); ok {
+		t.Error("expected revoked token to be rejected")
+	}
+	if err := cli.revoke("missing"); err == nil {
+		t.Error("expected error revoking unknown share")
+	}
+
+	list, err := shares.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != sh.ID || !list[0].Revoked {
+		t.Errorf("unexpected shares list %+v", list)
+	}
+}
+
+func TestShareTokenAllows(t *testing.T) {
+	st := &shareToken{Path: "kif/2023"}
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"kif/2023", true},
+		{"/kif/2023/", true},
+		{"kif/2023/post_1700000000_0.jpg", true},
+		{"kif/2023/../private", false},
+		{"kif/20234", false},
+		{"kif", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := st.allows(tt.path); got != tt.expected {
+			t.Errorf("allows(%q) = %v, expected %v", tt.path, got, tt.expected)
+		}
+	}
+
+	var none *shareToken
+	if none.allows("kif") {
+		t.Error("expected nil share to allow nothing")
+	}
+}
+
+// openShare follows share link and returns the share cookie
+func openShare(t *testing.T, handler http.Handler, token string, password string) *http.Cookie {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", urlPrefix+"/share/"+token, nil)
+	if password != "" {
+		form := url.Values{"password": {password}}
+		req = httptest.NewRequest("POST", urlPrefix+"/share/"+token, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect to shared media, got %d", rr.Code)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == shareCookie {
+			return c
+		}
+	}
+	t.Fatal("expected share cookie")
+	return nil
+}
+
+func TestShareMiddleware(t *testing.T) {
+	shares := newTestShares(t)
+	acl := newTestACL(t, testACL)
+	handler := authMiddleware(staticAuth{}, acl, shares.middleware(newACLTestServer(t)))
+
+	_, token, err := shares.create("snay/2023", time.Hour, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := openShare(t, handler, token, "")
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{"shared folder", urlPrefix + "/snay/2023", http.StatusOK},
+		{"shared media", urlPrefix + "/snay/2023/post_1700000002_0.jpg", http.StatusOK},
+		{"shared asset", assetsRoute + "/snay/2023/post_1700000002_0.jpg", http.StatusOK},
+		{"shared timeline", urlPrefix + "/timeline/snay/2023", http.StatusOK},
+		{"parent folder", urlPrefix + "/snay", http.StatusUnauthorized},
+		{"sibling folder", urlPrefix + "/snay/drafts", http.StatusUnauthorized},
+		{"download without permission", urlPrefix + "/download/snay/2023", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.AddCookie(cookie)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Errorf("expected status %d, got %d", tt.expectedCode, rr.Code)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", urlPrefix+"/share/"+token+"x", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected invalid share link to be not found, got %d", rr.Code)
+	}
+}
+
+func TestShareDownloadPermission(t *testing.T) {
+	shares := newTestShares(t)
+	handler := authMiddleware(staticAuth{}, newTestACL(t, testACL), shares.middleware(newACLTestServer(t)))
+
+	_, token, err := shares.create("snay/2023", time.Hour, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := openShare(t, handler, token, "")
+
+	req := httptest.NewRequest("GET", urlPrefix+"/download/snay/2023", nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("expected ZIP download, got %d", rr.Code)
+	}
+}
+
+func TestSharePassword(t *testing.T) {
+	shares := newTestShares(t)
+	handler := authMiddleware(staticAuth{}, newTestACL(t, testACL), shares.middleware(newACLTestServer(t)))
+
+	_, token, err := shares.create("snay/2023", time.Hour, "letmein", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", urlPrefix+"/share/"+token, nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `type="password"`) {
+		t.Fatalf("expected password form, got %d", rr.Code)
+	}
+
+	form := url.Values{"password": {"wrong"}}
+	req := httptest.NewRequest("POST", urlPrefix+"/share/"+token, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected wrong password to be rejected, got %d", rr.Code)
+	}
+
+	// Token alone is not enough without entering the password
+	req = httptest.NewRequest("GET", urlPrefix+"/snay/2023", nil)
+	req.AddCookie(&http.Cookie{Name: shareCookie, Value: token})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected locked share to be rejected, got %d", rr.Code)
+	}
+
+	cookie := openShare(t, handler, token, "letmein")
+	req = httptest.NewRequest("GET", urlPrefix+"/snay/2023", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected unlocked share to open, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,63 @@
+// Package sidecar defines JSON metadata files stored next to media files.
+// Sidecar of kif/2024/post_1700000000_0.jpg is kif/2024/post_1700000000_0.jpg.json.
+// Ingest tools write them and the gallery server reads them from any storage backend.
+package sidecar
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Ext is appended to the media file name to get its sidecar name
+const Ext = ".json"
+
+// Location is a geographic position in decimal degrees
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Metadata is the content of a sidecar file
+type Metadata struct {
+	// Caption or title of the post the media belongs to
+	Caption string `json:"caption,omitempty"`
+	// Path of the media inside of the original export
+	SourceURI string `json:"source_uri,omitempty"`
+	// Kind of media in the source e.g. post, story, reel, igtv
+	Type string `json:"type,omitempty"`
+	// Capture or publish time as unix timestamp in seconds
+	Timestamp int64     `json:"timestamp,omitempty"`
+	Location  *Location `json:"location,omitempty"`
+	// Camera or phone the media was captured with
+	Device string `json:"device,omitempty"`
+}
+
+// Path returns sidecar path for media path
+func Path(mediaPath string) string {
+	return mediaPath + Ext
+}
+
+// IsSidecar reports whether p looks like a sidecar of a media file
+func IsSidecar(p string) bool {
+	name := strings.TrimSuffix(p, Ext)
+	return name != p && strings.Contains(name[strings.LastIndex(name, "/")+1:], ".")
+}
+
+// Decode parses sidecar file content
+func Decode(data []byte) (*Metadata, error) {
+	m := &Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Write stores metadata as sidecar of media file on local disk
+func Write(mediaPath string, m Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(mediaPath), append(data, '\n'), 0644)
+}
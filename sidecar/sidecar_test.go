@@ -0,0 +1,55 @@
+package sidecar
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsSidecar(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"kif/2024/post_1_0.jpg.json", true},
+		{"post_1_0.mp4.json", true},
+		{"kif/2024/albums.json", false},
+		{"kif/2024.d/albums.json", false},
+		{"kif/2024/post_1_0.jpg", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSidecar(tt.path); got != tt.expected {
+			t.Errorf("IsSidecar(%q) = %v, want %v", tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestWriteDecode(t *testing.T) {
+	media := filepath.Join(t.TempDir(), "post_1700000000_0.jpg")
+	in := Metadata{
+		Caption:   "café",
+		SourceURI: "media/posts/202311/1.jpg",
+		Type:      "post",
+		Timestamp: 1700000000,
+		Location:  &Location{Latitude: 1, Longitude: 2},
+		Device:    "Apple iPhone",
+	}
+
+	if err := Write(media, in); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(media + Ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Caption != in.Caption || out.Timestamp != in.Timestamp || out.Location == nil || *out.Location != *in.Location {
+		t.Errorf("Decode(Write(m)) = %+v, want %+v", out, in)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// storageWriter changes media in the storage backend. The gallery only
+// reads storage through fs.FS, writes go through the backend that owns it.
+type storageWriter interface {
+	// writeFile creates or replaces file p with content of r
+	writeFile(p string, r io.Reader) error
+	// rename moves file p to dst, replacing file at dst
+	rename(p string, dst string) error
+	// remove deletes file p
+	remove(p string) error
+}
+
+// localWriter writes into the local assets folder
+type localWriter struct {
+	root string
+}
+
+func (s localWriter) writeFile(p string, r io.Reader) error {
+	dst := filepath.Join(s.root, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	// Written under temporary name first so a failed write does not leave
+	// partial media in the gallery
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s localWriter) rename(p string, dst string) error {
+	to := filepath.Join(s.root, filepath.FromSlash(dst))
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(s.root, filepath.FromSlash(p)), to); err != nil {
+		return err
+	}
+	s.removeEmptyDirs(path.Dir(p))
+	return nil
+}
+
+func (s localWriter) remove(p string) error {
+	if err := os.Remove(filepath.Join(s.root, filepath.FromSlash(p))); err != nil {
+		return err
+	}
+	s.removeEmptyDirs(path.Dir(p))
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents that are left empty, the same
+// way S3 has no folders without objects in them
+func (s localWriter) removeEmptyDirs(dir string) {
+	for dir != "." && dir != "/" {
+		if os.Remove(filepath.Join(s.root, filepath.FromSlash(dir))) != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// s3Listing is the in-memory listing of the bucket. Requests read it while
+// uploads, moves and refresh change it, so every access takes the lock.
+type s3Listing struct {
+	mu    sync.RWMutex
+	files fstest.MapFS
+	sizes map[string]int64
+}
+
+func newS3Listing() *s3Listing {
+	return &s3Listing{files: fstest.MapFS{}, sizes: make(map[string]int64)}
+}
+
+// Open implements fs.FS. MapFS reads everything it needs from the map while
+// opening, the returned file does not touch the map again.
+func (l *s3Listing) Open(name string) (fs.File, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.files.Open(name)
+}
+
+func (l *s3Listing) size(p string) int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sizes[p]
+}
+
+func (l *s3Listing) set(p string, modTime time.Time, size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files[p] = &fstest.MapFile{ModTime: modTime}
+	l.sizes[p] = size
+}
+
+// move lists object p under dst with its size
+func (l *s3Listing) move(p string, dst string, modTime time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files[dst] = &fstest.MapFile{ModTime: modTime}
+	l.sizes[dst] = l.sizes[p]
+}
+
+func (l *s3Listing) remove(p string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.files, p)
+	delete(l.sizes, p)
+}
+
+// replace swaps the listing for objects listed from the bucket
+func (l *s3Listing) replace(objects []s3Object) {
+	files := fstest.MapFS{}
+	sizes := make(map[string]int64)
+	for _, obj := range objects {
+		if obj.Name == "" {
+			continue
+		}
+		files[obj.Name] = &fstest.MapFile{ModTime: obj.LastModified}
+		sizes[obj.Name] = obj.Size
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = files
+	l.sizes = sizes
+}
+
+// s3Writer changes objects in the bucket and keeps the in-memory listing up
+// to date, so changes show up without listing the whole bucket again
+type s3Writer struct {
+	svc      *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+	// Folder of the bucket the gallery is in, empty for the bucket root
+	root string
+	// Folder of the bucket deleted media is moved to, empty for the .trash
+	// folder of the gallery. Objects of the gallery folder are public.
+	trashRoot string
+	listing   *s3Listing
+}
+
+func (s s3Writer) key(p string) string {
+	if s.trashRoot != "" && isTrashPath(p) {
+		return s.trashRoot + strings.TrimPrefix(p, trashFolder)
+	}
+	if s.root == "" {
+		return p
+	}
+	return s.root + "/" + p
+}
+
+// writeFile uploads r with S3 multipart upload, large files are sent in parts
+// without being read into memory
+func (s s3Writer) writeFile(p string, r io.Reader) error {
+	cr := &countingReader{r: r}
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(p)),
+		Body:   cr,
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %w", p, err)
+	}
+
+	s.listing.set(p, time.Now(), cr.n)
+
+	return nil
+}
+
+// rename copies object to the new key and deletes the old one, S3 can not move objects
+func (s s3Writer) rename(p string, dst string) error {
+	// Copy source is bucket and key, URL encoded
+	segments := strings.Split(s.key(p), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	_, err := s.svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(s.key(dst)),
+		CopySource: aws.String(s.bucket + "/" + strings.Join(segments, "/")),
+	})
+	if err != nil {
+		return fmt.Errorf("error copying %s to %s: %w", p, dst, err)
+	}
+
+	s.listing.move(p, dst, time.Now())
+
+	if err := s.remove(p); err != nil {
+		// Delete the copy so the object is only at p, as before the rename
+		if rollbackErr := s.remove(dst); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s s3Writer) remove(p string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(p)),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting %s: %w", p, err)
+	}
+
+	s.listing.remove(p)
+
+	return nil
+}
+
+// readHead downloads the first n bytes of object p with a ranged GET
+func (s s3Writer) readHead(p string, n int64) ([]byte, error) {
+	result, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(p)),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+	return io.ReadAll(io.LimitReader(result.Body, n))
+}
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
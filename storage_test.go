@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Run with -race, uploads, moves and refresh change the listing while
+// requests browse it
+func TestS3ListingConcurrent(t *testing.T) {
+	listing := newS3Listing()
+	listing.replace([]s3Object{
+		{Name: "kif/2023/post_1700000000_0.jpg", Size: 10},
+		{Name: "snay/post_1700000002_0.jpg", Size: 20},
+	})
+
+	var wg sync.WaitGroup
+	var started sync.WaitGroup
+	stop := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				fs.WalkDir(listing, ".", func(p string, d fs.DirEntry, err error) error {
+					if err == nil && !d.IsDir() {
+						listing.size(p)
+					}
+					return nil
+				})
+				fs.Stat(listing, "kif/2023/post_1700000000_0.jpg")
+				fs.ReadDir(listing, "kif")
+			}
+		}()
+	}
+
+	started.Wait()
+	for i := 0; i < 1000; i++ {
+		p := fmt.Sprintf("kif/2024/post_%d_0.jpg", 1700000000+i)
+		listing.set(p, time.Now(), int64(i))
+		listing.move(p, ".trash/1/"+p, time.Now())
+		listing.remove(p)
+		if i%50 == 0 {
+			listing.replace([]s3Object{{Name: "kif/2023/post_1700000000_0.jpg", Size: 10}})
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if _, err := fs.Stat(listing, ".trash/1/kif/2024/post_1700000999_0.jpg"); err != nil {
+		t.Errorf("expected moved object to be listed: %v", err)
+	}
+	if size := listing.size(".trash/1/kif/2024/post_1700000999_0.jpg"); size != 999 {
+		t.Errorf("expected moved object to keep its size, got %d", size)
+	}
+	if _, err := fs.Stat(listing, "kif/2024/post_1700000999_0.jpg"); err == nil {
+		t.Error("expected removed object not to be listed")
+	}
+}
+
+func TestS3WriterKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		root      string
+		trashRoot string
+		path      string
+		expected  string
+	}{
+		{"bucket root", "", "", "kif/2023/post_1700000000_0.jpg", "kif/2023/post_1700000000_0.jpg"},
+		{"gallery folder", "gallery", "", "kif/2023/post_1700000000_0.jpg", "gallery/kif/2023/post_1700000000_0.jpg"},
+		{"trash in gallery folder", "gallery", "", ".trash/1/kif/post_1700000000_0.jpg", "gallery/.trash/1/kif/post_1700000000_0.jpg"},
+		{"separate trash folder", "gallery", "gallery-trash", ".trash/1/kif/post_1700000000_0.jpg", "gallery-trash/1/kif/post_1700000000_0.jpg"},
+		{"media with separate trash folder", "gallery", "gallery-trash", "kif/.trash/post_1700000000_0.jpg", "gallery/kif/.trash/post_1700000000_0.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := s3Writer{root: tt.root, trashRoot: tt.trashRoot}
+			if got := s.key(tt.path); got != tt.expected {
+				t.Errorf("expected key %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// newTestS3Writer returns writer to a fake bucket where deleting keys in
+// failDeletes fails. Objects of the bucket are tracked in the returned map.
+func newTestS3Writer(t *testing.T, objects map[string]bool, failDeletes map[string]bool) s3Writer {
+	t.Helper()
+
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch {
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+			objects[key] = true
+			fmt.Fprint(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+		case r.Method == http.MethodDelete && failDeletes[key]:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listing := newS3Listing()
+	objs := []s3Object{}
+	for key := range objects {
+		objs = append(objs, s3Object{Name: key, Size: 10})
+	}
+	listing.replace(objs)
+
+	return s3Writer{svc: s3.New(sess), bucket: "bucket", listing: listing}
+}
+
+func TestS3WriterRename(t *testing.T) {
+	tests := []struct {
+		name        string
+		failDeletes map[string]bool
+		expectError bool
+		expected    []string
+	}{
+		{"moved", map[string]bool{}, false, []string{"kif/2024/post_1700000000_0.jpg"}},
+		{"source not deleted", map[string]bool{"kif/2023/post_1700000000_0.jpg": true}, true, []string{"kif/2023/post_1700000000_0.jpg"}},
+		{"copy not deleted", map[string]bool{"kif/2023/post_1700000000_0.jpg": true, "kif/2024/post_1700000000_0.jpg": true}, true, []string{"kif/2023/post_1700000000_0.jpg", "kif/2024/post_1700000000_0.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := map[string]bool{"kif/2023/post_1700000000_0.jpg": true}
+			s := newTestS3Writer(t, objects, tt.failDeletes)
+
+			err := s.rename("kif/2023/post_1700000000_0.jpg", "kif/2024/post_1700000000_0.jpg")
+			if (err != nil) != tt.expectError {
+				t.Fatalf("rename returned %v", err)
+			}
+
+			// Listing has to match the bucket whatever failed
+			listed := []string{}
+			if err := fs.WalkDir(s.listing, ".", func(p string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					listed = append(listed, p)
+				}
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			inBucket := slices.Sorted(maps.Keys(objects))
+			if !reflect.DeepEqual(listed, tt.expected) || !reflect.DeepEqual(inBucket, tt.expected) {
+				t.Errorf("expected %v, listed %v, in bucket %v", tt.expected, listed, inBucket)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// tarBlockSize is the size tar headers and file data are padded to
+const tarBlockSize = 512
+
+// countingWriter counts written bytes without keeping them
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// tarHeader returns header of archive file. Files without modification time
+// get Unix epoch so the header has the same size as written by tar.Writer.
+func tarHeader(f zipFile) *tar.Header {
+	modTime := f.modTime
+	if modTime.IsZero() {
+		modTime = time.Unix(0, 0)
+	}
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     f.Name,
+		Mode:     0o644,
+		Size:     f.size,
+		ModTime:  modTime,
+	}
+}
+
+// tarEntriesSize computes the exact byte size of uncompressed tar archive
+// without reading file contents. Every file takes a 512 byte header, plus
+// PAX records for long or non-ASCII names and files over 8 GB, and its data
+// padded to 512 bytes. The archive ends with two zero blocks.
+func tarEntriesSize(files []zipFile) (int64, error) {
+	var total int64
+	for _, f := range files {
+		// Header is measured by writing it, so it follows archive/tar choice of format
+		cw := &countingWriter{}
+		if err := tar.NewWriter(cw).WriteHeader(tarHeader(f)); err != nil {
+			return 0, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		total += cw.n
+		total += (f.size + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+	}
+	return total + 2*tarBlockSize, nil
+}
+
+// writeTar streams files as tar archive, compressed with gzip if compress is set.
+// Uncompressed archive has exact Content-Length.
+func writeTar(w http.ResponseWriter, r *http.Request, archiveName string, files []zipFile, readFile readFileFunc, compress bool) error {
+	fileName := archiveName + ".tar"
+	if compress {
+		fileName += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		size, err := tarEntriesSize(files)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if compress {
+		// Media files are compressed already, it is not worth spending time on them
+		gz, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+		out = gz
+	}
+
+	tw := tar.NewWriter(out)
+	fetcher := newZipPrefetcher(r.Context(), files, readFile, zipPrefetch, zipPrefetchBytes)
+	for i, f := range files {
+		data := f.data
+		if data == nil {
+			var err error
+			if fetcher != nil {
+				data, err = fetcher.get(i)
+			} else {
+				data, err = readFile(f.Path)
+			}
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", f.Path, err)
+			}
+		}
+		// Content-Length and the header are computed from the size in storage listing
+		if int64(len(data)) != f.size {
+			return fmt.Errorf("size of %s changed from %d to %d bytes", f.Path, f.size, len(data))
+		}
+
+		if err := tw.WriteHeader(tarHeader(f)); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
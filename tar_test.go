@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// readTar returns names and contents of files in tar archive
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+	return files
+}
+
+func TestTarEntriesSize(t *testing.T) {
+	mockFs := newTestZipFS()
+	longName := strings.Repeat("folder/", 30) + "post_1700000000_0.jpg"
+	mockFs[longName] = &fstest.MapFile{Data: bytes.Repeat([]byte("x"), 1500)}
+	mockFs["kif/2023/no_modtime.jpg"] = &fstest.MapFile{Data: []byte("s3")}
+
+	entries := append(testZipEntries(),
+		zipEntry{Name: longName, Path: longName},
+		zipEntry{Name: "no_modtime.jpg", Path: "kif/2023/no_modtime.jpg"},
+	)
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	files, _ := statZipFiles(entries, mockFs, sizeFn)
+
+	expected, err := tarEntriesSize(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	if err := writeTar(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023?format=tar", nil), "2023", files, readFile, false); err != nil {
+		t.Fatal(err)
+	}
+	if int64(rr.Body.Len()) != expected {
+		t.Errorf("calculated %d but actual tar is %d bytes", expected, rr.Body.Len())
+	}
+	if rr.Header().Get("Content-Length") != fmt.Sprintf("%d", rr.Body.Len()) {
+		t.Errorf("Content-Length %s does not match body size %d", rr.Header().Get("Content-Length"), rr.Body.Len())
+	}
+
+	contents := readTar(t, rr.Body)
+	for _, e := range entries {
+		if contents[e.Name] != string(mockFs[e.Path].Data) {
+			t.Errorf("unexpected content of %s", e.Name)
+		}
+	}
+}
+
+func TestDownloadFormat(t *testing.T) {
+	mockFs := newTestZipFS()
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	handler := makeDownloadHandler(mockFs, readFile, sizeFn, nil, nil)
+	expected := []string{"post_1700000000_0.jpg", "post_1700000000_1.jpg", "reel_1700000001_0.mp4"}
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023?format=tar", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("expected tar archive, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Header().Get("Content-Disposition"), `"2023.tar"`) {
+		t.Errorf("unexpected Content-Disposition %q", rr.Header().Get("Content-Disposition"))
+	}
+	if files := readTar(t, rr.Body); len(files) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023?format=tar.gz", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("expected gzipped tar archive, got %d", rr.Code)
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readTar(t, gz)
+	for _, name := range expected {
+		if files[name] != string(mockFs["kif/2023/"+name].Data) {
+			t.Errorf("unexpected content of %s", name)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023?format=rar", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unsupported format to be rejected, got %d", rr.Code)
+	}
+
+	// Selection takes format together with the paths
+	form := url.Values{"path": {"kif/2023/post_1700000000_0.jpg", "snay/2023/пост_1700000002_0.jpg"}, "format": {"tar"}}
+	req := httptest.NewRequest("POST", "/gallery/download", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	makeSelectionDownloadHandler(mockFs, readFile, sizeFn)(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("expected tar archive of selection, got %d", rr.Code)
+	}
+	if files := readTar(t, rr.Body); files["пост_1700000002_0.jpg"] != "snay" {
+		t.Errorf("unexpected selection archive %v", files)
+	}
+}
+
+func TestWriteTar_MissingFile(t *testing.T) {
+	mockFs := newTestZipFS()
+	var mu sync.Mutex
+	read := []string{}
+	readFile := func(p string) ([]byte, error) {
+		mu.Lock()
+		read = append(read, p)
+		mu.Unlock()
+		if p == "" {
+			return nil, fmt.Errorf("unexpected read of storage root")
+		}
+		return fs.ReadFile(mockFs, p)
+	}
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	entries := append(testZipEntries(), zipEntry{Name: "deleted.jpg", Path: "kif/2023/deleted.jpg"})
+
+	zipErrorManifest = true
+	defer func() { zipErrorManifest = false }()
+
+	rr := httptest.NewRecorder()
+	writeArchive(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023?format=tar", nil), "2023", entries, mockFs, readFile, sizeFn)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected tar archive with error manifest, got %d", rr.Code)
+	}
+
+	files := readTar(t, rr.Body)
+	if !strings.HasPrefix(files[zipErrorsName], "kif/2023/deleted.jpg: ") {
+		t.Errorf("expected missing file in error manifest, got %q", files[zipErrorsName])
+	}
+	// Every file read ahead was written, no reads are left in flight
+	mu.Lock()
+	defer mu.Unlock()
+	if len(read) != len(entries)-1 || slices.Contains(read, "") {
+		t.Errorf("expected only storage files to be read, got %q", read)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed web/gallery/timeline.css
+var timelineCss []byte
+
+// Timeline grouping granularity
+const (
+	GroupByDay   = "day"
+	GroupByMonth = "month"
+	GroupByYear  = "year"
+)
+
+// TimelineGroup is a block of media captured within the same day, month or year
+type TimelineGroup struct {
+	// Anchor id of the group e.g. d2024-05-17
+	ID    string
+	Label string
+	// Set when the group starts new year or month so the template can render headings
+	YearID   string
+	Year     int
+	MonthID  string
+	Month    string
+	NewYear  bool
+	NewMonth bool
+	Media    []Media
+}
+
+// TimelineNavYear is an entry of the jump-to-date sidebar
+type TimelineNavYear struct {
+	ID     string
+	Year   int
+	Months []TimelineNavMonth
+}
+
+type TimelineNavMonth struct {
+	ID    string
+	Label string
+}
+
+type TimelinePage struct {
+	Title       string
+	Groups      []TimelineGroup
+	Nav         []TimelineNavYear
+	GroupBy     string
+	URLParam    string
+	BackLink    string
+	Styles      template.CSS
+	JS          template.JS
+	GridSize    string
+	CurrentPath string
+	URLPrefix   string
+}
+
+func yearID(t time.Time) string {
+	return fmt.Sprintf("y%d", t.Year())
+}
+
+func monthID(t time.Time) string {
+	return fmt.Sprintf("m%d-%02d", t.Year(), t.Month())
+}
+
+// groupTimeline splits media items (expected newest first) into consecutive
+// groups by capture day, month or year in local time
+func groupTimeline(items []MediaItem, groupBy string) []TimelineGroup {
+	groups := []TimelineGroup{}
+
+	var key, lastYear, lastMonth string
+	for _, it := range items {
+		t := it.Time.Local()
+
+		var k, label string
+		switch groupBy {
+		case GroupByYear:
+			k, label = yearID(t), fmt.Sprintf("%d", t.Year())
+		case GroupByMonth:
+			k, label = monthID(t), t.Format("January 2006")
+		default:
+			k, label = fmt.Sprintf("d%s", t.Format("2006-01-02")), t.Format("Monday, 2 January")
+		}
+
+		if k != key || len(groups) == 0 {
+			g := TimelineGroup{
+				ID:      k,
+				Label:   label,
+				YearID:  yearID(t),
+				Year:    t.Year(),
+				MonthID: monthID(t),
+				Month:   t.Format("January"),
+			}
+			g.NewYear = g.YearID != lastYear
+			g.NewMonth = g.MonthID != lastMonth
+			lastYear, lastMonth = g.YearID, g.MonthID
+
+			groups = append(groups, g)
+			key = k
+		}
+
+		g := &groups[len(groups)-1]
+		g.Media = append(g.Media, makeIndexedMedia(it))
+	}
+
+	return groups
+}
+
+// timelineNav builds jump-to-date sidebar entries from timeline groups
+func timelineNav(groups []TimelineGroup, groupBy string) []TimelineNavYear {
+	nav := []TimelineNavYear{}
+
+	for _, g := range groups {
+		if g.NewYear {
+			nav = append(nav, TimelineNavYear{ID: g.YearID, Year: g.Year})
+		}
+		if g.NewMonth && groupBy != GroupByYear {
+			y := &nav[len(nav)-1]
+			y.Months = append(y.Months, TimelineNavMonth{ID: g.MonthID, Label: g.Month})
+		}
+	}
+
+	return nav
+}
+
+// makeTimelineHandler renders all media under a folder grouped by capture date.
+// The folder is taken from the URL path after /timeline/ and the
+// granularity from "group" query parameter (day, month or year).
+func makeTimelineHandler(index *mediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		folder := strings.TrimPrefix(r.URL.Path, urlPrefix+"/timeline")
+		folder = strings.Trim(folder, "/")
+
+		groupBy := r.URL.Query().Get("group")
+		switch groupBy {
+		case GroupByDay, GroupByMonth, GroupByYear:
+		default:
+			groupBy = GroupByDay
+		}
+
+		gridSize := r.URL.Query().Get("grid")
+		if gridSize == "" {
+			gridSize = "200px"
+		}
+
+		if !canBrowse(r, folder) {
+			denyAccess(w, r)
+			return
+		}
+
+		groups := groupTimeline(visibleItems(r, folder, index.all(folder)), groupBy)
+
+		title := "Timeline"
+		if folder != "" {
+			title = "Timeline: " + folder
+		}
+
+		page := TimelinePage{
+			Title:       title,
+			Groups:      groups,
+			Nav:         timelineNav(groups, groupBy),
+			GroupBy:     groupBy,
+			URLParam:    "?" + r.URL.RawQuery,
+			BackLink:    path.Join(urlPrefix, folder),
+			Styles:      template.CSS(append(append(galleryCss, timelineCss...), globalCss...)),
+			JS:          template.JS(append(globalJs, galleryJs...)),
+			GridSize:    gridSize,
+			CurrentPath: folder,
+			URLPrefix:   urlPrefix,
+		}
+
+		err := tmpl.ExecuteTemplate(w, "timeline.html", page)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+}
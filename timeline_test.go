@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestGroupTimeline(t *testing.T) {
+	items := []MediaItem{
+		{Path: "a/story_3_0.jpg", Time: time.Date(2024, 5, 17, 20, 0, 0, 0, time.Local)},
+		{Path: "a/story_2_0.jpg", Time: time.Date(2024, 5, 17, 8, 0, 0, 0, time.Local)},
+		{Path: "a/story_1_0.jpg", Time: time.Date(2024, 4, 1, 8, 0, 0, 0, time.Local)},
+		{Path: "a/story_0_0.jpg", Time: time.Date(2023, 12, 31, 8, 0, 0, 0, time.Local)},
+	}
+
+	tests := []struct {
+		groupBy   string
+		ids       []string
+		navYears  int
+		navMonths int
+	}{
+		{GroupByDay, []string{"d2024-05-17", "d2024-04-01", "d2023-12-31"}, 2, 3},
+		{GroupByMonth, []string{"m2024-05", "m2024-04", "m2023-12"}, 2, 3},
+		{GroupByYear, []string{"y2024", "y2023"}, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			groups := groupTimeline(items, tt.groupBy)
+			if len(groups) != len(tt.ids) {
+				t.Fatalf("got %d groups, want %d", len(groups), len(tt.ids))
+			}
+			for i, id := range tt.ids {
+				if groups[i].ID != id {
+					t.Errorf("group %d id = %s, want %s", i, groups[i].ID, id)
+				}
+			}
+			if tt.groupBy == GroupByDay && len(groups[0].Media) != 2 {
+				t.Errorf("first day has %d media, want 2", len(groups[0].Media))
+			}
+
+			nav := timelineNav(groups, tt.groupBy)
+			months := 0
+			for _, y := range nav {
+				months += len(y.Months)
+			}
+			if len(nav) != tt.navYears || months != tt.navMonths {
+				t.Errorf("nav has %d years and %d months, want %d and %d", len(nav), months, tt.navYears, tt.navMonths)
+			}
+		})
+	}
+}
+
+func TestTimelineHandler(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"kif/2023/story_1700000000_0.jpg": &fstest.MapFile{},
+		"snay/2021/post_1600000000_0.jpg": &fstest.MapFile{},
+	}
+	index := newMediaIndex(mockFs, func(string) ([]byte, error) { return nil, nil }, func(string) int64 { return 0 })
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", urlPrefix+"/timeline/kif?group=year", nil)
+	makeTimelineHandler(index)(w, r)
+
+	body := w.Body.String()
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if !strings.Contains(body, "story_1700000000_0.jpg") {
+		t.Error("timeline page does not contain media from the folder")
+	}
+	if strings.Contains(body, "post_1600000000_0.jpg") {
+		t.Error("timeline page contains media from another folder")
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kif11/gallery2/sidecar"
+)
+
+//go:embed web/gallery/trash.js
+var trashJs []byte
+
+//go:embed web/gallery/trash.css
+var trashCss []byte
+
+// Hidden storage folder deleted media is kept in until it is purged. Every
+// delete gets its own folder named by deletion time in nanoseconds, files
+// keep their original path under it: .trash/<id>/kif/2023/post_1700000000_0.jpg
+const trashFolder = ".trash"
+
+// isTrashPath reports whether p is in the trash
+func isTrashPath(p string) bool {
+	top, _, _ := strings.Cut(strings.Trim(p, "/"), "/")
+	return top == trashFolder
+}
+
+// TrashItem is media or folder deleted at once
+type TrashItem struct {
+	ID string `json:"id"`
+	// Original path, for a deleted folder the deepest folder that holds all of its files
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+	// When the item is purged, zero if the trash is kept until purged by hand
+	Expires time.Time `json:"expires,omitempty"`
+	Files   int       `json:"files"`
+	Size    int64     `json:"size"`
+	// Trash path of an image to preview the item with
+	Preview string `json:"-"`
+	// Original paths of the files
+	files []string
+}
+
+// SizeText returns size of the item for humans
+func (it TrashItem) SizeText() string {
+	return formatSize(it.Size)
+}
+
+// PreviewURL returns URL the preview image is served from to admins
+func (it TrashItem) PreviewURL() string {
+	return urlPrefix + "/api/trash/file/" + strings.TrimPrefix(it.Preview, trashFolder+"/")
+}
+
+// trashPath returns path of file p deleted as part of item id
+func trashPath(id string, p string) string {
+	return path.Join(trashFolder, id, p)
+}
+
+// trashItemPath returns what was deleted from original paths of its files
+func trashItemPath(files []string) string {
+	sidecars := make(map[string]bool)
+	for _, f := range files {
+		sidecars[sidecar.Path(f)] = true
+	}
+	media := []string{}
+	for _, f := range files {
+		// Sidecar files go together with their media
+		if sidecars[f] {
+			continue
+		}
+		media = append(media, f)
+	}
+	if len(media) == 1 {
+		return media[0]
+	}
+
+	dir := path.Dir(files[0])
+	for _, f := range files {
+		for dir != "." && !strings.HasPrefix(f, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// moveToTrash moves files of p into a new trash item. Caller must hold the lock.
+func (m *mediaManager) moveToTrash(p string, files []string) (string, error) {
+	now := time.Now()
+	id := strconv.FormatInt(now.UnixNano(), 10)
+	for {
+		if _, err := fs.Stat(m.fSys, path.Join(trashFolder, id)); err != nil {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+		id = strconv.FormatInt(now.UnixNano(), 10)
+	}
+
+	moved := make(map[string]string)
+	defer m.finish(moved)
+
+	for _, f := range files {
+		if err := m.storage.rename(f, trashPath(id, f)); err != nil {
+			m.index.reindex(f)
+			return "", err
+		}
+		moved[f] = trashPath(id, f)
+	}
+	fmt.Printf("[+] Moved %s to trash\n", p)
+
+	return id, nil
+}
+
+// trash returns deleted items, most recently deleted first
+func (m *mediaManager) trash() ([]TrashItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.trashItems()
+}
+
+// trashItems reads deleted items from the storage. Caller must hold the lock.
+func (m *mediaManager) trashItems() ([]TrashItem, error) {
+	byID := make(map[string]*TrashItem)
+
+	err := fs.WalkDir(m.fSys, trashFolder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		id, original, ok := strings.Cut(strings.TrimPrefix(p, trashFolder+"/"), "/")
+		nanos, err := strconv.ParseInt(id, 10, 64)
+		if !ok || err != nil {
+			return nil
+		}
+
+		it := byID[id]
+		if it == nil {
+			it = &TrashItem{ID: id, Deleted: time.Unix(0, nanos)}
+			if m.trashRetention > 0 {
+				it.Expires = it.Deleted.Add(m.trashRetention)
+			}
+			byID[id] = it
+		}
+		it.files = append(it.files, original)
+		it.Files++
+		if info, err := d.Info(); err == nil {
+			it.Size += info.Size()
+		}
+		if it.Preview == "" && getMediaType(path.Ext(p)) == Image {
+			it.Preview = p
+		}
+
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := []TrashItem{}
+	for _, it := range byID {
+		it.Path = trashItemPath(it.files)
+		items = append(items, *it)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.After(items[j].Deleted)
+	})
+
+	return items, nil
+}
+
+// trashItem returns deleted item id. Caller must hold the lock.
+func (m *mediaManager) trashItem(id string) (TrashItem, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return TrashItem{}, fmt.Errorf("%w: invalid trash item %q", errInvalidPath, id)
+	}
+
+	items, err := m.trashItems()
+	if err != nil {
+		return TrashItem{}, err
+	}
+	for _, it := range items {
+		if it.ID == id {
+			return it, nil
+		}
+	}
+	return TrashItem{}, fmt.Errorf("trash item %s %w", id, fs.ErrNotExist)
+}
+
+// restore moves files of deleted item id back to their original paths
+func (m *mediaManager) restore(id string) (TrashItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, err := m.trashItem(id)
+	if err != nil {
+		return it, err
+	}
+
+	// Nothing is restored if any of the files would replace another one
+	for _, f := range it.files {
+		if _, err := fs.Stat(m.fSys, f); err == nil {
+			return it, fmt.Errorf("%s %w", f, fs.ErrExist)
+		}
+	}
+
+	moved := make(map[string]string)
+	defer m.finish(moved)
+
+	for _, f := range it.files {
+		if err := m.storage.rename(trashPath(id, f), f); err != nil {
+			return it, err
+		}
+		moved[trashPath(id, f)] = f
+	}
+	fmt.Printf("[+] Restored %s from trash\n", it.Path)
+
+	return it, nil
+}
+
+// purge permanently deletes item id from the trash
+func (m *mediaManager) purge(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, err := m.trashItem(id)
+	if err != nil {
+		return err
+	}
+	return m.purgeItem(it)
+}
+
+// purgeItem deletes files of trash item. Caller must hold the lock.
+func (m *mediaManager) purgeItem(it TrashItem) error {
+	removed := make(map[string]string)
+	defer m.finish(removed)
+
+	for _, f := range it.files {
+		if err := m.storage.remove(trashPath(it.ID, f)); err != nil {
+			return err
+		}
+		removed[trashPath(it.ID, f)] = ""
+	}
+	fmt.Printf("[+] Purged %s from trash\n", it.Path)
+
+	return nil
+}
+
+// purgeExpired permanently deletes items deleted longer than the retention
+// ago, or every item if all is set
+func (m *mediaManager) purgeExpired(now time.Time, all bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.trashItems()
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		if !all && (it.Expires.IsZero() || it.Expires.After(now)) {
+			continue
+		}
+		if err := m.purgeItem(it); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serveTrashFile writes deleted file p, its path in the trash. On S3 the
+// trash can be outside of the public gallery folder, deleted media is only
+// served to admins this way.
+func (m *mediaManager) serveTrashFile(w http.ResponseWriter, r *http.Request, p string) {
+	p = path.Join(trashFolder, p)
+	// Joined path is cleaned, ".." could point out of the trash
+	if !isTrashPath(p) || p == trashFolder {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	info, err := fs.Stat(m.fSys, p)
+	if err != nil || info.IsDir() {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	data, err := m.readFile(p)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.ServeContent(w, r, path.Base(p), info.ModTime(), bytes.NewReader(data))
+}
+
+// makeTrashApiHandler manages deleted media under /api/trash:
+//   - GET /api/trash lists deleted items
+//   - GET /api/trash/file/<id>/<path> returns deleted file
+//   - POST /api/trash/restore with "id" form value restores item
+//   - POST /api/trash/purge with "id" form value permanently deletes item
+//   - POST /api/trash/empty permanently deletes every item
+func makeTrashApiHandler(media *mediaManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		action := strings.Trim(strings.TrimPrefix(r.URL.Path, urlPrefix+"/api/trash"), "/")
+
+		if action == "" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+				return
+			}
+			items, err := media.trash()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJSON(w, items)
+			return
+		}
+
+		if p, ok := strings.CutPrefix(action, "file/"); ok {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+				return
+			}
+			media.serveTrashFile(w, r, p)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+			return
+		}
+
+		id := r.FormValue("id")
+		switch action {
+		case "restore":
+			it, err := media.restore(id)
+			if err != nil {
+				writeMediaError(w, err)
+				return
+			}
+			writeJSON(w, map[string]any{"id": id, "path": it.Path})
+
+		case "purge":
+			if err := media.purge(id); err != nil {
+				writeMediaError(w, err)
+				return
+			}
+			writeJSON(w, map[string]any{"id": id, "purged": true})
+
+		case "empty":
+			if err := media.purgeExpired(time.Now(), true); err != nil {
+				writeMediaError(w, err)
+				return
+			}
+			writeJSON(w, map[string]any{"purged": true})
+
+		default:
+			writeError(w, http.StatusNotFound, "Not Found")
+		}
+	}
+}
+
+type TrashPage struct {
+	Title     string
+	Items     []TrashItem
+	Retention string
+	BackLink  string
+	URLPrefix string
+	Styles    template.CSS
+	JS        template.JS
+}
+
+// makeTrashPageHandler renders deleted media with restore and purge buttons
+func makeTrashPageHandler(media *mediaManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := media.trash()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		retention := "until purged"
+		if media.trashRetention > 0 {
+			retention = fmt.Sprintf("%d days", int(media.trashRetention.Hours()/24))
+		}
+
+		page := TrashPage{
+			Title:     "Trash",
+			Items:     items,
+			Retention: retention,
+			BackLink:  urlPrefix,
+			URLPrefix: urlPrefix,
+			Styles:    template.CSS(append(trashCss, globalCss...)),
+			JS:        template.JS(trashJs),
+		}
+
+		err = tmpl.ExecuteTemplate(w, "trash.html", page)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+	}
+}
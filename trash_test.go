@@ -0,0 +1,264 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kif11/gallery2/sidecar"
+)
+
+func postTrash(handler http.HandlerFunc, action string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", urlPrefix+"/api/trash/"+action, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestTrashRestore(t *testing.T) {
+	media, root := newTestMediaManager(t)
+	handler := makeTrashApiHandler(media)
+
+	id, err := media.remove("kif/2023/post_1700000000_0.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(root, trashPath(id, "kif/2023/post_1700000000_0.jpg.json")) {
+		t.Error("expected sidecar to be moved to the trash together with media")
+	}
+
+	items, err := media.trash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Path != "kif/2023/post_1700000000_0.jpg" || items[0].Files != 2 {
+		t.Fatalf("unexpected trash %+v", items)
+	}
+	if time.Since(items[0].Deleted) > time.Minute {
+		t.Errorf("unexpected deletion time %v", items[0].Deleted)
+	}
+	if got := len(media.index.all("")); got != 3 {
+		t.Errorf("expected deleted media not to be indexed, got %d media", got)
+	}
+
+	rr := postTrash(handler, "restore", url.Values{"id": {id}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected item to be restored, got %d %s", rr.Code, rr.Body.String())
+	}
+	if !fileExists(root, "kif/2023/post_1700000000_0.jpg") || !fileExists(root, "kif/2023/post_1700000000_0.jpg.json") {
+		t.Error("expected media and sidecar at the original path")
+	}
+	if fileExists(root, trashFolder) {
+		t.Error("expected empty trash folder to be removed")
+	}
+	if it, ok := media.index.get("kif/2023/post_1700000000_0.jpg"); !ok || it.Caption != "first" {
+		t.Errorf("expected restored media to be indexed, got %+v", it)
+	}
+	if !media.favorites.has("kif/2023/post_1700000000_0.jpg") {
+		t.Error("expected restored media to stay favorite")
+	}
+
+	if rr := postTrash(handler, "restore", url.Values{"id": {id}}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected restored item to be gone from the trash, got %d", rr.Code)
+	}
+	if rr := postTrash(handler, "restore", url.Values{"id": {"../kif"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid id to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestTrashRestoreConflict(t *testing.T) {
+	media, root := newTestMediaManager(t)
+
+	id, err := media.remove("kif/2023")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "kif", "2023"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "kif", "2023", "post_1700000000_1.jpg"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postTrash(makeTrashApiHandler(media), "restore", url.Values{"id": {id}})
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected restore over existing media to be rejected, got %d", rr.Code)
+	}
+	if !fileExists(root, trashPath(id, "kif/2023/trip/reel_1700000001_0.mp4")) {
+		t.Error("expected rejected restore to leave the trash as it was")
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	media, root := newTestMediaManager(t)
+	handler := makeTrashApiHandler(media)
+
+	folder, _ := media.remove("kif/2023/trip")
+	file, _ := media.remove("kif/2023/post_1700000000_0.jpg")
+
+	if rr := postTrash(handler, "purge", url.Values{"id": {folder}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected item to be purged, got %d %s", rr.Code, rr.Body.String())
+	}
+	if fileExists(root, trashPath(folder, "kif/2023/trip/reel_1700000001_0.mp4")) {
+		t.Error("expected purged media to be deleted")
+	}
+	if items, _ := media.trash(); len(items) != 1 || items[0].ID != file {
+		t.Errorf("expected only the other item to be left, got %+v", items)
+	}
+
+	if rr := postTrash(handler, "empty", url.Values{}); rr.Code != http.StatusOK {
+		t.Fatalf("expected trash to be emptied, got %d", rr.Code)
+	}
+	if fileExists(root, trashFolder) {
+		t.Error("expected trash to be empty")
+	}
+	if media.favorites.has(trashPath(file, "kif/2023/post_1700000000_0.jpg")) {
+		t.Error("expected purged media to be removed from favorites")
+	}
+}
+
+func TestTrashPurgeExpired(t *testing.T) {
+	media, root := newTestMediaManager(t)
+	media.trashRetention = 24 * time.Hour
+
+	id, _ := media.remove("snay/post_1700000002_0.jpg")
+
+	if err := media.purgeExpired(time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(root, trashPath(id, "snay/post_1700000002_0.jpg")) {
+		t.Error("expected recently deleted media to be kept")
+	}
+
+	if err := media.purgeExpired(time.Now().Add(25*time.Hour), false); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(root, trashPath(id, "snay/post_1700000002_0.jpg")) {
+		t.Error("expected expired media to be purged")
+	}
+}
+
+func TestTrashHidden(t *testing.T) {
+	media, _ := newTestMediaManager(t)
+	id, _ := media.remove("snay/post_1700000002_0.jpg")
+
+	entries, err := listFsItems(media.fSys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == trashFolder {
+			t.Error("expected trash not to be listed in the gallery")
+		}
+	}
+
+	p := "/" + trashPath(id, "snay/post_1700000002_0.jpg")
+	tests := []struct {
+		name     string
+		auth     authProvider
+		expected bool
+	}{
+		{"admin", staticAuth{user: adminUser}, true},
+		{"viewer", staticAuth{user: kifUser}, false},
+		{"anonymous", staticAuth{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var read, browse bool
+			handler := authMiddleware(tt.auth, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				read = canRead(r, p)
+				browse = canBrowse(r, trashFolder)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", urlPrefix, nil))
+
+			if read != tt.expected || browse != tt.expected {
+				t.Errorf("expected access %v, got read %v browse %v", tt.expected, read, browse)
+			}
+		})
+	}
+}
+
+func TestTrashItemPath(t *testing.T) {
+	tests := []struct {
+		files    []string
+		expected string
+	}{
+		{[]string{"kif/2023/a.jpg"}, "kif/2023/a.jpg"},
+		{[]string{"kif/2023/a.jpg", sidecar.Path("kif/2023/a.jpg")}, "kif/2023/a.jpg"},
+		{[]string{"kif/2023/a.jpg", sidecar.Path("kif/2023/b.jpg")}, "kif/2023"},
+		{[]string{"kif/2023/a.jpg", "kif/2023/b.jpg"}, "kif/2023"},
+		{[]string{"kif/2023/a.jpg", "kif/2023/trip/b.mp4"}, "kif/2023"},
+		{[]string{"kif/a.jpg", "snay/b.jpg"}, "."},
+	}
+
+	for _, tt := range tests {
+		if got := trashItemPath(tt.files); got != tt.expected {
+			t.Errorf("trashItemPath(%v) = %q, expected %q", tt.files, got, tt.expected)
+		}
+	}
+}
+
+func TestTrashFile(t *testing.T) {
+	media, _ := newTestMediaManager(t)
+	handler := makeTrashApiHandler(media)
+	id, err := media.remove("kif/2023/post_1700000000_0.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"deleted media", id + "/kif/2023/post_1700000000_0.jpg", http.StatusOK, "a"},
+		{"deleted sidecar", id + "/kif/2023/post_1700000000_0.jpg.json", http.StatusOK, `{"caption": "first"}`},
+		{"missing file", id + "/kif/2023/post_1700000000_1.jpg", http.StatusNotFound, ""},
+		{"trash item folder", id, http.StatusNotFound, ""},
+		{"media out of the trash", "../kif/2023/post_1700000000_1.jpg", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", urlPrefix+"/api/trash/file/x", nil)
+			req.URL.Path = urlPrefix + "/api/trash/file/" + tt.path
+			handler(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("expected %d, got %d", tt.expectedCode, rr.Code)
+			}
+			if tt.expectedBody != "" && rr.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestTrashPage(t *testing.T) {
+	media, _ := newTestMediaManager(t)
+	media.trashRetention = 30 * 24 * time.Hour
+	id, err := media.remove("kif/2023/post_1700000000_0.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	makeTrashPageHandler(media)(rr, httptest.NewRequest("GET", urlPrefix+"/trash", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected trash page, got %d %s", rr.Code, rr.Body.String())
+	}
+	preview := urlPrefix + "/api/trash/file/" + id + "/kif/2023/post_1700000000_0.jpg"
+	for _, s := range []string{"kif/2023/post_1700000000_0.jpg", "30 days", "Restore", preview} {
+		if !strings.Contains(rr.Body.String(), s) {
+			t.Errorf("expected trash page to contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed web/gallery/upload.js
+var uploadJs []byte
+
+//go:embed web/gallery/upload.css
+var uploadCss []byte
+
+// Unfinished uploads older than this are removed on start
+const uploadTTL = 24 * time.Hour
+
+// uploadStore receives media uploaded through the gallery. Files sent in
+// chunks are kept on local disk until the last chunk arrives, so an upload
+// interrupted by a lost connection can continue from the last received byte.
+// Complete files are written into the storage backend and added to the index.
+type uploadStore struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	fSys    fs.FS
+	storage storageWriter
+	index   *mediaIndex
+	// Uploads that are receiving data right now
+	active map[string]bool
+}
+
+func newUploadStore(dir string, maxSize int64, fSys fs.FS, storage storageWriter, index *mediaIndex) *uploadStore {
+	return &uploadStore{
+		dir:     dir,
+		maxSize: maxSize,
+		fSys:    fSys,
+		storage: storage,
+		index:   index,
+		active:  make(map[string]bool),
+	}
+}
+
+// load creates the folder for unfinished uploads and removes the ones too old to be resumed
+func (s *uploadStore) load() error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("error creating upload folder %s: %w", s.dir, err)
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("error reading upload folder %s: %w", s.dir, err)
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < uploadTTL {
+			continue
+		}
+		os.Remove(filepath.Join(s.dir, e.Name()))
+	}
+
+	return nil
+}
+
+// uploadTarget returns storage path of file uploaded into folder
+func uploadTarget(folder string, name string) (string, error) {
+	folder = strings.Trim(folder, "/")
+	if folder == "" {
+		folder = "."
+	}
+	if !fs.ValidPath(folder) || isVirtualPath(folder) || isTrashPath(folder) {
+		return "", fmt.Errorf("invalid folder %q", folder)
+	}
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	if getMediaType(path.Ext(name)) == Other {
+		return "", fmt.Errorf("unsupported media type of %q", name)
+	}
+	return path.Join(folder, name), nil
+}
+
+// isVirtualPath reports whether p is in a folder that does not exist in the storage
+func isVirtualPath(p string) bool {
+	top, _, _ := strings.Cut(strings.Trim(p, "/"), "/")
+	return top == albumsFolder || top == favoritesFolder
+}
+
+// shadowedFolders returns folders in the storage root that can not be opened
+// because virtual albums and favorites are served under their names
+func shadowedFolders(fSys fs.FS) []string {
+	shadowed := []string{}
+	for _, name := range []string{albumsFolder, favoritesFolder} {
+		if info, err := fs.Stat(fSys, name); err == nil && info.IsDir() {
+			shadowed = append(shadowed, name)
+		}
+	}
+	return shadowed
+}
+
+// exists reports whether there is a file or folder at p already
+func (s *uploadStore) exists(p string) bool {
+	_, err := fs.Stat(s.fSys, p)
+	return err == nil
+}
+
+// begin marks upload of p as active, it fails if p is being uploaded already
+func (s *uploadStore) begin(p string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active[p] {
+		return false
+	}
+	s.active[p] = true
+	return true
+}
+
+func (s *uploadStore) end(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.active, p)
+}
+
+// partFile returns path of the file chunks of p are collected in. Received
+// chunks are kept per total size, so an abandoned upload is not resumed by
+// another file of the same name.
+func (s *uploadStore) partFile(p string, total int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%d.part", partPrefix(p), total))
+}
+
+func partPrefix(p string) string {
+	sum := sha256.Sum256([]byte(p))
+	return hex.EncodeToString(sum[:16])
+}
+
+// offset returns how many bytes of p of total size were received
+func (s *uploadStore) offset(p string, total int64) int64 {
+	info, err := os.Stat(s.partFile(p, total))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// discardParts removes chunks of p received for other sizes than total,
+// they belong to an abandoned upload of another file
+func (s *uploadStore) discardParts(p string, total int64) {
+	parts, _ := filepath.Glob(filepath.Join(s.dir, partPrefix(p)+"-*.part"))
+	for _, part := range parts {
+		if part != s.partFile(p, total) {
+			os.Remove(part)
+		}
+	}
+}
+
+// writeChunk appends chunk of at most size bytes to the received part of p
+// and returns the new offset. Bytes received before the connection dropped
+// are kept, so the upload can continue from there.
+func (s *uploadStore) writeChunk(p string, total int64, r io.Reader, size int64) (int64, error) {
+	f, err := os.OpenFile(s.partFile(p, total), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = io.Copy(f, io.LimitReader(r, size))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return s.offset(p, total), err
+}
+
+// complete writes fully received p into the storage
+func (s *uploadStore) complete(p string, total int64) error {
+	f, err := os.Open(s.partFile(p, total))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := s.write(p, f); err != nil {
+		return err
+	}
+	return os.Remove(s.partFile(p, total))
+}
+
+// write stores file p and adds it to the index
+func (s *uploadStore) write(p string, r io.Reader) error {
+	if err := s.storage.writeFile(p, r); err != nil {
+		return err
+	}
+	fmt.Printf("[+] Uploaded %s\n", p)
+
+	s.index.reindex(p)
+
+	return nil
+}
+
+var contentRangePattern = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+
+// parseContentRange parses "bytes start-end/total" header of a chunk
+func parseContentRange(h string) (int64, int64, int64, error) {
+	m := contentRangePattern.FindStringSubmatch(h)
+	if m == nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+	}
+	start, _ := strconv.ParseInt(m[1], 10, 64)
+	end, _ := strconv.ParseInt(m[2], 10, 64)
+	total, _ := strconv.ParseInt(m[3], 10, 64)
+	if start > end || end >= total {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+	}
+	return start, end, total, nil
+}
+
+// writeUploadStatus responds with JSON state of the upload
+func writeUploadStatus(w http.ResponseWriter, status int, p string, offset int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, map[string]any{"path": p, "offset": offset})
+}
+
+// makeUploadApiHandler handles uploads under /api/upload:
+//   - POST /api/upload/<folder> with multipart form "file" fields stores every file at once
+//   - PUT /api/upload/<folder>/<name> stores the request body, with Content-Range
+//     header the file is sent in chunks that are collected until the last one
+//   - GET /api/upload/<folder>/<name>?size=<total> returns offset to resume
+//     chunked upload of a file of total size from
+func makeUploadApiHandler(uploads *uploadStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Trim(strings.TrimPrefix(r.URL.Path, urlPrefix+"/api/upload"), "/")
+
+		if r.Method == http.MethodPost {
+			uploadForm(w, r, uploads, p)
+			return
+		}
+
+		target, err := uploadTarget(path.Dir(p), path.Base(p))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			var total int64
+			if size := r.URL.Query().Get("size"); size != "" {
+				total, err = strconv.ParseInt(size, 10, 64)
+				if err != nil || total <= 0 {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid size %q", size))
+					return
+				}
+			}
+			writeUploadStatus(w, http.StatusOK, target, uploads.offset(target, total))
+
+		case http.MethodPut:
+			if uploads.exists(target) {
+				writeError(w, http.StatusConflict, target+" already exists")
+				return
+			}
+			if !uploads.begin(target) {
+				writeError(w, http.StatusConflict, target+" is being uploaded")
+				return
+			}
+			defer uploads.end(target)
+
+			if r.Header.Get("Content-Range") == "" {
+				body := http.MaxBytesReader(w, r.Body, uploads.maxSize)
+				if err := uploads.write(target, body); err != nil {
+					writeUploadError(w, err)
+					return
+				}
+				writeUploadStatus(w, http.StatusCreated, target, 0)
+				return
+			}
+
+			start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if total > uploads.maxSize {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %s", formatSize(uploads.maxSize)))
+				return
+			}
+			// Chunks have to follow each other, the client asks for offset after
+			// failure. Chunks of a file of another size start from zero.
+			if offset := uploads.offset(target, total); start != offset {
+				writeUploadStatus(w, http.StatusConflict, target, offset)
+				return
+			}
+			if start == 0 {
+				uploads.discardParts(target, total)
+			}
+
+			offset, err := uploads.writeChunk(target, total, r.Body, end-start+1)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if offset < total {
+				writeUploadStatus(w, http.StatusAccepted, target, offset)
+				return
+			}
+
+			if err := uploads.complete(target, total); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeUploadStatus(w, http.StatusCreated, target, total)
+
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		}
+	}
+}
+
+// uploadForm stores files of multipart form into folder. Files are streamed
+// to the storage one by one without buffering the whole form.
+func uploadForm(w http.ResponseWriter, r *http.Request, uploads *uploadStore, folder string) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	uploaded := []string{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		target, err := uploadTarget(folder, part.FileName())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if uploads.exists(target) || !uploads.begin(target) {
+			writeError(w, http.StatusConflict, target+" already exists")
+			return
+		}
+		err = uploads.write(target, http.MaxBytesReader(w, part, uploads.maxSize))
+		uploads.end(target)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		uploaded = append(uploaded, target)
+	}
+
+	if len(uploaded) == 0 {
+		writeError(w, http.StatusBadRequest, "no files uploaded")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, map[string]any{"uploaded": uploaded})
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %s", formatSize(tooLarge.Limit)))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+type UploadPage struct {
+	Title     string
+	Folder    string
+	BackLink  string
+	URLPrefix string
+	MaxSize   int64
+	Styles    template.CSS
+	JS        template.JS
+}
+
+// makeUploadPageHandler renders drag and drop upload page of a folder
+func makeUploadPageHandler(uploads *uploadStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		folder := strings.Trim(strings.TrimPrefix(r.URL.Path, urlPrefix+"/upload"), "/")
+		if folder == "" {
+			folder = "."
+		}
+		if !fs.ValidPath(folder) || isVirtualPath(folder) || isTrashPath(folder) {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+
+		backLink := urlPrefix
+		if folder != "." {
+			backLink = urlPrefix + "/" + folder
+		}
+
+		page := UploadPage{
+			Title:     "Upload to " + folder,
+			Folder:    folder,
+			BackLink:  backLink,
+			URLPrefix: urlPrefix,
+			MaxSize:   uploads.maxSize,
+			Styles:    template.CSS(append(uploadCss, globalCss...)),
+			JS:        template.JS(uploadJs),
+		}
+
+		err := tmpl.ExecuteTemplate(w, "upload.html", page)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newTestUploads returns upload store writing into a temporary local gallery
+func newTestUploads(t *testing.T) (*uploadStore, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "kif", "2023"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "kif", "2023", "post_1700000000_0.jpg"), []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fSys, readFile, sizeFn, _, storage := localFS(root)
+	index := newMediaIndex(fSys, readFile, sizeFn)
+	index.readExif = false
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := newUploadStore(filepath.Join(t.TempDir(), "uploads"), 100, fSys, storage, index)
+	if err := uploads.load(); err != nil {
+		t.Fatal(err)
+	}
+	return uploads, root
+}
+
+func putChunk(handler http.HandlerFunc, p string, contentRange string, body string) (*httptest.ResponseRecorder, int64) {
+	req := httptest.NewRequest("PUT", urlPrefix+"/api/upload/"+p, strings.NewReader(body))
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	var status struct {
+		Offset int64 `json:"offset"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	return rr, status.Offset
+}
+
+func TestUploadChunked(t *testing.T) {
+	uploads, root := newTestUploads(t)
+	handler := makeUploadApiHandler(uploads)
+	p := "kif/2024/post_1710000000_0.jpg"
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", urlPrefix+"/api/upload/"+p+"?size=12", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"offset":0`) {
+		t.Fatalf("expected new upload to start from 0, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr, offset := putChunk(handler, p, "bytes 0-4/12", "hello"); rr.Code != http.StatusAccepted || offset != 5 {
+		t.Fatalf("expected first chunk to be accepted, got %d offset %d", rr.Code, offset)
+	}
+	// Repeated chunk after lost response tells where to continue
+	if rr, offset := putChunk(handler, p, "bytes 0-4/12", "hello"); rr.Code != http.StatusConflict || offset != 5 {
+		t.Fatalf("expected chunk at wrong offset to be rejected, got %d offset %d", rr.Code, offset)
+	}
+	if _, err := os.Stat(filepath.Join(root, p)); err == nil {
+		t.Fatal("expected incomplete upload not to be in the gallery")
+	}
+
+	if rr, offset := putChunk(handler, p, "bytes 5-11/12", " world!"); rr.Code != http.StatusCreated || offset != 12 {
+		t.Fatalf("expected last chunk to complete upload, got %d offset %d", rr.Code, offset)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, p))
+	if err != nil || string(data) != "hello world!" {
+		t.Errorf("unexpected uploaded file %q: %v", data, err)
+	}
+	if _, ok := uploads.index.get(p); !ok {
+		t.Error("expected uploaded media to be indexed")
+	}
+	if uploads.offset(p, 12) != 0 {
+		t.Error("expected received chunks to be removed")
+	}
+
+	if rr, _ := putChunk(handler, p, "", "again"); rr.Code != http.StatusConflict {
+		t.Errorf("expected upload over existing media to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestUploadChunkedOtherFile(t *testing.T) {
+	uploads, root := newTestUploads(t)
+	handler := makeUploadApiHandler(uploads)
+	p := "kif/2024/post_1710000000_0.jpg"
+
+	// Abandoned upload of another file with the same name
+	if rr, _ := putChunk(handler, p, "bytes 0-4/12", "stale"); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected first chunk to be accepted, got %d", rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", urlPrefix+"/api/upload/"+p+"?size=8", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"offset":0`) {
+		t.Fatalf("expected file of another size to start from 0, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, offset := putChunk(handler, p, "bytes 5-7/8", "new"); rr.Code != http.StatusConflict || offset != 0 {
+		t.Fatalf("expected chunk of another size not to continue old upload, got %d offset %d", rr.Code, offset)
+	}
+
+	if rr, _ := putChunk(handler, p, "bytes 0-4/8", "fresh"); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected new upload to start, got %d", rr.Code)
+	}
+	if uploads.offset(p, 12) != 0 {
+		t.Error("expected chunks of the abandoned upload to be removed")
+	}
+	if rr, _ := putChunk(handler, p, "bytes 5-7/8", "new"); rr.Code != http.StatusCreated {
+		t.Fatalf("expected last chunk to complete upload, got %d", rr.Code)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, p)); string(data) != "freshnew" {
+		t.Errorf("unexpected uploaded file %q", data)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", urlPrefix+"/api/upload/"+p+"?size=x", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid size to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestUploadWhole(t *testing.T) {
+	uploads, root := newTestUploads(t)
+	handler := makeUploadApiHandler(uploads)
+
+	if rr, _ := putChunk(handler, "reel_1710000000_0.mp4", "", "video"); rr.Code != http.StatusCreated {
+		t.Fatalf("expected upload into gallery root, got %d", rr.Code)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "reel_1710000000_0.mp4")); string(data) != "video" {
+		t.Errorf("unexpected uploaded file %q", data)
+	}
+
+	if rr, _ := putChunk(handler, "kif/large.jpg", "", strings.Repeat("x", 101)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected too large file to be rejected, got %d", rr.Code)
+	}
+	if rr, _ := putChunk(handler, "kif/large.jpg", "bytes 0-9/101", strings.Repeat("x", 10)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected too large chunked file to be rejected, got %d", rr.Code)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "kif")); len(entries) != 1 {
+		t.Errorf("expected failed upload to leave no files, got %d entries", len(entries))
+	}
+}
+
+func TestUploadForm(t *testing.T) {
+	uploads, root := newTestUploads(t)
+	handler := makeUploadApiHandler(uploads)
+
+	upload := func(files map[string]string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for name, content := range files {
+			fw, _ := mw.CreateFormFile("file", name)
+			fw.Write([]byte(content))
+		}
+		mw.Close()
+
+		req := httptest.NewRequest("POST", urlPrefix+"/api/upload/snay/2023", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := upload(map[string]string{"post_1710000000_0.jpg": "a", "post_1710000000_1.png": "b"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected files to be uploaded, got %d %s", rr.Code, rr.Body.String())
+	}
+	for _, name := range []string{"post_1710000000_0.jpg", "post_1710000000_1.png"} {
+		if _, err := os.Stat(filepath.Join(root, "snay", "2023", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if got := len(uploads.index.all("snay")); got != 2 {
+		t.Errorf("expected 2 indexed media in snay, got %d", got)
+	}
+
+	if rr := upload(map[string]string{"notes.txt": "c"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unsupported file to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestUploadTarget(t *testing.T) {
+	tests := []struct {
+		folder   string
+		name     string
+		expected string
+	}{
+		{"kif/2023", "post_1700000000_0.jpg", "kif/2023/post_1700000000_0.jpg"},
+		{"/kif/", "clip.MOV", "kif/clip.MOV"},
+		{".", "post_1700000000_0.jpg", "post_1700000000_0.jpg"},
+		{"", "post_1700000000_0.jpg", "post_1700000000_0.jpg"},
+		{"kif/../..", "post_1700000000_0.jpg", ""},
+		{"kif", "../post_1700000000_0.jpg", ""},
+		{"kif", ".hidden.jpg", ""},
+		{"kif", "notes.txt", ""},
+		{"kif", "post.jpg.json", ""},
+		{albumsFolder + "/trip", "post_1700000000_0.jpg", ""},
+		{favoritesFolder, "post_1700000000_0.jpg", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := uploadTarget(tt.folder, tt.name)
+		if tt.expected == "" && err == nil {
+			t.Errorf("uploadTarget(%q, %q) = %q, expected error", tt.folder, tt.name, got)
+		}
+		if tt.expected != "" && got != tt.expected {
+			t.Errorf("uploadTarget(%q, %q) = %q, %v, expected %q", tt.folder, tt.name, got, err, tt.expected)
+		}
+	}
+}
+
+func TestUploadLoadRemovesStale(t *testing.T) {
+	uploads, _ := newTestUploads(t)
+
+	stale := uploads.partFile("kif/old.jpg", 4)
+	fresh := uploads.partFile("kif/new.jpg", 4)
+	for _, f := range []string{stale, fresh} {
+		if err := os.WriteFile(f, []byte("part"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-uploadTTL - time.Hour)
+	os.Chtimes(stale, old, old)
+
+	if err := uploads.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); err == nil {
+		t.Error("expected stale upload to be removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("expected recent upload to be kept")
+	}
+}
+
+func TestShadowedFolders(t *testing.T) {
+	mockFs := fstest.MapFS{
+		"albums/post_1700000000_0.jpg": &fstest.MapFile{},
+		"favorites":                    &fstest.MapFile{},
+		"kif/post_1700000000_0.jpg":    &fstest.MapFile{},
+	}
+	if got := shadowedFolders(mockFs); !reflect.DeepEqual(got, []string{albumsFolder}) {
+		t.Errorf("shadowedFolders() = %v, expected [%s]", got, albumsFolder)
+	}
+
+	sizeFn := func(string) int64 { return 0 }
+	index := newMediaIndex(mockFs, func(string) ([]byte, error) { return nil, nil }, sizeFn)
+	if err := index.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	makeGalleryRootHandler(mockFs, sizeFn, index, nil, nil)(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, urlPrefix+"/kif?") || strings.Contains(body, urlPrefix+"/albums?") {
+		t.Errorf("root listing should show kif and hide the shadowed albums folder")
+	}
+}
@@ -0,0 +1,19 @@
+// Dashboard buttons carry URL of the action they post to
+const dashboardButtons = document.querySelectorAll(".dashboard-action");
+
+dashboardButtons.forEach((b) => {
+    b.addEventListener("click", () => {
+        b.disabled = true;
+        fetch(b.dataset.url, { method: "POST" })
+            .then(async (res) => {
+                if (!res.ok) {
+                    throw new Error(await res.text());
+                }
+                location.reload();
+            })
+            .catch((err) => {
+                b.disabled = false;
+                alert(b.textContent + " failed: " + err.message);
+            });
+    });
+});
@@ -0,0 +1,58 @@
+// Favorite toggle buttons have "fav" class and carry media storage path and API URL
+const favButtons = document.querySelectorAll(".fav");
+
+function setFavorite(button, favorite) {
+    button.classList.toggle("active", favorite);
+    button.title = favorite ? "Remove from favorites" : "Add to favorites";
+}
+
+// Mark buttons of media that is already favorite
+function loadFavorites() {
+    if (favButtons.length === 0) {
+        return;
+    }
+
+    fetch(favButtons[0].dataset.api)
+        .then((res) => res.json())
+        .then((favorites) => {
+            const paths = new Set(favorites.map((f) => f.path));
+            favButtons.forEach((b) => setFavorite(b, paths.has(b.dataset.path)));
+        })
+        .catch((err) => console.error("failed to load favorites", err));
+}
+
+function toggleFavorite(button) {
+    const body = new URLSearchParams();
+    body.set("path", button.dataset.path);
+    body.set("favorite", !button.classList.contains("active"));
+
+    fetch(button.dataset.api, { method: "POST", body: body })
+        .then((res) => {
+            if (!res.ok) {
+                throw new Error(res.statusText);
+            }
+            return res.json();
+        })
+        .then((res) => setFavorite(button, res.favorite))
+        .catch((err) => console.error("failed to update favorite", err));
+}
+
+favButtons.forEach((b) => {
+    b.addEventListener("click", (e) => {
+        // Buttons in the grid are inside of media links
+        e.preventDefault();
+        e.stopPropagation();
+        toggleFavorite(b);
+    });
+});
+
+document.addEventListener("keydown", (e) => {
+    if (e.target.tagName === "INPUT") return
+
+    // Hotkey only makes sense in the player where there is a single button
+    if (e.key === "f" && favButtons.length === 1) {
+        toggleFavorite(favButtons[0]);
+    }
+});
+
+loadFavorites();
@@ -49,8 +49,10 @@ function updateFilter() {
     const searchParams = new URLSearchParams(decodeURI(window.location.search));
     const queryFilter = searchParams.get('filter')
 
-    if (queryFilter) {
-        document.getElementById("filter-input").value = queryFilter;
+    const filterInput = document.getElementById("filter-input");
+
+    if (queryFilter && filterInput) {
+        filterInput.value = queryFilter;
     }
 }
 
@@ -132,4 +134,4 @@ document.addEventListener("keydown", (e) => {
     }
 });
 
-document.getElementById("clear-filter").addEventListener("click", clearFilter);
+document.getElementById("clear-filter")?.addEventListener("click", clearFilter);
@@ -0,0 +1,52 @@
+// Move and delete buttons have "manage" class and carry storage path of media
+// or folder, API URL and where to go once it is deleted
+const manageButtons = document.querySelectorAll(".manage");
+
+function changeMedia(button, action, body) {
+    body.set("path", button.dataset.path);
+
+    return fetch(button.dataset.api + "/" + action, { method: "POST", body: body })
+        .then(async (res) => {
+            if (!res.ok) {
+                throw new Error(await res.text());
+            }
+            return res.json();
+        });
+}
+
+function moveMedia(button) {
+    const to = prompt("Move " + button.dataset.path + " to", button.dataset.path);
+    if (to === null || to.trim() === "" || to === button.dataset.path) {
+        return;
+    }
+
+    const body = new URLSearchParams();
+    body.set("to", to);
+    changeMedia(button, "move", body)
+        .then((res) => {
+            location.href = button.dataset.prefix + "/" + res.to.split("/").map(encodeURIComponent).join("/");
+        })
+        .catch((err) => alert("Failed to move " + button.dataset.path + ": " + err.message));
+}
+
+function deleteMedia(button) {
+    if (!confirm("Delete " + button.dataset.path + "? It can be restored from the trash.")) {
+        return;
+    }
+
+    changeMedia(button, "delete", new URLSearchParams())
+        .then(() => {
+            location.href = button.dataset.back;
+        })
+        .catch((err) => alert("Failed to delete " + button.dataset.path + ": " + err.message));
+}
+
+manageButtons.forEach((b) => {
+    b.addEventListener("click", () => {
+        if (b.dataset.action === "move") {
+            moveMedia(b);
+        } else if (b.dataset.action === "delete") {
+            deleteMedia(b);
+        }
+    });
+});
@@ -0,0 +1,83 @@
+// Selection mode picks media in the grid to download it as a single ZIP.
+// Selected paths are kept in session storage so media from several
+// folders can be collected before downloading.
+const selectToggle = document.getElementById("select-toggle");
+const selectionForm = document.getElementById("selection");
+const selectionKey = "ccg-selection";
+
+function loadSelection() {
+    try {
+        return new Set(JSON.parse(sessionStorage.getItem(selectionKey)) || []);
+    } catch {
+        return new Set();
+    }
+}
+
+let selection = loadSelection();
+let selecting = selection.size > 0;
+
+function saveSelection() {
+    sessionStorage.setItem(selectionKey, JSON.stringify([...selection]));
+}
+
+function renderSelection() {
+    document.body.classList.toggle("selecting", selecting);
+    selectToggle.textContent = selecting ? "Done" : "Select";
+    selectionForm.hidden = selection.size === 0;
+    document.getElementById("selection-count").textContent = selection.size;
+    document.querySelectorAll(".gallery a[data-path]").forEach((a) => {
+        a.classList.toggle("selected", selection.has(a.dataset.path));
+    });
+}
+
+function toggleSelected(p) {
+    if (selection.has(p)) {
+        selection.delete(p);
+    } else {
+        selection.add(p);
+    }
+    saveSelection();
+    renderSelection();
+}
+
+if (selectToggle && selectionForm) {
+    selectToggle.addEventListener("click", () => {
+        selecting = !selecting;
+        renderSelection();
+    });
+
+    document.querySelectorAll(".gallery a[data-path]").forEach((a) => {
+        a.addEventListener("click", (e) => {
+            if (!selecting) return;
+            e.preventDefault();
+            toggleSelected(a.dataset.path);
+        });
+    });
+
+    // Selected paths are sent as repeated "path" form values
+    selectionForm.addEventListener("submit", () => {
+        selectionForm.querySelectorAll("input[name=path]").forEach((i) => i.remove());
+        selection.forEach((p) => {
+            const input = document.createElement("input");
+            input.type = "hidden";
+            input.name = "path";
+            input.value = p;
+            selectionForm.appendChild(input);
+        });
+    });
+
+    document.getElementById("selection-clear").addEventListener("click", () => {
+        selection.clear();
+        saveSelection();
+        renderSelection();
+    });
+
+    document.addEventListener("keydown", (e) => {
+        if (e.key === "Escape" && selecting) {
+            selecting = false;
+            renderSelection();
+        }
+    });
+
+    renderSelection();
+}
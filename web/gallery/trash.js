@@ -0,0 +1,28 @@
+// Trash buttons have "trash-action" class and carry action, trash item ID and API URL
+const trashButtons = document.querySelectorAll(".trash-action");
+
+function trashAction(button) {
+    const action = button.dataset.action;
+    if (action === "purge" && !confirm("Permanently delete " + button.dataset.path + "?")) {
+        return;
+    }
+    if (action === "empty" && !confirm("Permanently delete everything in the trash?")) {
+        return;
+    }
+
+    const body = new URLSearchParams();
+    if (button.dataset.id) {
+        body.set("id", button.dataset.id);
+    }
+
+    fetch(button.dataset.api + "/" + action, { method: "POST", body: body })
+        .then(async (res) => {
+            if (!res.ok) {
+                throw new Error(await res.text());
+            }
+            location.reload();
+        })
+        .catch((err) => alert("Failed to " + action + ": " + err.message));
+}
+
+trashButtons.forEach((b) => b.addEventListener("click", () => trashAction(b)));
@@ -0,0 +1,112 @@
+// Files are sent in chunks with Content-Range header. When a chunk fails
+// the upload asks the server how much it has received and continues from there.
+const dropZone = document.getElementById("drop-zone");
+const uploadInput = document.getElementById("upload-input");
+const uploadList = document.getElementById("uploads");
+
+const chunkSize = 8 << 20;
+const maxRetries = 5;
+const maxSize = Number(dropZone.dataset.maxSize);
+
+function fileURL(file) {
+    return dropZone.dataset.api.replace(/\/\.$/, "") + "/" + encodeURIComponent(file.name);
+}
+
+// Received chunks are kept per file size, a file of another size starts over
+async function uploadedOffset(url, size) {
+    const res = await fetch(url + "?size=" + size);
+    if (!res.ok) {
+        throw new Error(await res.text());
+    }
+    return (await res.json()).offset;
+}
+
+async function uploadFile(file, setStatus) {
+    if (file.size > maxSize) {
+        throw new Error("file is too large");
+    }
+
+    const url = fileURL(file);
+
+    // Small files are sent at once
+    if (file.size <= chunkSize) {
+        const res = await fetch(url, { method: "PUT", body: file });
+        if (!res.ok) {
+            throw new Error(await res.text());
+        }
+        return;
+    }
+
+    let offset = await uploadedOffset(url, file.size);
+    let retries = 0;
+
+    while (true) {
+        setStatus(Math.floor((offset / file.size) * 100) + "%");
+
+        const end = Math.min(offset + chunkSize, file.size);
+        let res;
+        try {
+            res = await fetch(url, {
+                method: "PUT",
+                headers: { "Content-Range": `bytes ${offset}-${end - 1}/${file.size}` },
+                body: file.slice(offset, end),
+            });
+        } catch (err) {
+            // Connection failed, continue from what the server has
+            if (++retries > maxRetries) {
+                throw err;
+            }
+            await new Promise((resolve) => setTimeout(resolve, 1000 * retries));
+            offset = await uploadedOffset(url, file.size);
+            continue;
+        }
+
+        if (res.status === 201) {
+            return;
+        }
+        // Accepted chunk or chunk at wrong offset both report where to continue
+        if (res.status === 202 || (res.status === 409 && res.headers.get("Content-Type") === "application/json")) {
+            offset = (await res.json()).offset;
+            retries = 0;
+            continue;
+        }
+        throw new Error(await res.text());
+    }
+}
+
+async function uploadFiles(files) {
+    for (const file of files) {
+        const item = document.createElement("li");
+        const name = document.createElement("span");
+        const status = document.createElement("span");
+        name.textContent = file.name;
+        item.append(name, status);
+        uploadList.append(item);
+
+        try {
+            await uploadFile(file, (s) => (status.textContent = s));
+            status.textContent = "done";
+        } catch (err) {
+            status.textContent = err.message;
+            status.classList.add("error");
+        }
+    }
+}
+
+uploadInput.addEventListener("change", () => {
+    uploadFiles([...uploadInput.files]);
+    uploadInput.value = "";
+});
+
+dropZone.addEventListener("dragover", (e) => {
+    e.preventDefault();
+    dropZone.classList.add("over");
+});
+
+dropZone.addEventListener("dragleave", () => dropZone.classList.remove("over"));
+
+dropZone.addEventListener("drop", (e) => {
+    e.preventDefault();
+    dropZone.classList.remove("over");
+    uploadFiles([...e.dataTransfer.files]);
+});
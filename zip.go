@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ZIP record signatures and lengths, see APPNOTE.TXT
+const (
+	zipLocalHeaderSig    = 0x04034b50
+	zipDirHeaderSig      = 0x02014b50
+	zipDataDescriptorSig = 0x08074b50
+	zipDir64EndSig       = 0x06064b50
+	zipDir64LocatorSig   = 0x07064b50
+	zipDirEndSig         = 0x06054b50
+
+	zipLocalHeaderLen      = 30
+	zipDirHeaderLen        = 46
+	zipDataDescriptorLen   = 16
+	zipDataDescriptor64Len = 24
+	zipDir64EndLen         = 56
+	zipDir64LocatorLen     = 20
+	zipDirEndLen           = 22
+
+	zipVersion20 = 20
+	zipVersion45 = 45
+	// Sizes are written in data descriptor after file data
+	zipFlagDataDescriptor = 0x8
+	zipFlagUTF8           = 0x800
+
+	zipUint16Max = 1<<16 - 1
+	zipUint32Max = 1<<32 - 1
+)
+
+// crcCache keeps CRC32 of files written into archives, so resumed downloads
+// do not need to read every file again to write the central directory.
+// With a file set the cache is persisted, a Range request for the end of an
+// archive after restart does not read the whole archive first.
+type crcCache struct {
+	mu   sync.Mutex
+	file string
+	crcs map[string]crcCacheEntry
+	// CRCs were added since the cache was saved
+	dirty bool
+}
+
+type crcCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	CRC     uint32    `json:"crc"`
+}
+
+func newCRCCache() *crcCache {
+	return &crcCache{crcs: make(map[string]crcCacheEntry)}
+}
+
+// load reads persisted CRCs. Missing file means nothing was downloaded yet.
+func (c *crcCache) load() error {
+	if c.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading CRC cache %s: %w", c.file, err)
+	}
+
+	crcs := make(map[string]crcCacheEntry)
+	if err := json.Unmarshal(data, &crcs); err != nil {
+		return fmt.Errorf("error parsing CRC cache %s: %w", c.file, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crcs = crcs
+	return nil
+}
+
+// save writes CRCs added since the last save into a temporary file and
+// renames it over the old one
+func (c *crcCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == "" || !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.crcs)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), ".zip-crcs-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.file); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// CRC cache shared by all downloads
+var zipCRCs = newCRCCache()
+
+// Whether files that can not be found are left out of archives and listed
+// in errorsZipName entry instead of failing the download
+var zipErrorManifest = false
+
+// Name of archive entry listing files left out of the archive
+const zipErrorsName = "errors.txt"
+
+// Number of files read ahead of the one being written into archive and the
+// limit of their total size. Archives from S3 are dominated by round-trip
+// latency when objects are fetched one at a time.
+var (
+	zipPrefetch            = 8
+	zipPrefetchBytes int64 = 256 << 20
+)
+
+func (c *crcCache) get(p string, size int64, modTime time.Time) (uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.crcs[p]
+	if !ok || e.Size != size || !e.ModTime.Equal(modTime) {
+		return 0, false
+	}
+	return e.CRC, true
+}
+
+func (c *crcCache) set(p string, size int64, modTime time.Time, crc uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.crcs[p]; ok && e.Size == size && e.ModTime.Equal(modTime) && e.CRC == crc {
+		return
+	}
+	c.crcs[p] = crcCacheEntry{Size: size, ModTime: modTime, CRC: crc}
+	c.dirty = true
+}
+
+func (c *crcCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.crcs)
+}
+
+// remove forgets CRCs of files that were moved or deleted
+func (c *crcCache) remove(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range paths {
+		if _, ok := c.crcs[p]; ok {
+			delete(c.crcs, p)
+			c.dirty = true
+		}
+	}
+}
+
+// prune forgets CRCs of files that are gone from the storage listing or
+// changed since their CRC was computed, so the cache does not grow with
+// every file that was ever downloaded
+func (c *crcCache) prune(fSys fs.FS, sizeFn func(string) int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for p, e := range c.crcs {
+		info, err := fs.Stat(fSys, p)
+		if err != nil || info.IsDir() || sizeFn(p) != e.Size || !info.ModTime().Equal(e.ModTime) {
+			delete(c.crcs, p)
+			c.dirty = true
+		}
+	}
+}
+
+func (c *crcCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.crcs = make(map[string]crcCacheEntry)
+	c.dirty = true
+}
+
+// zipFile is an archive entry with its position in the archive
+type zipFile struct {
+	zipEntry
+	size    int64
+	modTime time.Time
+	// Offset of local file header
+	offset int64
+	// Content of entries generated by the server, nil for files from storage
+	data []byte
+}
+
+func (f zipFile) dataOffset() int64 {
+	return f.offset + zipLocalHeaderLen + int64(len(f.Name))
+}
+
+func (f zipFile) descriptorLen() int64 {
+	if f.size >= zipUint32Max {
+		return zipDataDescriptor64Len
+	}
+	return zipDataDescriptorLen
+}
+
+func (f zipFile) end() int64 {
+	return f.dataOffset() + f.size + f.descriptorLen()
+}
+
+// zipArchive is a ZIP archive in Store mode with layout computed up front
+// from file names and sizes, the same layout calculateZipSize describes.
+// Every byte of the archive can be produced without writing the ones
+// before it, so it implements io.ReadSeeker and can serve Range requests.
+// Only data descriptors and the central directory need file CRCs, they are
+// taken from the CRC cache or computed by reading the file.
+type zipArchive struct {
+	files    []zipFile
+	readFile readFileFunc
+	crcs     *crcCache
+
+	// Offset of central directory, everything from there on is the tail
+	dirOffset int64
+	size      int64
+	pos       int64
+
+	// Data of the last read file, reads are mostly sequential
+	cur     int
+	curData []byte
+	// Central directory and end records, generated on first read
+	tail []byte
+	// First error reading the archive, the response can not be completed after it
+	err error
+	// Reads files ahead of the one being written, nil to read them one by one
+	fetcher *zipPrefetcher
+}
+
+// statZipFiles looks up entries in storage before anything is sent, so
+// missing files can still be reported with an error status. Entries that
+// can not be found are returned as failed.
+func statZipFiles(entries []zipEntry, fSys fs.FS, sizeFn func(string) int64) ([]zipFile, []error) {
+	files := []zipFile{}
+	failed := []error{}
+	for _, e := range entries {
+		info, err := fs.Stat(fSys, e.Path)
+		if err == nil && info.IsDir() {
+			err = errors.New("is a folder")
+		}
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", e.Path, err))
+			continue
+		}
+		files = append(files, zipFile{zipEntry: e, size: sizeFn(e.Path), modTime: info.ModTime()})
+	}
+	return files, failed
+}
+
+// errorsZipFile returns archive entry listing files left out of the archive
+func errorsZipFile(failed []error) zipFile {
+	data := []byte{}
+	for _, err := range failed {
+		data = append(data, err.Error()+"\n"...)
+	}
+	return zipFile{zipEntry: zipEntry{Name: zipErrorsName}, size: int64(len(data)), data: data}
+}
+
+// newZipArchive lays out archive of files in the given order
+func newZipArchive(files []zipFile, readFile readFileFunc, crcs *crcCache) *zipArchive {
+	a := &zipArchive{readFile: readFile, crcs: crcs, cur: -1}
+
+	entries := []zipEntry{}
+	sizes := make(map[string]int64)
+	var offset int64
+	for _, f := range files {
+		f.offset = offset
+		a.files = append(a.files, f)
+		offset = f.end()
+
+		entries = append(entries, f.zipEntry)
+		sizes[f.Path] = f.size
+	}
+
+	a.dirOffset = offset
+	a.size = zipEntriesSize(entries, func(p string) int64 { return sizes[p] })
+
+	return a
+}
+
+// etag identifies archive content for If-Range, it changes when any file is
+// added, renamed or modified
+func (a *zipArchive) etag() string {
+	h := sha256.New()
+	for _, f := range a.files {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\n", f.Name, f.Path, f.size, f.modTime.UnixNano())
+		h.Write(f.data)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// modTime returns modification time of the newest file
+func (a *zipArchive) modTime() time.Time {
+	var t time.Time
+	for _, f := range a.files {
+		if f.modTime.After(t) {
+			t = f.modTime
+		}
+	}
+	return t
+}
+
+func (a *zipArchive) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += a.pos
+	case io.SeekEnd:
+		offset += a.size
+	default:
+		return 0, errors.New("zip: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("zip: negative position")
+	}
+	a.pos = offset
+	return offset, nil
+}
+
+// Read reads from the record the current position is in
+func (a *zipArchive) Read(p []byte) (int, error) {
+	if a.pos >= a.size {
+		return 0, io.EOF
+	}
+
+	var chunk []byte
+	var start int64
+	var err error
+
+	if a.pos >= a.dirOffset {
+		chunk, err = a.tailData()
+		start = a.dirOffset
+	} else {
+		// Last file starting at or before the position
+		i := sort.Search(len(a.files), func(i int) bool { return a.files[i].offset > a.pos }) - 1
+		f := a.files[i]
+
+		switch {
+		case a.pos < f.dataOffset():
+			chunk, start = a.localHeader(f), f.offset
+		case a.pos < f.dataOffset()+f.size:
+			chunk, err = a.fileData(i)
+			start = f.dataOffset()
+		default:
+			var crc uint32
+			crc, err = a.crc(i)
+			chunk, start = dataDescriptor(f, crc), f.dataOffset()+f.size
+		}
+	}
+	if err != nil {
+		a.err = err
+		return 0, err
+	}
+
+	n := copy(p, chunk[a.pos-start:])
+	a.pos += int64(n)
+	return n, nil
+}
+
+// fileData returns content of i-th file
+func (a *zipArchive) fileData(i int) ([]byte, error) {
+	if a.cur == i {
+		return a.curData, nil
+	}
+
+	f := a.files[i]
+	if f.data != nil {
+		return f.data, nil
+	}
+
+	var data []byte
+	var err error
+	if a.fetcher != nil {
+		data, err = a.fetcher.get(i)
+	} else {
+		data, err = a.readFile(f.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", f.Path, err)
+	}
+	// Archive layout is computed from sizes, file changed since then
+	if int64(len(data)) != f.size {
+		return nil, fmt.Errorf("size of %s changed from %d to %d bytes", f.Path, f.size, len(data))
+	}
+
+	a.cur, a.curData = i, data
+	a.crcs.set(f.Path, f.size, f.modTime, crc32.ChecksumIEEE(data))
+
+	return data, nil
+}
+
+// crc returns CRC32 of i-th file, reading the file if it is not cached
+func (a *zipArchive) crc(i int) (uint32, error) {
+	f := a.files[i]
+	if f.data != nil {
+		return crc32.ChecksumIEEE(f.data), nil
+	}
+	if crc, ok := a.crcs.get(f.Path, f.size, f.modTime); ok {
+		return crc, nil
+	}
+
+	data, err := a.fileData(i)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(data), nil
+}
+
+// msDosTime converts modification time to MS-DOS date and time fields.
+// UTC is used so the archive does not depend on server time zone.
+func msDosTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		return 0, 0
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return tm, date
+}
+
+func zipFlags(name string) uint16 {
+	flags := uint16(zipFlagDataDescriptor)
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			return flags | zipFlagUTF8
+		}
+	}
+	return flags
+}
+
+// localHeader returns local file header, CRC and sizes are in the data descriptor
+func (a *zipArchive) localHeader(f zipFile) []byte {
+	tm, date := msDosTime(f.modTime)
+
+	b := make([]byte, 0, zipLocalHeaderLen+len(f.Name))
+	b = binary.LittleEndian.AppendUint32(b, zipLocalHeaderSig)
+	b = binary.LittleEndian.AppendUint16(b, zipVersion20)
+	b = binary.LittleEndian.AppendUint16(b, zipFlags(f.Name))
+	b = binary.LittleEndian.AppendUint16(b, 0) // method: store
+	b = binary.LittleEndian.AppendUint16(b, tm)
+	b = binary.LittleEndian.AppendUint16(b, date)
+	b = binary.LittleEndian.AppendUint32(b, 0) // crc32
+	b = binary.LittleEndian.AppendUint32(b, 0) // compressed size
+	b = binary.LittleEndian.AppendUint32(b, 0) // uncompressed size
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(f.Name)))
+	b = binary.LittleEndian.AppendUint16(b, 0) // extra length
+	return append(b, f.Name...)
+}
+
+func dataDescriptor(f zipFile, crc uint32) []byte {
+	b := make([]byte, 0, f.descriptorLen())
+	b = binary.LittleEndian.AppendUint32(b, zipDataDescriptorSig)
+	b = binary.LittleEndian.AppendUint32(b, crc)
+	if f.size >= zipUint32Max {
+		b = binary.LittleEndian.AppendUint64(b, uint64(f.size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(f.size))
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, uint32(f.size))
+		b = binary.LittleEndian.AppendUint32(b, uint32(f.size))
+	}
+	return b
+}
+
+// tailData returns central directory and end of central directory records
+func (a *zipArchive) tailData() ([]byte, error) {
+	if a.tail != nil {
+		return a.tail, nil
+	}
+
+	b := []byte{}
+	usedZip64 := false
+	for i, f := range a.files {
+		crc, err := a.crc(i)
+		if err != nil {
+			return nil, err
+		}
+
+		// ZIP64 extra field holds only the values that do not fit
+		extra := []byte{}
+		if f.size >= zipUint32Max {
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(f.size))
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(f.size))
+		}
+		if f.offset >= zipUint32Max {
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(f.offset))
+		}
+		version := uint16(zipVersion20)
+		if len(extra) > 0 {
+			usedZip64 = true
+			version = zipVersion45
+			extra = append(binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, 0x0001), uint16(len(extra))), extra...)
+		}
+
+		tm, date := msDosTime(f.modTime)
+		b = binary.LittleEndian.AppendUint32(b, zipDirHeaderSig)
+		b = binary.LittleEndian.AppendUint16(b, zipVersion20) // version made by
+		b = binary.LittleEndian.AppendUint16(b, version)      // version needed to extract
+		b = binary.LittleEndian.AppendUint16(b, zipFlags(f.Name))
+		b = binary.LittleEndian.AppendUint16(b, 0) // method: store
+		b = binary.LittleEndian.AppendUint16(b, tm)
+		b = binary.LittleEndian.AppendUint16(b, date)
+		b = binary.LittleEndian.AppendUint32(b, crc)
+		b = binary.LittleEndian.AppendUint32(b, uint32(min(f.size, zipUint32Max)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(min(f.size, zipUint32Max)))
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(f.Name)))
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(extra)))
+		b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+		b = binary.LittleEndian.AppendUint16(b, 0) // disk number start
+		b = binary.LittleEndian.AppendUint16(b, 0) // internal attributes
+		b = binary.LittleEndian.AppendUint32(b, 0) // external attributes
+		b = binary.LittleEndian.AppendUint32(b, uint32(min(f.offset, zipUint32Max)))
+		b = append(b, f.Name...)
+		b = append(b, extra...)
+	}
+
+	records := uint64(len(a.files))
+	dirSize := uint64(len(b))
+	dirOffset := uint64(a.dirOffset)
+
+	if usedZip64 || records >= zipUint16Max || dirSize >= zipUint32Max || dirOffset >= zipUint32Max {
+		end := dirOffset + dirSize
+		b = binary.LittleEndian.AppendUint32(b, zipDir64EndSig)
+		b = binary.LittleEndian.AppendUint64(b, zipDir64EndLen-12) // length without signature and this field
+		b = binary.LittleEndian.AppendUint16(b, zipVersion45)      // version made by
+		b = binary.LittleEndian.AppendUint16(b, zipVersion45)      // version needed to extract
+		b = binary.LittleEndian.AppendUint32(b, 0)                 // this disk
+		b = binary.LittleEndian.AppendUint32(b, 0)                 // disk with central directory
+		b = binary.LittleEndian.AppendUint64(b, records)
+		b = binary.LittleEndian.AppendUint64(b, records)
+		b = binary.LittleEndian.AppendUint64(b, dirSize)
+		b = binary.LittleEndian.AppendUint64(b, dirOffset)
+
+		b = binary.LittleEndian.AppendUint32(b, zipDir64LocatorSig)
+		b = binary.LittleEndian.AppendUint32(b, 0) // disk with ZIP64 end record
+		b = binary.LittleEndian.AppendUint64(b, end)
+		b = binary.LittleEndian.AppendUint32(b, 1) // total disks
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, zipDirEndSig)
+	b = binary.LittleEndian.AppendUint16(b, 0) // this disk
+	b = binary.LittleEndian.AppendUint16(b, 0) // disk with central directory
+	b = binary.LittleEndian.AppendUint16(b, uint16(min(records, zipUint16Max)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(min(records, zipUint16Max)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(min(dirSize, zipUint32Max)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(min(dirOffset, zipUint32Max)))
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+
+	if int64(len(b)) != a.size-a.dirOffset {
+		return nil, fmt.Errorf("zip: central directory is %d bytes, expected %d", len(b), a.size-a.dirOffset)
+	}
+
+	a.tail = b
+	return b, nil
+}
+
+// prefetch makes archive read up to ahead files concurrently with the one
+// being written, as long as they take no more than maxBytes together with it.
+// Files are not read ahead anymore once ctx is done.
+func (a *zipArchive) prefetch(ctx context.Context, ahead int, maxBytes int64) {
+	a.fetcher = newZipPrefetcher(ctx, a.files, a.readFile, ahead, maxBytes)
+}
+
+// newZipPrefetcher returns nil when nothing is to be read ahead
+func newZipPrefetcher(ctx context.Context, files []zipFile, readFile readFileFunc, ahead int, maxBytes int64) *zipPrefetcher {
+	if ahead <= 0 {
+		return nil
+	}
+	return &zipPrefetcher{
+		ctx:      ctx,
+		readFile: readFile,
+		files:    files,
+		ahead:    ahead,
+		maxBytes: maxBytes,
+		fetches:  make(map[int]*zipFetch),
+	}
+}
+
+// zipPrefetcher reads archive files in the background and hands them out
+// in archive order
+type zipPrefetcher struct {
+	ctx      context.Context
+	readFile readFileFunc
+	files    []zipFile
+	ahead    int
+	maxBytes int64
+
+	mu      sync.Mutex
+	fetches map[int]*zipFetch
+	// Next file to start reading
+	next int
+	// Size of the file handed out last, the archive keeps it until the next one
+	curSize int64
+	// Size of the file being written and files being read, including reads
+	// that are not needed anymore but did not finish yet
+	bytes int64
+}
+
+type zipFetch struct {
+	done chan struct{}
+	size int64
+	data []byte
+	err  error
+
+	// Guarded by mu of the prefetcher
+	finished bool
+	// Reading jumped past the file, its size is released when the read ends
+	abandoned bool
+}
+
+// get returns content of i-th file and starts reading files after it
+func (p *zipPrefetcher) get(i int) ([]byte, error) {
+	p.mu.Lock()
+	f, ok := p.fetches[i]
+	if ok {
+		delete(p.fetches, i)
+	} else {
+		// Reading jumped to another part of the archive, files read ahead
+		// are not needed. Their reads finish in the background.
+		for _, abandoned := range p.fetches {
+			if abandoned.finished {
+				p.bytes -= abandoned.size
+			} else {
+				abandoned.abandoned = true
+			}
+		}
+		clear(p.fetches)
+		f = p.start(i)
+		p.next = i + 1
+	}
+	// The archive drops the previous file once it gets this one
+	p.bytes -= p.curSize
+	p.curSize = f.size
+
+	for p.ctx.Err() == nil && p.next < len(p.files) && len(p.fetches) < p.ahead {
+		// Generated entries like the error manifest are not in the storage
+		if p.files[p.next].data != nil {
+			p.next++
+			continue
+		}
+		size := p.files[p.next].size
+		if p.bytes+size > p.maxBytes {
+			break
+		}
+		p.fetches[p.next] = p.start(p.next)
+		p.next++
+	}
+	p.mu.Unlock()
+
+	<-f.done
+	return f.data, f.err
+}
+
+// start reads i-th file in the background, its size is counted until the
+// file is written or the read of abandoned file ends. Must be called with
+// mu held.
+func (p *zipPrefetcher) start(i int) *zipFetch {
+	if data := p.files[i].data; data != nil {
+		f := &zipFetch{done: make(chan struct{}), data: data, finished: true}
+		close(f.done)
+		return f
+	}
+
+	f := &zipFetch{done: make(chan struct{}), size: p.files[i].size}
+	p.bytes += f.size
+	go func() {
+		defer close(f.done)
+		// Client went away, nothing is written anymore
+		if err := p.ctx.Err(); err != nil {
+			f.err = err
+		} else {
+			f.data, f.err = p.readFile(p.files[i].Path)
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		f.finished = true
+		if f.abandoned {
+			p.bytes -= f.size
+			f.data = nil
+		}
+	}()
+	return f
+}
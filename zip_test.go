@@ -0,0 +1,635 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestZipFS() fstest.MapFS {
+	modTime := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	return fstest.MapFS{
+		"kif/2023/post_1700000000_0.jpg":  &fstest.MapFile{Data: []byte("kif 2023"), ModTime: modTime},
+		"kif/2023/post_1700000000_1.jpg":  &fstest.MapFile{Data: []byte{}, ModTime: modTime},
+		"kif/2023/reel_1700000001_0.mp4":  &fstest.MapFile{Data: bytes.Repeat([]byte("reel"), 100), ModTime: modTime.Add(time.Hour)},
+		"snay/2023/пост_1700000002_0.jpg": &fstest.MapFile{Data: []byte("snay"), ModTime: modTime},
+	}
+}
+
+func testZipEntries() []zipEntry {
+	return []zipEntry{
+		{Name: "post_1700000000_0.jpg", Path: "kif/2023/post_1700000000_0.jpg"},
+		{Name: "post_1700000000_1.jpg", Path: "kif/2023/post_1700000000_1.jpg"},
+		{Name: "reel_1700000001_0.mp4", Path: "kif/2023/reel_1700000001_0.mp4"},
+		{Name: "пост_1700000002_0.jpg", Path: "snay/2023/пост_1700000002_0.jpg"},
+	}
+}
+
+func newTestZipArchive(mockFs fstest.MapFS, entries []zipEntry) *zipArchive {
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	files, failed := statZipFiles(entries, mockFs, sizeFn)
+	if len(failed) > 0 {
+		panic(failed[0])
+	}
+	return newZipArchive(files, readFile, newCRCCache())
+}
+
+func TestZipArchive(t *testing.T) {
+	mockFs := newTestZipFS()
+	entries := testZipEntries()
+	archive := newTestZipArchive(mockFs, entries)
+
+	data, err := io.ReadAll(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != archive.size {
+		t.Errorf("expected %d bytes, got %d", archive.size, len(data))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(entries) {
+		t.Fatalf("expected %d files, got %d", len(entries), len(zr.File))
+	}
+	for i, f := range zr.File {
+		if f.Name != entries[i].Name {
+			t.Errorf("expected name %q, got %q", entries[i].Name, f.Name)
+		}
+		expected := mockFs[entries[i].Path]
+		if !f.Modified.Equal(expected.ModTime) {
+			t.Errorf("%s: expected modification time %v, got %v", f.Name, expected.ModTime, f.Modified)
+		}
+
+		// Reading checks CRC from the data descriptor
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+		if !bytes.Equal(content, expected.Data) {
+			t.Errorf("%s: unexpected content %q", f.Name, content)
+		}
+	}
+}
+
+func TestZipArchive_ManyFiles(t *testing.T) {
+	mockFs := fstest.MapFS{}
+	entries := []zipEntry{}
+	for i := 0; i < 70000; i++ {
+		p := fmt.Sprintf("kif/post_%d_0.jpg", 1700000000+i)
+		mockFs[p] = &fstest.MapFile{Data: []byte("abc")}
+		entries = append(entries, zipEntry{Name: p, Path: p})
+	}
+
+	data, err := io.ReadAll(newTestZipArchive(mockFs, entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(entries) {
+		t.Errorf("expected %d files, got %d", len(entries), len(zr.File))
+	}
+}
+
+// Every slice of the archive is the same as in the full archive, even when
+// it is generated without reading anything before it
+func TestZipArchive_Seek(t *testing.T) {
+	mockFs := newTestZipFS()
+	entries := testZipEntries()
+
+	full, err := io.ReadAll(newTestZipArchive(mockFs, entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for start := 0; start < len(full); start++ {
+		archive := newTestZipArchive(mockFs, entries)
+		if _, err := archive.Seek(int64(start), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		part, err := io.ReadAll(archive)
+		if err != nil {
+			t.Fatalf("reading from %d: %v", start, err)
+		}
+		if !bytes.Equal(part, full[start:]) {
+			t.Fatalf("archive read from %d differs from full archive", start)
+		}
+	}
+}
+
+func TestZipArchive_ChangedFile(t *testing.T) {
+	mockFs := newTestZipFS()
+	archive := newTestZipArchive(mockFs, testZipEntries())
+	etag := archive.etag()
+
+	mockFs["kif/2023/post_1700000000_0.jpg"].Data = []byte("replaced with longer content")
+	if _, err := io.ReadAll(archive); err == nil {
+		t.Error("expected error reading file of changed size")
+	}
+
+	mockFs["kif/2023/post_1700000000_0.jpg"].ModTime = time.Now()
+	if newTestZipArchive(mockFs, testZipEntries()).etag() == etag {
+		t.Error("expected ETag to change with modified file")
+	}
+}
+
+func TestCRCCachePersist(t *testing.T) {
+	mockFs := newTestZipFS()
+	entries := testZipEntries()
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	files, _ := statZipFiles(entries, mockFs, sizeFn)
+	file := filepath.Join(t.TempDir(), "zip-crcs.json")
+
+	crcs := newCRCCache()
+	crcs.file = file
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	full, err := io.ReadAll(newZipArchive(files, readFile, crcs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crcs.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After restart the end of the archive is written without reading files
+	loaded := newCRCCache()
+	loaded.file = file
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.len() != len(entries) {
+		t.Errorf("expected %d CRCs loaded, got %d", len(entries), loaded.len())
+	}
+	failRead := func(p string) ([]byte, error) { return nil, fmt.Errorf("unexpected read of %s", p) }
+	archive := newZipArchive(files, failRead, loaded)
+	start := int64(len(full)) - 100
+	if _, err := archive.Seek(start, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tail, full[start:]) {
+		t.Error("expected archive end with loaded CRCs to be the same")
+	}
+
+	missing := newCRCCache()
+	missing.file = filepath.Join(t.TempDir(), "missing.json")
+	if err := missing.load(); err != nil {
+		t.Errorf("expected missing CRC cache to be empty, got %v", err)
+	}
+}
+
+func TestCRCCachePrune(t *testing.T) {
+	mockFs := newTestZipFS()
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	modTime := mockFs["kif/2023/post_1700000000_0.jpg"].ModTime
+
+	crcs := newCRCCache()
+	crcs.set("kif/2023/post_1700000000_0.jpg", 8, modTime, 1)
+	crcs.set("kif/2023/post_1700000000_1.jpg", 0, modTime, 2)
+	// Listed with a later modification time
+	crcs.set("kif/2023/reel_1700000001_0.mp4", 400, modTime, 3)
+	crcs.set("kif/2023/deleted.jpg", 5, modTime, 4)
+	crcs.set("kif/2023", 0, modTime, 5)
+	// Replaced with a file of other size
+	mockFs["kif/2023/post_1700000000_1.jpg"].Data = []byte("new")
+
+	crcs.prune(mockFs, sizeFn)
+
+	if _, ok := crcs.get("kif/2023/post_1700000000_0.jpg", 8, modTime); !ok {
+		t.Error("expected CRC of unchanged file to be kept")
+	}
+	if crcs.len() != 1 {
+		t.Errorf("expected CRCs of changed, deleted files and folders to be pruned, got %d", crcs.len())
+	}
+	if !crcs.dirty {
+		t.Error("expected pruned cache to be saved")
+	}
+
+	crcs.remove("kif/2023/post_1700000000_0.jpg")
+	if crcs.len() != 0 {
+		t.Error("expected removed CRC to be forgotten")
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	mockFs := newTestZipFS()
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	handler := makeDownloadHandler(mockFs, readFile, sizeFn, nil, nil)
+
+	download := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/gallery/download/kif/2023", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := download(nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected full download accepting ranges, got %d", rr.Code)
+	}
+	full := rr.Body.Bytes()
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag")
+	}
+
+	tests := []struct {
+		name         string
+		header       http.Header
+		expectedCode int
+		expected     []byte
+	}{
+		{"range", http.Header{"Range": {"bytes=40-99"}}, http.StatusPartialContent, full[40:100]},
+		{"resume", http.Header{"Range": {"bytes=100-"}, "If-Range": {etag}}, http.StatusPartialContent, full[100:]},
+		{"suffix", http.Header{"Range": {"bytes=-30"}}, http.StatusPartialContent, full[len(full)-30:]},
+		{"changed archive", http.Header{"Range": {"bytes=100-"}, "If-Range": {`"other"`}}, http.StatusOK, full},
+		{"unsatisfiable", http.Header{"Range": {fmt.Sprintf("bytes=%d-", len(full))}}, http.StatusRequestedRangeNotSatisfiable, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := download(tt.header)
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("expected status %d, got %d", tt.expectedCode, rr.Code)
+			}
+			if tt.expected != nil && !bytes.Equal(rr.Body.Bytes(), tt.expected) {
+				t.Errorf("response differs from the same bytes of the full archive")
+			}
+		})
+	}
+}
+
+// Central directory of archive over 4 GB matches the size calculated by zipEntriesSize
+func TestZipArchive_Zip64Directory(t *testing.T) {
+	mockFs := fstest.MapFS{}
+	sizes := map[string]int64{}
+	entries := []zipEntry{}
+	for i, size := range []int64{3, 5 << 30, 1 << 31, zipUint32Max, 3} {
+		p := fmt.Sprintf("kif/reel_%d_0.mp4", 1700000000+i)
+		mockFs[p] = &fstest.MapFile{}
+		sizes[p] = size
+		entries = append(entries, zipEntry{Name: p, Path: p})
+	}
+
+	// File data is never read when CRCs are known
+	crcs := newCRCCache()
+	for p, size := range sizes {
+		crcs.set(p, size, time.Time{}, 0)
+	}
+	readFile := func(p string) ([]byte, error) { return nil, fmt.Errorf("unexpected read of %s", p) }
+	files, _ := statZipFiles(entries, mockFs, func(p string) int64 { return sizes[p] })
+	archive := newZipArchive(files, readFile, crcs)
+
+	if _, err := archive.Seek(archive.dirOffset, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(tail)) != archive.size-archive.dirOffset {
+		t.Errorf("expected %d bytes of central directory, got %d", archive.size-archive.dirOffset, len(tail))
+	}
+}
+
+// 0xFFFFFFFF is the ZIP64 marker, a file of exactly that size needs ZIP64
+// sizes in the data descriptor and the central directory alike
+func TestZipArchive_Zip64Boundary(t *testing.T) {
+	tests := []struct {
+		name          string
+		size          int64
+		descriptorLen int64
+		zip64         bool
+	}{
+		{"below the marker", zipUint32Max - 1, zipDataDescriptorLen, false},
+		{"marker", zipUint32Max, zipDataDescriptor64Len, true},
+		{"above the marker", zipUint32Max + 1, zipDataDescriptor64Len, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := "kif/reel_1700000000_0.mp4"
+			mockFs := fstest.MapFS{p: &fstest.MapFile{}}
+			entries := []zipEntry{{Name: p, Path: p}}
+			sizeFn := func(string) int64 { return tt.size }
+			crcs := newCRCCache()
+			crcs.set(p, tt.size, time.Time{}, 0)
+			readFile := func(p string) ([]byte, error) { return nil, fmt.Errorf("unexpected read of %s", p) }
+			files, _ := statZipFiles(entries, mockFs, sizeFn)
+			archive := newZipArchive(files, readFile, crcs)
+
+			f := archive.files[0]
+			if got := f.descriptorLen(); got != tt.descriptorLen {
+				t.Errorf("expected %d byte data descriptor, got %d", tt.descriptorLen, got)
+			}
+			if got := int64(len(dataDescriptor(f, 0))); got != tt.descriptorLen {
+				t.Errorf("expected %d byte data descriptor written, got %d", tt.descriptorLen, got)
+			}
+			if archive.size != zipEntriesSize(entries, sizeFn) {
+				t.Errorf("expected archive of %d bytes, calculated %d", archive.size, zipEntriesSize(entries, sizeFn))
+			}
+
+			tail, err := archive.tailData()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Sizes of central directory entry and length of its extra field
+			compressed := binary.LittleEndian.Uint32(tail[20:])
+			extraLen := binary.LittleEndian.Uint16(tail[30:])
+			if zip64 := compressed == zipUint32Max && extraLen > 0; zip64 != tt.zip64 {
+				t.Errorf("expected ZIP64 central directory entry %v, got size %#x and %d bytes of extra", tt.zip64, compressed, extraLen)
+			}
+		})
+	}
+}
+
+func TestWriteZip_MissingFile(t *testing.T) {
+	mockFs := newTestZipFS()
+	readFile := func(p string) ([]byte, error) { return fs.ReadFile(mockFs, p) }
+	sizeFn := func(p string) int64 { return 5 }
+	entries := append(testZipEntries(), zipEntry{Name: "deleted.jpg", Path: "kif/2023/deleted.jpg"})
+
+	rr := httptest.NewRecorder()
+	writeArchive(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023", nil), "2023", entries, mockFs, readFile, sizeFn)
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Type") == "application/zip" {
+		t.Errorf("expected error before archive is sent, got %d", rr.Code)
+	}
+
+	zipErrorManifest = true
+	defer func() { zipErrorManifest = false }()
+
+	sizeFn = func(p string) int64 { return int64(len(mockFs[p].Data)) }
+	rr = httptest.NewRecorder()
+	writeArchive(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023", nil), "2023", entries, mockFs, readFile, sizeFn)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected archive with error manifest, got %d", rr.Code)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := zr.File[len(zr.File)-1]
+	if len(zr.File) != len(entries) || last.Name != zipErrorsName {
+		t.Fatalf("expected missing file to be replaced with %s", zipErrorsName)
+	}
+	rc, err := last.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	content, _ := io.ReadAll(rc)
+	if !bytes.HasPrefix(content, []byte("kif/2023/deleted.jpg: ")) {
+		t.Errorf("expected missing file in error manifest, got %q", content)
+	}
+}
+
+func TestWriteZip_ReadError(t *testing.T) {
+	mockFs := newTestZipFS()
+	readFile := func(p string) ([]byte, error) {
+		if p == "kif/2023/reel_1700000001_0.mp4" {
+			return nil, fmt.Errorf("connection reset")
+		}
+		return fs.ReadFile(mockFs, p)
+	}
+	sizeFn := func(p string) int64 { return int64(len(mockFs[p].Data)) }
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("expected download to be aborted, got %v", r)
+		}
+	}()
+
+	rr := httptest.NewRecorder()
+	writeArchive(rr, httptest.NewRequest("GET", "/gallery/download/kif/2023", nil), "2023", testZipEntries(), mockFs, readFile, sizeFn)
+	t.Error("expected panic")
+}
+
+// slowS3 is a fake S3 backend with round-trip latency that tracks how many
+// objects are fetched at the same time
+type slowS3 struct {
+	objects fstest.MapFS
+	latency time.Duration
+
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+	fetched int
+}
+
+func (s *slowS3) readFile(p string) ([]byte, error) {
+	s.mu.Lock()
+	s.active++
+	s.fetched++
+	s.maxSeen = max(s.maxSeen, s.active)
+	s.mu.Unlock()
+
+	time.Sleep(s.latency)
+
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+
+	return fs.ReadFile(s.objects, p)
+}
+
+func newSlowS3(files int, size int) (*slowS3, []zipEntry) {
+	s3 := &slowS3{objects: fstest.MapFS{}, latency: 10 * time.Millisecond}
+	entries := []zipEntry{}
+	for i := 0; i < files; i++ {
+		p := fmt.Sprintf("kif/2023/post_%d_0.jpg", 1700000000+i)
+		s3.objects[p] = &fstest.MapFile{Data: bytes.Repeat([]byte{byte(i)}, size)}
+		entries = append(entries, zipEntry{Name: path.Base(p), Path: p})
+	}
+	return s3, entries
+}
+
+func newSlowS3Archive(s3 *slowS3, entries []zipEntry, ahead int, maxBytes int64) *zipArchive {
+	sizeFn := func(p string) int64 { return int64(len(s3.objects[p].Data)) }
+	files, _ := statZipFiles(entries, s3.objects, sizeFn)
+	archive := newZipArchive(files, s3.readFile, newCRCCache())
+	archive.prefetch(context.Background(), ahead, maxBytes)
+	return archive
+}
+
+func TestZipPrefetch(t *testing.T) {
+	s3, entries := newSlowS3(40, 100)
+
+	sequential, err := io.ReadAll(newSlowS3Archive(s3, entries, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s3.maxSeen != 1 {
+		t.Errorf("expected files to be fetched one by one without prefetch, got %d at once", s3.maxSeen)
+	}
+
+	s3.maxSeen, s3.fetched = 0, 0
+	data, err := io.ReadAll(newSlowS3Archive(s3, entries, 8, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, sequential) {
+		t.Error("expected prefetched archive to be the same as sequential one")
+	}
+	if s3.maxSeen < 2 || s3.maxSeen > 9 {
+		t.Errorf("expected 2 to 9 concurrent fetches, got %d", s3.maxSeen)
+	}
+	if s3.fetched != len(entries) {
+		t.Errorf("expected every file to be fetched once, got %d fetches", s3.fetched)
+	}
+}
+
+func TestZipPrefetch_MemoryLimit(t *testing.T) {
+	s3, entries := newSlowS3(20, 100)
+
+	// Only two files fit into the limit, the one being written counts too
+	_, err := io.ReadAll(newSlowS3Archive(s3, entries, 8, 250))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s3.maxSeen > 2 {
+		t.Errorf("expected at most 2 concurrent fetches, got %d", s3.maxSeen)
+	}
+}
+
+func TestZipPrefetch_SeekMemoryLimit(t *testing.T) {
+	s3, entries := newSlowS3(20, 100)
+	full, err := io.ReadAll(newSlowS3Archive(s3, entries, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files read ahead before a seek keep their memory until their reads end
+	s3.maxSeen = 0
+	archive := newSlowS3Archive(s3, entries, 8, 250)
+	for _, start := range []int64{0, 1000, 2000, 500, 1500} {
+		if _, err := archive.Seek(start, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		part := make([]byte, 50)
+		if _, err := io.ReadFull(archive, part); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(part, full[start:start+50]) {
+			t.Errorf("archive read from %d differs from full archive", start)
+		}
+	}
+	if s3.maxSeen > 2 {
+		t.Errorf("expected at most 2 concurrent fetches, got %d", s3.maxSeen)
+	}
+
+	// Reads of abandoned files end in the background and release their memory
+	time.Sleep(5 * s3.latency)
+	archive.fetcher.mu.Lock()
+	defer archive.fetcher.mu.Unlock()
+	if archive.fetcher.bytes > 250 {
+		t.Errorf("expected at most 250 bytes counted, got %d", archive.fetcher.bytes)
+	}
+}
+
+func TestZipPrefetch_GeneratedEntry(t *testing.T) {
+	s3, entries := newSlowS3(5, 100)
+	s3.latency = 0
+	sizeFn := func(p string) int64 { return int64(len(s3.objects[p].Data)) }
+	files, _ := statZipFiles(entries, s3.objects, sizeFn)
+	files = append(files, errorsZipFile([]error{errors.New("kif/2023/deleted.jpg: file does not exist")}))
+
+	read := []string{}
+	var mu sync.Mutex
+	readFile := func(p string) ([]byte, error) {
+		mu.Lock()
+		read = append(read, p)
+		mu.Unlock()
+		return s3.readFile(p)
+	}
+	archive := newZipArchive(files, readFile, newCRCCache())
+	archive.prefetch(context.Background(), 8, 1<<20)
+	if _, err := io.ReadAll(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Error manifest is generated, it is not read from the storage
+	if len(read) != len(entries) || slices.Contains(read, "") {
+		t.Errorf("expected only storage files to be read, got %q", read)
+	}
+	if archive.fetcher.bytes > 100 {
+		t.Errorf("expected only the last file to be counted, got %d bytes", archive.fetcher.bytes)
+	}
+}
+
+func TestZipPrefetch_Cancel(t *testing.T) {
+	s3, entries := newSlowS3(20, 100)
+	sizeFn := func(p string) int64 { return int64(len(s3.objects[p].Data)) }
+	files, _ := statZipFiles(entries, s3.objects, sizeFn)
+	archive := newZipArchive(files, s3.readFile, newCRCCache())
+	ctx, cancel := context.WithCancel(context.Background())
+	archive.prefetch(ctx, 2, 1<<20)
+
+	if _, err := io.ReadFull(archive, make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := io.ReadAll(archive); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected read to be canceled, got %v", err)
+	}
+	if s3.fetched > 3 {
+		t.Errorf("expected no files to be fetched after cancel, got %d fetches", s3.fetched)
+	}
+}
+
+func TestZipPrefetch_Seek(t *testing.T) {
+	s3, entries := newSlowS3(10, 10)
+	s3.latency = 0
+
+	full, err := io.ReadAll(newSlowS3Archive(s3, entries, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := newSlowS3Archive(s3, entries, 4, 1<<20)
+	for _, start := range []int64{0, 300, 10, 250, int64(len(full)) - 5} {
+		if _, err := archive.Seek(start, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		part := make([]byte, 50)
+		n, err := io.ReadFull(archive, part)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(part[:n], full[start:start+int64(n)]) {
+			t.Errorf("archive read from %d differs from full archive", start)
+		}
+	}
+}